@@ -0,0 +1,97 @@
+// Command admin runs operational tasks against the service's dependencies:
+//
+//	go run ./cmd/admin topics            # report missing and drifted topics
+//	go run ./cmd/admin topics -create    # also create the missing topics
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/joho/godotenv"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/messaging"
+)
+
+var commands = map[string]func(cfg *config.Config, args []string) error{
+	// Kafka topics match the provisioning spec
+	"topics": topicsCommand,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	if err := godotenv.Load("deployments/.env"); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if err := command(cfg, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: admin <command> [flags]\ncommands: %v\n", names)
+	os.Exit(2)
+}
+
+func topicsCommand(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("topics", flag.ExitOnError)
+	create := flags.Bool("create", false, "create missing topics")
+	flags.Parse(args)
+
+	km, err := messaging.NewKafkaManager(&cfg.Kafka)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Kafka.Provisioning.Timeout)
+	defer cancel()
+
+	report, err := km.ProvisionTopics(ctx, *create)
+	if report != nil {
+		for _, topic := range report.Created {
+			fmt.Printf("created  %s\n", topic)
+		}
+		for _, topic := range report.Missing {
+			fmt.Printf("missing  %s\n", topic)
+		}
+		for _, drift := range report.Drift {
+			fmt.Printf("drift    %s\n", drift)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if !report.OK() {
+		return fmt.Errorf("%d missing, %d drifted", len(report.Missing), len(report.Drift))
+	}
+
+	fmt.Printf("ok       %d topics\n", len(messaging.TopicSpecs(&cfg.Kafka)))
+	return nil
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -12,7 +15,12 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 
+	"github.com/webbies/otel-fiber-demo/internal/adapters/consumers"
+	"github.com/webbies/otel-fiber-demo/internal/adapters/payments"
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/database"
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
@@ -41,68 +49,145 @@ func main() {
 	defer logger.Sync()
 
 	// Initialize telemetry
-	telemetry, err := observability.NewTelemetryManager(&cfg.Telemetry)
+	telemetry, err := observability.NewTelemetryManager(&cfg.Telemetry, cfg.Server.Environment)
 	if err != nil {
-		logger.Fatal("Failed to initialize telemetry", err)
+		logger.Fatal("Failed to initialize telemetry", zap.Error(err))
 	}
 	defer func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := telemetry.Shutdown(ctx); err != nil {
-			logger.Error("Failed to shutdown telemetry", err)
+			logger.Error("Failed to shutdown telemetry", zap.Error(err))
+		}
+	}()
+
+	// Send logs through the OTel logs pipeline as well, when enabled
+	telemetry.BridgeLogger(logger)
+
+	// Apply sampling changes from the config file without a restart
+	config.OnChange(func(updated *config.Config) {
+		if err := telemetry.UpdateSampling(&updated.Telemetry.Sampling); err != nil {
+			logger.Error("Failed to update trace sampling", zap.Error(err))
+			return
+		}
+		logger.Info("Updated trace sampling")
+	})
+
+	// Serve the Prometheus reader for scraping
+	metricsServer := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Telemetry.PrometheusPort),
+		Handler:           metricsMux(telemetry),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics server stopped", zap.Error(err))
 		}
 	}()
 
 	// Initialize business metrics
 	metrics, err := observability.NewBusinessMetrics(telemetry.Meter())
 	if err != nil {
-		logger.Fatal("Failed to initialize metrics", err)
+		logger.Fatal("Failed to initialize metrics", zap.Error(err))
 	}
 
 	// Initialize database connections
 	mongodb, err := database.NewMongoDB(&cfg.Database)
 	if err != nil {
-		logger.Fatal("Failed to connect to MongoDB", err)
+		logger.Fatal("Failed to connect to MongoDB", zap.Error(err))
 	}
 	defer func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := mongodb.Disconnect(ctx); err != nil {
-			logger.Error("Failed to disconnect from MongoDB", err)
+			logger.Error("Failed to disconnect from MongoDB", zap.Error(err))
 		}
 	}()
 
 	redis, err := database.NewRedis(&cfg.Redis)
 	if err != nil {
-		logger.Fatal("Failed to connect to Redis", err)
+		logger.Fatal("Failed to connect to Redis", zap.Error(err))
 	}
 	defer redis.Close()
 
 	// Initialize Kafka
-	kafkaManager := messaging.NewKafkaManager(&cfg.Kafka)
+	kafkaManager, err := messaging.NewKafkaManager(&cfg.Kafka)
+	if err != nil {
+		logger.Fatal("Failed to initialize Kafka", zap.Error(err))
+	}
+
+	// Create missing topics and report drift from the topic spec
+	if cfg.Kafka.Provisioning.Enabled {
+		provisionTopics(kafkaManager, &cfg.Kafka.Provisioning, logger)
+	}
 
 	// Initialize external clients
-	mtnPayClient := external.NewMTNPayClient(&cfg.External.MTNPay)
-	madapiClient := external.NewMADAPIClient(&cfg.External.MADAPI)
-	soaClient := external.NewSOAClient(&cfg.External.SOA)
+	mtnPayClient := external.NewMTNPayClient(&cfg.External.MTNPay, metrics, redis, redis)
+	madapiClient := external.NewMADAPIClient(&cfg.External.MADAPI, metrics, redis, redis, redis)
+	soaClient := external.NewSOAClient(&cfg.External.SOA, metrics, redis, redis)
+	if err := external.ObserveBreakers(telemetry.Meter(),
+		mtnPayClient.Breaker(), madapiClient.Breaker(), soaClient.Breaker()); err != nil {
+		logger.Fatal("Failed to observe circuit breakers", zap.Error(err))
+	}
 
 	// Create database indexes
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	if err := mongodb.CreateIndexes(ctx); err != nil {
-		logger.Error("Failed to create database indexes", err)
+		logger.Error("Failed to create database indexes", zap.Error(err))
+	}
+
+	// Start background event consumers
+	consumerRunner := kafkaManager.NewConsumerRunner(func(topic, groupID string, err error) {
+		logger.Error("Kafka consumer stopped, restarting",
+			zap.String("topic", topic),
+			zap.String("group_id", groupID),
+			zap.Error(err),
+		)
+	}, func(failure messaging.MessageFailure) {
+		fields := []zap.Field{
+			zap.String("topic", failure.Topic),
+			zap.Int("partition", failure.Partition),
+			zap.Int64("offset", failure.Offset),
+			zap.String("key", failure.Key),
+			zap.Int("attempt", failure.Attempt),
+			zap.Error(failure.Err),
+		}
+		switch failure.Outcome {
+		case messaging.FailureDeadLettered:
+			logger.Error("Kafka message failed, moved to the dead-letter topic",
+				append(fields, zap.String("dead_letter_topic", failure.DeadLetterTopic))...)
+		case messaging.FailureSkipped:
+			logger.Error("Kafka message failed and there is no dead-letter topic, skipped", fields...)
+		case messaging.FailureUncommitted:
+			logger.Warn("Kafka message failed during shutdown, left uncommitted", fields...)
+		default:
+			logger.Warn("Kafka message failed, retrying", fields...)
+		}
+	})
+	consumers.NewHandlers(mongodb, kafkaManager, soaClient, logger).Start(consumerRunner, &cfg.Kafka)
+
+	// Asynchronous payment results; callbacks cannot be verified without the
+	// shared secret, so the webhook stays off until it is set
+	settler := payments.NewSettler(mongodb, kafkaManager, metrics)
+	var mtnPayWebhook *payments.MTNPayWebhook
+	if cfg.External.MTNPay.Secret != "" {
+		mtnPayWebhook = payments.NewMTNPayWebhook(&cfg.External.MTNPay, redis, settler, logger)
+	} else {
+		logger.Warn("MTN_PAY_SECRET is not set, the MTN Pay webhook is disabled")
+	}
+
+	// Resolve payments left pending when the provider's answer was lost
+	reconciler := payments.NewReconciler(&cfg.Reconciliation, mongodb, redis, mtnPayClient, settler, metrics, logger)
+	if cfg.Reconciliation.Enabled {
+		reconciler.Start()
 	}
 
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:      cfg.Telemetry.ServiceName,
 		ServerHeader: "Fiber",
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			// Custom error handling with tracing
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": err.Error(),
-			})
-		},
+		ErrorHandler: middleware.ErrorResponse,
 	})
 
 	// Add middleware
@@ -113,20 +198,23 @@ func main() {
 	app.Use(middleware.RequestTracing(telemetry.Tracer()))
 	app.Use(middleware.RequestMetrics(metrics))
 	app.Use(middleware.RequestLogging(logger))
+	app.Use(middleware.ErrorHandler())
 	app.Use(middleware.RateLimit(redis, &cfg.RateLimit))
 
 	// Create dependencies container
 	deps := &Dependencies{
-		Config:       cfg,
-		Logger:       logger,
-		Telemetry:    telemetry,
-		Metrics:      metrics,
-		MongoDB:      mongodb,
-		Redis:        redis,
-		KafkaManager: kafkaManager,
-		MTNPayClient: mtnPayClient,
-		MADAPIClient: madapiClient,
-		SOAClient:    soaClient,
+		Config:        cfg,
+		Logger:        logger,
+		Telemetry:     telemetry,
+		Metrics:       metrics,
+		MongoDB:       mongodb,
+		Redis:         redis,
+		KafkaManager:  kafkaManager,
+		MTNPayClient:  mtnPayClient,
+		MADAPIClient:  madapiClient,
+		SOAClient:     soaClient,
+		MTNPayWebhook: mtnPayWebhook,
+		Payments:      payments.NewInitiator(mongodb, mtnPayClient),
 	}
 
 	// Setup routes
@@ -138,7 +226,7 @@ func main() {
 	// Graceful shutdown
 	go func() {
 		if err := app.Listen(":" + cfg.Server.Port); err != nil {
-			logger.Fatal("Failed to start server", err)
+			logger.Fatal("Failed to start server", zap.Error(err))
 		}
 	}()
 
@@ -154,28 +242,85 @@ func main() {
 	defer shutdownCancel()
 
 	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
-		logger.Fatal("Server forced to shutdown", err)
+		logger.Fatal("Server forced to shutdown", zap.Error(err))
+	}
+
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shutdown metrics server", zap.Error(err))
+	}
+
+	// Drain in-flight messages before the database connections close
+	if err := consumerRunner.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to drain Kafka consumers", zap.Error(err))
+	}
+
+	if err := reconciler.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to stop payment reconciliation", zap.Error(err))
 	}
 
 	logger.Info("Server exited")
 }
 
+func provisionTopics(km *messaging.KafkaManager, cfg *config.KafkaProvisioningConfig, logger *observability.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	report, err := km.ProvisionTopics(ctx, cfg.CreateMissing)
+	if err != nil {
+		if cfg.FailOnDrift {
+			logger.Fatal("Failed to provision Kafka topics", zap.Error(err))
+		}
+		logger.Error("Failed to provision Kafka topics", zap.Error(err))
+		if report == nil {
+			return
+		}
+	}
+
+	for _, topic := range report.Created {
+		logger.Info("Created Kafka topic", zap.String("topic", topic))
+	}
+	for _, topic := range report.Missing {
+		logger.Warn("Kafka topic is missing", zap.String("topic", topic))
+	}
+	for _, drift := range report.Drift {
+		logger.Warn("Kafka topic drifted from its spec",
+			zap.String("topic", drift.Topic),
+			zap.String("setting", drift.Setting),
+			zap.String("want", drift.Want),
+			zap.String("got", drift.Got),
+		)
+	}
+
+	if cfg.FailOnDrift && !report.OK() {
+		logger.Fatal("Kafka topics do not match their spec")
+	}
+}
+
+func metricsMux(telemetry *observability.TelemetryManager) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/metrics", telemetry.MetricsHandler())
+	return mux
+}
+
 type Dependencies struct {
-	Config       *config.Config
-	Logger       *observability.Logger
-	Telemetry    *observability.TelemetryManager
-	Metrics      *observability.BusinessMetrics
-	MongoDB      *database.MongoDB
-	Redis        *database.Redis
-	KafkaManager *messaging.KafkaManager
-	MTNPayClient *external.MTNPayClient
-	MADAPIClient *external.MADAPIClient
-	SOAClient    *external.SOAClient
+	Config        *config.Config
+	Logger        *observability.Logger
+	Telemetry     *observability.TelemetryManager
+	Metrics       *observability.BusinessMetrics
+	MongoDB       *database.MongoDB
+	Redis         *database.Redis
+	KafkaManager  *messaging.KafkaManager
+	MTNPayClient  *external.MTNPayClient
+	MADAPIClient  *external.MADAPIClient
+	SOAClient     *external.SOAClient
+	MTNPayWebhook *payments.MTNPayWebhook
+	Payments      *payments.Initiator
 }
 
 func setupRoutes(app *fiber.App, deps *Dependencies) {
 	// Health check endpoint
 	app.Get("/v1/health", healthHandler(deps))
+	app.Get("/v1/ready", readyHandler(deps))
 
 	// API v1 group
 	v1 := app.Group("/v1")
@@ -190,6 +335,11 @@ func setupRoutes(app *fiber.App, deps *Dependencies) {
 	v1.Post("/payments", createPaymentHandler(deps))
 	v1.Get("/payments/:id/status", getPaymentStatusHandler(deps))
 
+	// Provider callbacks
+	if deps.MTNPayWebhook != nil {
+		v1.Post("/webhooks/mtnpay", deps.MTNPayWebhook.Handle)
+	}
+
 	// Order endpoints
 	v1.Post("/orders", createOrderHandler(deps))
 	v1.Get("/orders/:id", getOrderHandler(deps))
@@ -223,6 +373,50 @@ func healthHandler(deps *Dependencies) fiber.Handler {
 	}
 }
 
+// readyHandler fails only when MongoDB or Redis is unreachable. An open
+// circuit breaker marks the service degraded but keeps it ready; taking pods
+// out of rotation because a provider is down would not bring it back.
+func readyHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), 2*time.Second)
+		defer cancel()
+
+		status, code := "ready", fiber.StatusOK
+		dependencies := fiber.Map{}
+		for name, check := range map[string]func(context.Context) error{
+			"mongodb": deps.MongoDB.IsConnected,
+			"redis":   deps.Redis.IsConnected,
+		} {
+			if err := check(ctx); err != nil {
+				dependencies[name] = err.Error()
+				status, code = "unavailable", fiber.StatusServiceUnavailable
+				continue
+			}
+			dependencies[name] = "up"
+		}
+
+		breakers := fiber.Map{}
+		for _, b := range []*external.CircuitBreaker{
+			deps.MTNPayClient.Breaker(),
+			deps.MADAPIClient.Breaker(),
+			deps.SOAClient.Breaker(),
+		} {
+			state := b.State()
+			breakers[b.Service()] = state.String()
+			if state != external.BreakerClosed && code == fiber.StatusOK {
+				status = "degraded"
+			}
+		}
+
+		return c.Status(code).JSON(fiber.Map{
+			"status":           status,
+			"dependencies":     dependencies,
+			"circuit_breakers": breakers,
+			"timestamp":        time.Now().UTC(),
+		})
+	}
+}
+
 // Placeholder handlers - will be implemented with proper business logic
 func createUserHandler(deps *Dependencies) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -236,9 +430,55 @@ func dashboardHandler(deps *Dependencies) fiber.Handler {
 	}
 }
 
+// createPaymentHandler starts an MTN Pay payment; its result arrives
+// asynchronously
 func createPaymentHandler(deps *Dependencies) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"message": "Payment creation endpoint - to be implemented"})
+		var req entities.CreatePaymentRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if req.Method != entities.PaymentMethodMTNPay {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "only mtn_pay payments are supported")
+		}
+		if req.Amount <= 0 || req.Currency == "" || req.PhoneNumber == "" {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "amount, currency and phone_number are required")
+		}
+		userID, err := primitive.ObjectIDFromHex(req.UserID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid user_id")
+		}
+		payment := &entities.Payment{
+			UserID:      userID,
+			Amount:      req.Amount,
+			Currency:    req.Currency,
+			Description: req.Description,
+			Metadata:    req.Metadata,
+		}
+		if req.OrderID != "" {
+			if payment.OrderID, err = primitive.ObjectIDFromHex(req.OrderID); err != nil {
+				return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid order_id")
+			}
+		}
+
+		if err := deps.Payments.Initiate(c.UserContext(), payment, req.PhoneNumber); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(entities.PaymentResponse{
+			ID:            payment.ID.Hex(),
+			UserID:        payment.UserID.Hex(),
+			OrderID:       req.OrderID,
+			Amount:        payment.Amount,
+			Currency:      payment.Currency,
+			Method:        payment.Method,
+			Status:        payment.Status,
+			ExternalTxnID: payment.ExternalTxnID,
+			Reference:     payment.Reference,
+			Description:   payment.Description,
+			Metadata:      payment.Metadata,
+			CreatedAt:     payment.CreatedAt,
+			UpdatedAt:     payment.UpdatedAt,
+		})
 	}
 }
 
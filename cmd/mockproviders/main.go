@@ -0,0 +1,88 @@
+// Command mockproviders serves local stand-ins for MTN Pay, MADAPI and SOA
+// so the service runs without the real providers:
+//
+//	go run ./cmd/mockproviders                        # happy path on :9000
+//	go run ./cmd/mockproviders -scenario async        # payments settle by callback
+//	go run ./cmd/mockproviders -scenario my.json      # scenario from a file
+//
+// Point the clients at it with MTN_PAY_BASE_URL=http://localhost:9000/mtnpay,
+// MADAPI_BASE_URL=http://localhost:9000/madapi and
+// SOA_BASE_URL=http://localhost:9000/soa. The scenario can be switched while
+// running: curl -X PUT 'localhost:9000/_scenario?name=flaky'
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/webbies/otel-fiber-demo/internal/mockproviders"
+)
+
+func main() {
+	if err := godotenv.Load("deployments/.env"); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	addr := flag.String("addr", ":9000", "listen address")
+	scenarioName := flag.String("scenario", "happy",
+		"built-in scenario ("+strings.Join(mockproviders.Scenarios(), ", ")+") or a JSON scenario file")
+	callbackURL := flag.String("callback-url", "http://localhost:3000/v1/webhooks/mtnpay",
+		"where MTN Pay payment results are posted; empty disables callbacks")
+	secret := flag.String("secret", os.Getenv("MTN_PAY_SECRET"),
+		"MTN Pay signing secret; requests must be signed and callbacks are signed with it when set")
+	seed := flag.Int64("seed", 0, "seed for scripted errors and ids; 0 seeds from the clock")
+	flag.Parse()
+
+	scenario, err := mockproviders.LoadScenario(*scenarioName)
+	if err != nil {
+		log.Fatalf("Failed to load scenario: %v", err)
+	}
+
+	logger := log.New(os.Stderr, "mockproviders: ", log.LstdFlags)
+	mock := mockproviders.New(mockproviders.Options{
+		Scenario:    scenario,
+		CallbackURL: *callbackURL,
+		Secret:      *secret,
+		Seed:        *seed,
+		Logger:      logger,
+	})
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           mock.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start mock providers: %v", err)
+		}
+	}()
+
+	logger.Printf("scenario %q: %s", scenario.Name, scenario.Description)
+	logger.Printf("listening on %s: %s, %s, %s", *addr,
+		mockproviders.MTNPayPrefix, mockproviders.MADAPIPrefix, mockproviders.SOAPrefix)
+	if *secret == "" {
+		logger.Print("no MTN Pay secret: requests are not verified and callbacks are unsigned")
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Printf("shutdown: %v", err)
+	}
+	mock.Close()
+}
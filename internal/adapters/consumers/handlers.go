@@ -0,0 +1,340 @@
+package consumers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/database"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/messaging"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
+)
+
+const (
+	// WelcomeBonusPoints are granted to every new user
+	WelcomeBonusPoints = 100
+	welcomeBonusTTL    = 90 * 24 * time.Hour
+)
+
+// Handlers reacts to domain events published on the Kafka topics
+type Handlers struct {
+	mongodb *database.MongoDB
+	kafka   *messaging.KafkaManager
+	soa     *external.SOAClient
+	logger  *observability.Logger
+	tracer  trace.Tracer
+}
+
+func NewHandlers(mongodb *database.MongoDB, kafka *messaging.KafkaManager, soa *external.SOAClient, logger *observability.Logger) *Handlers {
+	return &Handlers{
+		mongodb: mongodb,
+		kafka:   kafka,
+		soa:     soa,
+		logger:  logger,
+		tracer:  otel.Tracer("event-consumers"),
+	}
+}
+
+// Start registers every background consumer on runner, each under its own
+// consumer group
+func (h *Handlers) Start(runner *messaging.ConsumerRunner, cfg *config.KafkaConfig) {
+	payments := h.kafka.NewEventDispatcher()
+	messaging.HandleEvent(payments, h.OnPaymentProcessed)
+	runner.Start(cfg.Topics.Payments, cfg.Groups.PaymentSettlement, payments.MessageHandler())
+
+	orders := h.kafka.NewEventDispatcher()
+	messaging.HandleEvent(orders, h.OnOrderCreated)
+	runner.Start(cfg.Topics.Orders, cfg.Groups.StockAvailability, orders.MessageHandler())
+
+	users := h.kafka.NewEventDispatcher()
+	messaging.HandleEvent(users, h.OnUserCreated)
+	runner.Start(cfg.Topics.Users, cfg.Groups.WelcomeBonus, users.MessageHandler())
+}
+
+// OnPaymentProcessed confirms the order of a completed payment and issues the
+// purchase reward. When the order is no longer pending, e.g. cancelled for
+// lack of stock, no reward is issued and the payment is flagged for refund.
+func (h *Handlers) OnPaymentProcessed(ctx context.Context, env *messaging.Envelope, event *messaging.PaymentProcessedEvent) error {
+	ctx, span := h.tracer.Start(ctx, "consumer.payment_settlement",
+		trace.WithAttributes(
+			attribute.String("payment.id", event.PaymentID),
+			attribute.String("payment.status", event.Status),
+			attribute.String("order.id", event.OrderID),
+		),
+	)
+	defer span.End()
+
+	if event.Status != string(entities.PaymentStatusCompleted) {
+		span.SetAttributes(attribute.Bool("consumer.skipped", true))
+		return nil
+	}
+
+	if event.OrderID != "" {
+		confirmed, status, err := h.confirmOrder(ctx, event)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		if !confirmed {
+			span.SetAttributes(
+				attribute.Bool("order.confirmed", false),
+				attribute.String("order.status", string(status)),
+			)
+			if err := h.flagForRefund(ctx, event, status); err != nil {
+				span.RecordError(err)
+				return err
+			}
+			return nil
+		}
+	}
+
+	points := int64(math.Floor(event.Amount))
+	if points <= 0 {
+		return nil
+	}
+
+	reward := entities.Reward{
+		Type:        entities.RewardTypePoints,
+		Points:      points,
+		Status:      entities.RewardStatusActive,
+		Source:      entities.RewardSourcePurchase,
+		Reference:   event.PaymentID,
+		Description: "Purchase reward",
+	}
+	if err := h.issueReward(ctx, event.UserID, reward); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// confirmOrder moves the order of a completed payment from pending to
+// confirmed. It reports whether the order is confirmed by this payment,
+// which a redelivered event finds it already is, and the order's status.
+func (h *Handlers) confirmOrder(ctx context.Context, event *messaging.PaymentProcessedEvent) (bool, entities.OrderStatus, error) {
+	orderID, err := primitive.ObjectIDFromHex(event.OrderID)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid order id %q: %w", event.OrderID, err)
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":     entities.OrderStatusConfirmed,
+			"updated_at": time.Now().UTC(),
+		},
+	}
+	paymentID, err := primitive.ObjectIDFromHex(event.PaymentID)
+	if err == nil {
+		update["$set"].(bson.M)["payment_id"] = paymentID
+	}
+
+	// Only pending orders move to confirmed
+	result, err := h.mongodb.OrdersCollection().UpdateOne(ctx,
+		bson.M{"_id": orderID, "status": entities.OrderStatusPending},
+		update,
+	)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to confirm order: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		return true, entities.OrderStatusConfirmed, nil
+	}
+
+	var order entities.Order
+	if err := h.mongodb.OrdersCollection().FindOne(ctx, bson.M{"_id": orderID}).Decode(&order); err != nil {
+		return false, "", fmt.Errorf("failed to load order: %w", err)
+	}
+
+	switch order.Status {
+	case entities.OrderStatusPending, entities.OrderStatusCancelled, entities.OrderStatusRefunded:
+		return false, order.Status, nil
+	}
+	if !order.PaymentID.IsZero() && order.PaymentID != paymentID {
+		// Confirmed by another payment
+		return false, order.Status, nil
+	}
+	return true, order.Status, nil
+}
+
+// flagForRefund marks a completed payment whose order could not be
+// confirmed, so the captured amount can be refunded
+func (h *Handlers) flagForRefund(ctx context.Context, event *messaging.PaymentProcessedEvent, status entities.OrderStatus) error {
+	reason := fmt.Sprintf("order %s is %s", event.OrderID, status)
+	h.logger.WithTrace(ctx).Warn("Payment completed for an order that is not pending, flagging it for refund",
+		zap.String("order_id", event.OrderID),
+		zap.String("order_status", string(status)),
+		zap.String("payment_id", event.PaymentID),
+	)
+
+	paymentID, err := primitive.ObjectIDFromHex(event.PaymentID)
+	if err != nil {
+		return fmt.Errorf("invalid payment id %q: %w", event.PaymentID, err)
+	}
+	_, err = h.mongodb.PaymentsCollection().UpdateOne(ctx,
+		bson.M{"_id": paymentID},
+		bson.M{"$set": bson.M{
+			"metadata.refund_reason": reason,
+			"updated_at":             time.Now().UTC(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to flag payment for refund: %w", err)
+	}
+	return nil
+}
+
+// OnOrderCreated checks stock availability for every item of a new order and
+// cancels the order when an item is unavailable. Stock is not reserved, as
+// SOA has no reservation endpoint; orders racing for the last units can
+// both pass.
+func (h *Handlers) OnOrderCreated(ctx context.Context, env *messaging.Envelope, event *messaging.OrderCreatedEvent) error {
+	ctx, span := h.tracer.Start(ctx, "consumer.stock_availability",
+		trace.WithAttributes(
+			attribute.String("order.id", event.OrderID),
+			attribute.Int("order.item_count", event.ItemCount),
+		),
+	)
+	defer span.End()
+
+	orderID, err := primitive.ObjectIDFromHex(event.OrderID)
+	if err != nil {
+		err = fmt.Errorf("invalid order id %q: %w", event.OrderID, err)
+		span.RecordError(err)
+		return err
+	}
+
+	var order entities.Order
+	if err := h.mongodb.OrdersCollection().FindOne(ctx, bson.M{"_id": orderID}).Decode(&order); err != nil {
+		err = fmt.Errorf("failed to load order: %w", err)
+		span.RecordError(err)
+		return err
+	}
+
+	if order.Status != entities.OrderStatusPending {
+		span.SetAttributes(attribute.Bool("consumer.skipped", true))
+		return nil
+	}
+
+	reqs := make([]external.InventoryRequest, len(order.Items))
+	for i, item := range order.Items {
+		reqs[i] = external.InventoryRequest{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		}
+	}
+	stock, err := h.soa.CheckInventoryBatch(ctx, reqs)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to check stock availability: %w", err)
+	}
+
+	for i, inventory := range stock {
+		if !inventory.Available {
+			span.SetAttributes(
+				attribute.Bool("order.stock_available", false),
+				attribute.String("order.unavailable_product", reqs[i].ProductID),
+			)
+			return h.cancelOrder(ctx, orderID)
+		}
+	}
+
+	span.SetAttributes(attribute.Bool("order.stock_available", true))
+	return nil
+}
+
+func (h *Handlers) cancelOrder(ctx context.Context, orderID primitive.ObjectID) error {
+	_, err := h.mongodb.OrdersCollection().UpdateOne(ctx,
+		bson.M{"_id": orderID, "status": entities.OrderStatusPending},
+		bson.M{"$set": bson.M{
+			"status":     entities.OrderStatusCancelled,
+			"updated_at": time.Now().UTC(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cancel order: %w", err)
+	}
+	return nil
+}
+
+// OnUserCreated grants the welcome bonus to a new user
+func (h *Handlers) OnUserCreated(ctx context.Context, env *messaging.Envelope, event *messaging.UserCreatedEvent) error {
+	ctx, span := h.tracer.Start(ctx, "consumer.welcome_bonus",
+		trace.WithAttributes(
+			attribute.String("user.id", event.UserID),
+		),
+	)
+	defer span.End()
+
+	expiresAt := time.Now().UTC().Add(welcomeBonusTTL)
+	reward := entities.Reward{
+		Type:        entities.RewardTypeBonus,
+		Points:      WelcomeBonusPoints,
+		Status:      entities.RewardStatusActive,
+		Source:      entities.RewardSourceBonus,
+		Reference:   "welcome:" + event.UserID,
+		Description: "Welcome bonus",
+		ExpiresAt:   &expiresAt,
+	}
+	if err := h.issueReward(ctx, event.UserID, reward); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// issueReward stores reward once per source and reference and publishes
+// RewardProcessedEvent for it. A redelivered event finds the reward already
+// stored and publishes it again, in case an earlier attempt failed after
+// storing it; consumers of RewardProcessedEvent are idempotent.
+func (h *Handlers) issueReward(ctx context.Context, userID string, reward entities.Reward) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+
+	now := time.Now().UTC()
+	reward.UserID = oid
+	reward.CreatedAt = now
+	reward.UpdatedAt = now
+
+	filter := bson.M{"source": reward.Source, "reference": reward.Reference}
+	var stored entities.Reward
+	err = h.mongodb.RewardsCollection().FindOneAndUpdate(ctx,
+		filter,
+		bson.M{"$setOnInsert": reward},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent delivery inserted it first
+		err = h.mongodb.RewardsCollection().FindOne(ctx, filter).Decode(&stored)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store reward: %w", err)
+	}
+
+	return h.kafka.PublishRewardProcessed(ctx, messaging.RewardProcessedEvent{
+		RewardID:  stored.ID.Hex(),
+		UserID:    stored.UserID.Hex(),
+		Type:      string(stored.Type),
+		Points:    stored.Points,
+		Value:     stored.Value,
+		Currency:  stored.Currency,
+		Source:    string(stored.Source),
+		Timestamp: stored.CreatedAt,
+	})
+}
@@ -0,0 +1,99 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/database"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+// Initiator starts MTN Pay payments. Each payment stores the span context
+// it was created in, which the webhook and the reconciler link back to.
+type Initiator struct {
+	mongodb *database.MongoDB
+	mtnPay  *external.MTNPayClient
+}
+
+func NewInitiator(mongodb *database.MongoDB, mtnPay *external.MTNPayClient) *Initiator {
+	return &Initiator{mongodb: mongodb, mtnPay: mtnPay}
+}
+
+// Initiate stores payment as pending and asks MTN Pay to collect it from
+// phoneNumber, recording the provider's transaction id. The result arrives
+// on the webhook or is found by the reconciler; a payment the provider never
+// acknowledged stays pending until the reconciler expires it.
+func (i *Initiator) Initiate(ctx context.Context, payment *entities.Payment, phoneNumber string) error {
+	now := time.Now().UTC()
+	payment.ID = primitive.NewObjectID()
+	payment.Method = entities.PaymentMethodMTNPay
+	payment.Status = entities.PaymentStatusPending
+	if payment.Reference == "" {
+		payment.Reference = payment.ID.Hex()
+	}
+	payment.TraceContext = traceContext(ctx)
+	payment.CreatedAt = now
+	payment.UpdatedAt = now
+
+	if _, err := i.mongodb.PaymentsCollection().InsertOne(ctx, payment); err != nil {
+		return fmt.Errorf("failed to store payment: %w", err)
+	}
+
+	resp, err := i.mtnPay.ProcessPayment(ctx, external.MTNPayRequest{
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		PhoneNumber: phoneNumber,
+		Reference:   payment.Reference,
+		Description: payment.Description,
+		Metadata:    payment.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	updated := time.Now().UTC()
+	set := bson.M{"external_txn_id": resp.TransactionID, "updated_at": updated}
+	// Final results are left to the webhook and the reconciler, which
+	// publish them
+	if status, err := MTNPayStatus(resp.Status); err == nil && status == entities.PaymentStatusProcessing {
+		set["status"] = status
+		payment.Status = status
+	}
+	if _, err := i.mongodb.PaymentsCollection().UpdateOne(ctx,
+		bson.M{"_id": payment.ID, "status": entities.PaymentStatusPending},
+		bson.M{"$set": set},
+	); err != nil {
+		return fmt.Errorf("failed to record provider transaction: %w", err)
+	}
+	payment.ExternalTxnID = resp.TransactionID
+	payment.UpdatedAt = updated
+	return nil
+}
+
+// traceContext propagates the span context of ctx into a map stored with
+// the payment
+func traceContext(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// paymentSpanContext returns the span context stored with payment, if any
+func paymentSpanContext(payment *entities.Payment) trace.SpanContext {
+	if len(payment.TraceContext) == 0 {
+		return trace.SpanContext{}
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(payment.TraceContext))
+	return trace.SpanContextFromContext(ctx)
+}
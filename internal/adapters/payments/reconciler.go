@@ -0,0 +1,420 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/database"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
+)
+
+const reconciliationLockKey = "lock:payments:reconciliation"
+
+// Reconciliation outcomes, recorded on payments_reconciled_total
+const (
+	// outcomeSettled: the provider had a final result and it was applied
+	outcomeSettled = "settled"
+	// outcomeExpired: unknown to the provider after FailAfter, failed by
+	// the job
+	outcomeExpired = "expired"
+	// outcomePending: still open at the provider, checked again next run
+	outcomePending = "pending"
+	// outcomeOverdue: still open at the provider after FailAfter. It is
+	// not failed, as the provider may still complete it, but checked again
+	// and alerted on.
+	outcomeOverdue = "overdue"
+	// outcomeIgnored: the provider's status does not follow the payment's
+	outcomeIgnored = "ignored"
+	// outcomeError: the provider could not be asked, checked again next run
+	outcomeError = "error"
+)
+
+// Reconciliation run results, recorded on payments_reconciliation_runs_total
+const (
+	runCompleted = "completed"
+	runLocked    = "locked"
+	runFailed    = "failed"
+)
+
+// Report counts what one reconciliation run did
+type Report struct {
+	Scanned int
+	Settled int
+	Expired int
+	Pending int
+	Overdue int
+	Ignored int
+	Errors  int
+	// Aborted is set when the run stopped early, e.g. on an open breaker
+	Aborted bool
+}
+
+func (r *Report) add(outcome string) {
+	switch outcome {
+	case outcomeSettled:
+		r.Settled++
+	case outcomeExpired:
+		r.Expired++
+	case outcomePending:
+		r.Pending++
+	case outcomeOverdue:
+		r.Overdue++
+	case outcomeIgnored:
+		r.Ignored++
+	case outcomeError:
+		r.Errors++
+	}
+}
+
+// Reconciler periodically resolves MTN Pay payments left pending or
+// processing, e.g. after the client timed out waiting for the provider
+type Reconciler struct {
+	cfg     *config.ReconciliationConfig
+	mongodb *database.MongoDB
+	redis   *database.Redis
+	mtnPay  *external.MTNPayClient
+	settler *Settler
+	metrics *observability.BusinessMetrics
+	logger  *observability.Logger
+	tracer  trace.Tracer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReconciler(cfg *config.ReconciliationConfig, mongodb *database.MongoDB, redis *database.Redis, mtnPay *external.MTNPayClient, settler *Settler, metrics *observability.BusinessMetrics, logger *observability.Logger) *Reconciler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Reconciler{
+		cfg:     cfg,
+		mongodb: mongodb,
+		redis:   redis,
+		mtnPay:  mtnPay,
+		settler: settler,
+		metrics: metrics,
+		logger:  logger,
+		tracer:  otel.Tracer("payment-reconciliation"),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start runs the job every Interval until Shutdown
+func (r *Reconciler) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.RunOnce(r.ctx)
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops the job and waits for a running pass to stop (or for ctx
+// to expire)
+func (r *Reconciler) Shutdown(ctx context.Context) error {
+	r.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("reconciliation did not stop: %w", ctx.Err())
+	}
+}
+
+// RunOnce runs one pass if no other replica holds the lock. It reports
+// false when the pass was skipped.
+func (r *Reconciler) RunOnce(ctx context.Context) (Report, bool) {
+	// Each pass is its own trace
+	ctx, span := r.tracer.Start(ctx, "payments.reconcile", trace.WithNewRoot())
+	defer span.End()
+
+	unlock, ok, err := r.redis.TryLock(ctx, reconciliationLockKey, r.cfg.LockTTL)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		r.logger.WithTrace(ctx).Error("Failed to take the reconciliation lock", zap.Error(err))
+		r.recordRun(ctx, runFailed)
+		return Report{}, false
+	}
+	if !ok {
+		span.SetAttributes(attribute.Bool("reconciliation.locked", true))
+		r.recordRun(ctx, runLocked)
+		return Report{}, false
+	}
+	defer func() {
+		if err := unlock(context.WithoutCancel(ctx)); err != nil {
+			r.logger.WithTrace(ctx).Warn("Failed to release the reconciliation lock", zap.Error(err))
+		}
+	}()
+
+	// Finish before the lock can expire under us
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.LockTTL)
+	defer cancel()
+
+	report, err := r.reconcile(ctx)
+	span.SetAttributes(
+		attribute.Int("reconciliation.scanned", report.Scanned),
+		attribute.Int("reconciliation.settled", report.Settled),
+		attribute.Int("reconciliation.expired", report.Expired),
+		attribute.Int("reconciliation.pending", report.Pending),
+		attribute.Int("reconciliation.overdue", report.Overdue),
+		attribute.Int("reconciliation.ignored", report.Ignored),
+		attribute.Int("reconciliation.errors", report.Errors),
+		attribute.Bool("reconciliation.aborted", report.Aborted),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		r.logger.WithTrace(ctx).Error("Payment reconciliation failed", zap.Error(err))
+		r.recordRun(ctx, runFailed)
+		return report, true
+	}
+
+	r.recordRun(ctx, runCompleted)
+	if report.Scanned > 0 {
+		r.logger.WithTrace(ctx).Info("Payment reconciliation completed",
+			zap.Int("scanned", report.Scanned),
+			zap.Int("settled", report.Settled),
+			zap.Int("expired", report.Expired),
+			zap.Int("pending", report.Pending),
+			zap.Int("overdue", report.Overdue),
+			zap.Int("ignored", report.Ignored),
+			zap.Int("errors", report.Errors),
+			zap.Bool("aborted", report.Aborted),
+		)
+	}
+	return report, true
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) (Report, error) {
+	var report Report
+
+	cursor, err := r.mongodb.PaymentsCollection().Find(ctx,
+		bson.M{
+			"method":     entities.PaymentMethodMTNPay,
+			"status":     bson.M{"$in": []entities.PaymentStatus{entities.PaymentStatusPending, entities.PaymentStatusProcessing}},
+			"created_at": bson.M{"$lte": time.Now().UTC().Add(-r.cfg.MinAge)},
+		},
+		// Payments never checked first, then the longest unchecked, so that
+		// payments the provider keeps open cannot crowd out the rest
+		options.Find().
+			SetSort(bson.D{{Key: "reconciled_at", Value: 1}, {Key: "created_at", Value: 1}}).
+			SetLimit(int64(r.cfg.BatchSize)),
+	)
+	if err != nil {
+		return report, fmt.Errorf("failed to find stuck payments: %w", err)
+	}
+
+	var stuck []entities.Payment
+	if err := cursor.All(ctx, &stuck); err != nil {
+		return report, fmt.Errorf("failed to read stuck payments: %w", err)
+	}
+
+	// Paces the provider calls; the first waits one interval too
+	var tick <-chan time.Time
+	if r.cfg.RatePerSecond > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.cfg.RatePerSecond))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for i := range stuck {
+		if ctx.Err() != nil {
+			report.Aborted = true
+			break
+		}
+
+		outcome, err := r.reconcilePayment(ctx, &stuck[i], tick)
+		report.Scanned++
+		report.add(outcome)
+		if r.metrics != nil {
+			r.metrics.PaymentsReconciled.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+		}
+		if err != nil {
+			r.logger.WithTrace(ctx).Warn("Failed to reconcile payment",
+				zap.String("payment_id", stuck[i].ID.Hex()),
+				zap.String("external_txn_id", stuck[i].ExternalTxnID),
+				zap.Error(err),
+			)
+		}
+		if errors.Is(err, external.ErrCircuitOpen) || errors.Is(err, external.ErrRateLimited) || external.IsRateLimited(err) {
+			// The provider is down or throttling us, or we are at our own
+			// limit; leave the rest for a later run
+			report.Aborted = true
+			break
+		}
+	}
+
+	return report, nil
+}
+
+func (r *Reconciler) reconcilePayment(ctx context.Context, payment *entities.Payment, tick <-chan time.Time) (string, error) {
+	ctx, span := r.tracer.Start(ctx, "payments.reconcile_payment",
+		trace.WithAttributes(
+			attribute.String("payment.id", payment.ID.Hex()),
+			attribute.String("payment.status", string(payment.Status)),
+			attribute.String("payment.external_txn_id", payment.ExternalTxnID),
+		),
+	)
+	defer span.End()
+
+	if sc := paymentSpanContext(payment); sc.IsValid() {
+		span.AddLink(trace.Link{SpanContext: sc, Attributes: []attribute.KeyValue{
+			attribute.String("link.reason", "payment_created"),
+		}})
+	}
+
+	outcome, err := r.resolve(ctx, payment, tick)
+	span.SetAttributes(attribute.String("reconciliation.outcome", outcome))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if outcome == outcomePending || outcome == outcomeOverdue {
+		if err := r.markChecked(ctx, payment); err != nil {
+			span.RecordError(err)
+		}
+	}
+	return outcome, err
+}
+
+func (r *Reconciler) resolve(ctx context.Context, payment *entities.Payment, tick <-chan time.Time) (string, error) {
+	overdue := time.Since(payment.CreatedAt) >= r.cfg.FailAfter
+
+	// Without a transaction id the provider cannot be asked; the request
+	// never reached it or timed out before it answered
+	if payment.ExternalTxnID == "" {
+		if !overdue {
+			return outcomePending, nil
+		}
+		return r.expire(ctx, payment, "no provider transaction")
+	}
+
+	if tick != nil {
+		select {
+		case <-tick:
+		case <-ctx.Done():
+			return outcomeError, ctx.Err()
+		}
+	}
+
+	resp, err := r.mtnPay.GetPaymentStatus(ctx, payment.ExternalTxnID)
+	if external.IsNotFound(err) {
+		// The provider has no record of the transaction, e.g. it was
+		// rolled back; treated like a request that never reached it
+		if !overdue {
+			return outcomePending, nil
+		}
+		return r.expire(ctx, payment, "transaction unknown to the provider")
+	}
+	if err != nil {
+		return outcomeError, err
+	}
+	status, err := MTNPayStatus(resp.Status)
+	if err != nil {
+		return outcomeError, err
+	}
+
+	if !isFinal(status) {
+		if status != payment.Status {
+			// e.g. pending to processing; not final, nothing is published
+			if _, err := r.settler.Settle(ctx, payment, Update{
+				ExternalTxnID: payment.ExternalTxnID,
+				Status:        status,
+				Source:        "reconciliation",
+			}); err != nil {
+				return outcomeError, err
+			}
+		}
+		if overdue {
+			// Failing it here would drop a later success, so it is left to
+			// the provider and reported instead
+			r.logger.WithTrace(ctx).Warn("Payment still open at the provider past the fail-after age",
+				zap.String("payment_id", payment.ID.Hex()),
+				zap.String("external_txn_id", payment.ExternalTxnID),
+				zap.String("provider_status", resp.Status),
+				zap.Duration("age", time.Since(payment.CreatedAt)),
+			)
+			return outcomeOverdue, nil
+		}
+		return outcomePending, nil
+	}
+
+	result, err := r.settler.Settle(ctx, payment, Update{
+		ExternalTxnID: payment.ExternalTxnID,
+		Status:        status,
+		FailureReason: resp.FailureReason,
+		Source:        "reconciliation",
+	})
+	switch {
+	case err != nil:
+		return outcomeError, err
+	case result == ResultIgnored:
+		return outcomeIgnored, nil
+	}
+	return outcomeSettled, nil
+}
+
+func (r *Reconciler) expire(ctx context.Context, payment *entities.Payment, reason string) (string, error) {
+	if _, err := r.settler.Settle(ctx, payment, Update{
+		ExternalTxnID: payment.ExternalTxnID,
+		Status:        entities.PaymentStatusFailed,
+		FailureReason: "reconciliation timeout: " + reason,
+		Source:        "reconciliation",
+	}); err != nil {
+		return outcomeError, err
+	}
+	return outcomeExpired, nil
+}
+
+// markChecked records when payment was last checked, moving it to the back
+// of the next runs
+func (r *Reconciler) markChecked(ctx context.Context, payment *entities.Payment) error {
+	_, err := r.mongodb.PaymentsCollection().UpdateOne(ctx,
+		bson.M{"_id": payment.ID},
+		bson.M{"$set": bson.M{"reconciled_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record the reconciliation check: %w", err)
+	}
+	return nil
+}
+
+func (r *Reconciler) recordRun(ctx context.Context, result string) {
+	if r.metrics != nil {
+		r.metrics.ReconciliationRuns.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+	}
+}
@@ -0,0 +1,230 @@
+// Package payments starts MTN Pay payments and applies their asynchronous
+// results from the providers
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/database"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/messaging"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
+)
+
+var (
+	// ErrUnknownStatus is returned for a provider status with no mapping
+	ErrUnknownStatus = errors.New("unknown provider payment status")
+	// ErrPaymentNotFound is returned when no payment has the transaction id
+	ErrPaymentNotFound = errors.New("payment not found")
+	// ErrConcurrentUpdate is returned when the payment changed between
+	// reading and updating it
+	ErrConcurrentUpdate = errors.New("payment updated concurrently")
+)
+
+// mtnPayStatuses maps MTN Pay statuses, upper-cased, onto payment statuses
+var mtnPayStatuses = map[string]entities.PaymentStatus{
+	"CREATED":    entities.PaymentStatusPending,
+	"PENDING":    entities.PaymentStatusPending,
+	"ONGOING":    entities.PaymentStatusProcessing,
+	"PROCESSING": entities.PaymentStatusProcessing,
+	"SUCCESS":    entities.PaymentStatusCompleted,
+	"SUCCESSFUL": entities.PaymentStatusCompleted,
+	"COMPLETED":  entities.PaymentStatusCompleted,
+	"FAILED":     entities.PaymentStatusFailed,
+	"REJECTED":   entities.PaymentStatusFailed,
+	"TIMEOUT":    entities.PaymentStatusFailed,
+	"EXPIRED":    entities.PaymentStatusFailed,
+	"CANCELLED":  entities.PaymentStatusCancelled,
+	"CANCELED":   entities.PaymentStatusCancelled,
+	"REFUNDED":   entities.PaymentStatusRefunded,
+	"REVERSED":   entities.PaymentStatusRefunded,
+}
+
+// MTNPayStatus maps an MTN Pay status onto a payment status
+func MTNPayStatus(status string) (entities.PaymentStatus, error) {
+	if s, ok := mtnPayStatuses[strings.ToUpper(strings.TrimSpace(status))]; ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+}
+
+// transitions lists the statuses a payment may move to from each status.
+// Anything else, e.g. a late "pending" after "completed", is ignored.
+var transitions = map[entities.PaymentStatus][]entities.PaymentStatus{
+	entities.PaymentStatusPending: {
+		entities.PaymentStatusProcessing,
+		entities.PaymentStatusCompleted,
+		entities.PaymentStatusFailed,
+		entities.PaymentStatusCancelled,
+	},
+	entities.PaymentStatusProcessing: {
+		entities.PaymentStatusCompleted,
+		entities.PaymentStatusFailed,
+		entities.PaymentStatusCancelled,
+	},
+	entities.PaymentStatusCompleted: {
+		entities.PaymentStatusRefunded,
+	},
+}
+
+func canTransition(from, to entities.PaymentStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isFinal reports whether status is a result worth publishing
+func isFinal(status entities.PaymentStatus) bool {
+	switch status {
+	case entities.PaymentStatusCompleted, entities.PaymentStatusFailed,
+		entities.PaymentStatusCancelled, entities.PaymentStatusRefunded:
+		return true
+	}
+	return false
+}
+
+// Result is what Settle did with a provider status
+type Result string
+
+const (
+	// ResultApplied means the payment moved to the new status
+	ResultApplied Result = "applied"
+	// ResultRepublished means the payment already had the status; its event
+	// was published again in case an earlier attempt failed after the update
+	ResultRepublished Result = "republished"
+	// ResultIgnored means the status does not follow the payment's current
+	// one and was dropped
+	ResultIgnored Result = "ignored"
+)
+
+// Update is a provider-reported payment status
+type Update struct {
+	ExternalTxnID string
+	Status        entities.PaymentStatus
+	FailureReason string
+	// Source names where the update came from, e.g. "webhook"
+	Source string
+}
+
+// Settler applies provider payment results to stored payments and
+// publishes PaymentProcessedEvent for final ones
+type Settler struct {
+	mongodb *database.MongoDB
+	kafka   *messaging.KafkaManager
+	metrics *observability.BusinessMetrics
+}
+
+func NewSettler(mongodb *database.MongoDB, kafka *messaging.KafkaManager, metrics *observability.BusinessMetrics) *Settler {
+	return &Settler{mongodb: mongodb, kafka: kafka, metrics: metrics}
+}
+
+// FindByExternalTxnID loads the payment with the provider transaction id
+func (s *Settler) FindByExternalTxnID(ctx context.Context, txnID string) (*entities.Payment, error) {
+	var payment entities.Payment
+	err := s.mongodb.PaymentsCollection().FindOne(ctx, bson.M{"external_txn_id": txnID}).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: transaction %s", ErrPaymentNotFound, txnID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load payment: %w", err)
+	}
+	return &payment, nil
+}
+
+// Settle moves payment to the update's status. The update only applies if
+// the payment still has the status it was read with, so concurrent results
+// cannot overwrite each other. Consumers of PaymentProcessedEvent are
+// idempotent, which makes republishing safe.
+func (s *Settler) Settle(ctx context.Context, payment *entities.Payment, update Update) (Result, error) {
+	switch {
+	case payment.Status == update.Status:
+		if !isFinal(update.Status) {
+			return ResultIgnored, nil
+		}
+		if err := s.publish(ctx, payment); err != nil {
+			return "", err
+		}
+		return ResultRepublished, nil
+	case !canTransition(payment.Status, update.Status):
+		return ResultIgnored, nil
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"status": update.Status, "updated_at": now}
+	if update.FailureReason != "" {
+		set["metadata.failure_reason"] = update.FailureReason
+	}
+
+	result, err := s.mongodb.PaymentsCollection().UpdateOne(ctx,
+		bson.M{"_id": payment.ID, "status": payment.Status},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to update payment: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return "", ErrConcurrentUpdate
+	}
+
+	payment.Status = update.Status
+	payment.UpdatedAt = now
+	if update.FailureReason != "" {
+		if payment.Metadata == nil {
+			payment.Metadata = map[string]string{}
+		}
+		payment.Metadata["failure_reason"] = update.FailureReason
+	}
+	s.record(ctx, update)
+
+	if isFinal(update.Status) {
+		if err := s.publish(ctx, payment); err != nil {
+			return "", err
+		}
+	}
+	return ResultApplied, nil
+}
+
+func (s *Settler) publish(ctx context.Context, payment *entities.Payment) error {
+	event := messaging.PaymentProcessedEvent{
+		PaymentID:     payment.ID.Hex(),
+		UserID:        payment.UserID.Hex(),
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		Status:        string(payment.Status),
+		ExternalTxnID: payment.ExternalTxnID,
+		Metadata:      payment.Metadata,
+		Timestamp:     payment.UpdatedAt,
+	}
+	if !payment.OrderID.IsZero() {
+		event.OrderID = payment.OrderID.Hex()
+	}
+	if err := s.kafka.PublishPaymentProcessed(ctx, event); err != nil {
+		return fmt.Errorf("failed to publish payment result: %w", err)
+	}
+	return nil
+}
+
+func (s *Settler) record(ctx context.Context, update Update) {
+	if s.metrics == nil {
+		return
+	}
+	attrs := metric.WithAttributes(attribute.String("source", update.Source))
+	switch update.Status {
+	case entities.PaymentStatusCompleted:
+		s.metrics.PaymentSuccessCounter.Add(ctx, 1, attrs)
+	case entities.PaymentStatusFailed, entities.PaymentStatusCancelled:
+		s.metrics.PaymentFailureCounter.Add(ctx, 1, attrs)
+	}
+}
@@ -0,0 +1,181 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/database"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
+)
+
+const dedupeKeyPrefix = "mtnpay:callback:"
+
+// MTNPayWebhook receives asynchronous payment results from MTN Pay
+type MTNPayWebhook struct {
+	verifier  *external.MTNPayVerifier
+	redis     *database.Redis
+	settler   *Settler
+	logger    *observability.Logger
+	tracer    trace.Tracer
+	dedupeTTL time.Duration
+}
+
+func NewMTNPayWebhook(cfg *config.MTNPayConfig, redis *database.Redis, settler *Settler, logger *observability.Logger) *MTNPayWebhook {
+	return &MTNPayWebhook{
+		verifier:  external.NewMTNPayVerifier(cfg.Secret, cfg.SignatureTolerance),
+		redis:     redis,
+		settler:   settler,
+		logger:    logger,
+		tracer:    otel.Tracer("payment-webhooks"),
+		dedupeTTL: cfg.CallbackDedupeTTL,
+	}
+}
+
+// Handle verifies and applies a callback. Failures that a retry could fix
+// answer 5xx or 409 so the provider sends the callback again; bad callbacks
+// answer 4xx.
+func (w *MTNPayWebhook) Handle(c *fiber.Ctx) error {
+	body := c.Body()
+	header := func(key string) string { return c.Get(key) }
+	if err := w.verifier.Verify(c.Method(), c.OriginalURL(), header, body); err != nil {
+		w.logger.WithTrace(c.UserContext()).Warn("Rejected MTN Pay callback", zap.Error(err))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid signature"})
+	}
+
+	var callback external.MTNPayCallback
+	if err := json.Unmarshal(body, &callback); err != nil || callback.TransactionID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid callback body"})
+	}
+
+	// Loaded ahead of the span, which links to the span that created it
+	payment, lookupErr := w.settler.FindByExternalTxnID(c.UserContext(), callback.TransactionID)
+
+	ctx, span := w.startSpan(c, callback, payment)
+	defer span.End()
+
+	code, result, err := w.apply(ctx, span, callback, payment, lookupErr)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.logger.WithTrace(ctx).Error("Failed to apply MTN Pay callback",
+			zap.String("transaction_id", callback.TransactionID),
+			zap.String("status", callback.Status),
+			zap.Error(err),
+		)
+		// Internal errors stay in the logs
+		message := err.Error()
+		if code >= fiber.StatusInternalServerError {
+			message = "failed to apply callback"
+		}
+		return c.Status(code).JSON(fiber.Map{"error": message})
+	}
+
+	span.SetAttributes(attribute.String("payment.callback_result", result))
+	return c.Status(code).JSON(fiber.Map{"result": result})
+}
+
+func (w *MTNPayWebhook) apply(ctx context.Context, span trace.Span, callback external.MTNPayCallback, payment *entities.Payment, lookupErr error) (int, string, error) {
+	status, err := MTNPayStatus(callback.Status)
+	if err != nil {
+		return fiber.StatusUnprocessableEntity, "", err
+	}
+	span.SetAttributes(attribute.String("payment.status", string(status)))
+
+	// Repeats of a transaction id and status are dropped; a new status for
+	// the same transaction is not a repeat
+	key := dedupeKeyPrefix + callback.TransactionID + ":" + string(status)
+	first, err := w.redis.SetNX(ctx, key, time.Now().UTC().Unix(), w.dedupeTTL)
+	if err != nil {
+		// Settle is idempotent, carry on without the fast path
+		w.logger.WithTrace(ctx).Warn("MTN Pay callback dedupe unavailable", zap.Error(err))
+		first = true
+	}
+	if !first {
+		span.SetAttributes(attribute.Bool("payment.callback_duplicate", true))
+		return fiber.StatusOK, "duplicate", nil
+	}
+
+	code, result, err := w.settle(ctx, span, callback, status, payment, lookupErr)
+	if err != nil {
+		// Let the provider's retry through
+		if derr := w.redis.Del(context.WithoutCancel(ctx), key); derr != nil {
+			w.logger.WithTrace(ctx).Warn("Failed to clear MTN Pay callback dedupe key", zap.Error(derr))
+		}
+	}
+	return code, result, err
+}
+
+func (w *MTNPayWebhook) settle(ctx context.Context, span trace.Span, callback external.MTNPayCallback, status entities.PaymentStatus, payment *entities.Payment, lookupErr error) (int, string, error) {
+	if errors.Is(lookupErr, ErrPaymentNotFound) {
+		return fiber.StatusNotFound, "", lookupErr
+	}
+	if lookupErr != nil {
+		return fiber.StatusInternalServerError, "", lookupErr
+	}
+
+	span.SetAttributes(attribute.String("payment.id", payment.ID.Hex()))
+
+	result, err := w.settler.Settle(ctx, payment, Update{
+		ExternalTxnID: callback.TransactionID,
+		Status:        status,
+		FailureReason: callback.FailureReason,
+		Source:        "webhook",
+	})
+	switch {
+	case errors.Is(err, ErrConcurrentUpdate):
+		return fiber.StatusConflict, "", err
+	case err != nil:
+		return fiber.StatusInternalServerError, "", err
+	}
+	return fiber.StatusOK, string(result), nil
+}
+
+// startSpan continues the provider's trace when the callback carries a
+// traceparent, linking back to the server span. Without one the span stays
+// in the server's trace. Either way it links to the span that created
+// payment (may be nil).
+func (w *MTNPayWebhook) startSpan(c *fiber.Ctx, callback external.MTNPayCallback, payment *entities.Payment) (context.Context, trace.Span) {
+	ctx := c.UserContext()
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(
+			attribute.String("payment.provider", "mtnpay"),
+			attribute.String("payment.external_txn_id", callback.TransactionID),
+			attribute.String("payment.provider_status", callback.Status),
+		),
+	}
+
+	header := http.Header{}
+	for key, value := range c.GetReqHeaders() {
+		header[key] = value
+	}
+	providerCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	if sc := trace.SpanContextFromContext(providerCtx); sc.IsRemote() {
+		if server := trace.SpanContextFromContext(ctx); server.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: server}))
+		}
+		ctx = providerCtx
+	}
+	if payment != nil {
+		if sc := paymentSpanContext(payment); sc.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc, Attributes: []attribute.KeyValue{
+				attribute.String("link.reason", "payment_created"),
+			}}))
+		}
+	}
+
+	return w.tracer.Start(ctx, "mtnpay.webhook", opts...)
+}
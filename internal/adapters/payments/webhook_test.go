@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+// TestWebhookSpanLinksPayment checks that the webhook span links to the span
+// the payment was created in, with and without a forwarded traceparent
+func TestWebhookSpanLinksPayment(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx, created := provider.Tracer("test").Start(context.Background(), "payments.create")
+	payment := &entities.Payment{TraceContext: traceContext(ctx)}
+	created.End()
+
+	w := &MTNPayWebhook{tracer: provider.Tracer("payment-webhooks")}
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		_, span := w.startSpan(c, external.MTNPayCallback{TransactionID: "txn-1"}, payment)
+		span.End()
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for name, traceparent := range map[string]string{
+		"no traceparent": "",
+		"traceparent":    "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodPost, "/", nil)
+			if traceparent != "" {
+				req.Header.Set("traceparent", traceparent)
+			}
+			if _, err := app.Test(req); err != nil {
+				t.Fatal(err)
+			}
+
+			spans := recorder.Ended()
+			span := spans[len(spans)-1]
+			for _, link := range span.Links() {
+				if link.SpanContext.SpanID() == created.SpanContext().SpanID() &&
+					link.SpanContext.TraceID() == created.SpanContext().TraceID() {
+					return
+				}
+			}
+			t.Errorf("webhook span links %v, want the payment's span %v", span.Links(), created.SpanContext())
+		})
+	}
+}
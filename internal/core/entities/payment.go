@@ -18,8 +18,14 @@ type Payment struct {
 	Reference     string             `bson:"reference" json:"reference"`
 	Description   string             `bson:"description,omitempty" json:"description,omitempty"`
 	Metadata      map[string]string  `bson:"metadata,omitempty" json:"metadata,omitempty"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	// TraceContext holds the propagated trace context of the request that
+	// created the payment, so asynchronous results can link back to it
+	TraceContext map[string]string `bson:"trace_context,omitempty" json:"-"`
+	// ReconciledAt is when the reconciliation job last found the payment
+	// still open at the provider
+	ReconciledAt *time.Time `bson:"reconciled_at,omitempty" json:"-"`
+	CreatedAt    time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `bson:"updated_at" json:"updated_at"`
 }
 
 type PaymentMethod string
@@ -48,6 +54,7 @@ type CreatePaymentRequest struct {
 	Currency    string            `json:"currency" validate:"required"`
 	Method      PaymentMethod     `json:"method" validate:"required"`
 	Description string            `json:"description,omitempty"`
+	PhoneNumber string            `json:"phone_number,omitempty"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
 }
 
@@ -2,19 +2,22 @@ package config
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server    ServerConfig    `mapstructure:"server"`
-	Database  DatabaseConfig  `mapstructure:"database"`
-	Redis     RedisConfig     `mapstructure:"redis"`
-	Kafka     KafkaConfig     `mapstructure:"kafka"`
-	External  ExternalConfig  `mapstructure:"external"`
-	Telemetry TelemetryConfig `mapstructure:"telemetry"`
-	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Server         ServerConfig         `mapstructure:"server"`
+	Database       DatabaseConfig       `mapstructure:"database"`
+	Redis          RedisConfig          `mapstructure:"redis"`
+	Kafka          KafkaConfig          `mapstructure:"kafka"`
+	External       ExternalConfig       `mapstructure:"external"`
+	Telemetry      TelemetryConfig      `mapstructure:"telemetry"`
+	RateLimit      RateLimitConfig      `mapstructure:"rate_limit"`
+	Reconciliation ReconciliationConfig `mapstructure:"reconciliation"`
 }
 
 type ServerConfig struct {
@@ -33,8 +36,35 @@ type RedisConfig struct {
 }
 
 type KafkaConfig struct {
-	Brokers []string `mapstructure:"brokers"`
-	Topics  Topics   `mapstructure:"topics"`
+	Brokers       []string                 `mapstructure:"brokers"`
+	Topics        Topics                   `mapstructure:"topics"`
+	Producer      string                   `mapstructure:"producer"`
+	Consumer      KafkaConsumerConfig      `mapstructure:"consumer"`
+	Groups        KafkaConsumerGroups      `mapstructure:"groups"`
+	Serialization KafkaSerializationConfig `mapstructure:"serialization"`
+	ClientID      string                   `mapstructure:"client_id"`
+	DialTimeout   time.Duration            `mapstructure:"dial_timeout"`
+	TLS           KafkaTLSConfig           `mapstructure:"tls"`
+	SASL          KafkaSASLConfig          `mapstructure:"sasl"`
+	Provisioning  KafkaProvisioningConfig  `mapstructure:"provisioning"`
+}
+
+// KafkaTLSConfig enables TLS to the brokers. CAFile defaults to the system
+// roots; CertFile and KeyFile enable mutual TLS.
+type KafkaTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// KafkaSASLConfig enables SASL authentication. Mechanism is "PLAIN",
+// "SCRAM-SHA-256" or "SCRAM-SHA-512"; leave it empty to disable SASL.
+type KafkaSASLConfig struct {
+	Mechanism string `mapstructure:"mechanism"`
+	Username  string `mapstructure:"username"`
+	Password  string `mapstructure:"password"`
 }
 
 type Topics struct {
@@ -44,34 +74,253 @@ type Topics struct {
 	Users    string `mapstructure:"users"`
 }
 
+// KafkaConsumerConfig tunes the concurrent consumer worker pool.
+// OrderingKey is either "key" (messages with the same key are handled in
+// order) or "partition" (messages from the same partition are handled in order).
+// TraceContext is "parent" to continue the producer's trace or "link" to
+// start a new trace linked to it.
+type KafkaConsumerConfig struct {
+	Workers      int    `mapstructure:"workers"`
+	QueueSize    int    `mapstructure:"queue_size"`
+	MaxInFlight  int    `mapstructure:"max_in_flight"`
+	OrderingKey  string `mapstructure:"ordering_key"`
+	TraceContext string `mapstructure:"trace_context"`
+	// A failed message is tried MaxAttempts times in all, backing off
+	// exponentially, before it is moved to its topic's dead-letter topic,
+	// or skipped when there is none
+	MaxAttempts     int           `mapstructure:"max_attempts"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	RetryMaxBackoff time.Duration `mapstructure:"retry_max_backoff"`
+}
+
+// KafkaConsumerGroups holds the group id of each background consumer
+type KafkaConsumerGroups struct {
+	PaymentSettlement string `mapstructure:"payment_settlement"`
+	StockAvailability string `mapstructure:"stock_availability"`
+	WelcomeBonus      string `mapstructure:"welcome_bonus"`
+}
+
+// KafkaSerializationConfig selects the event encoding. Format is "json",
+// "avro" or "protobuf"; the binary formats use the Confluent wire format and
+// need either a schema registry URL or a local registry file.
+type KafkaSerializationConfig struct {
+	Format                 string `mapstructure:"format"`
+	SchemaRegistryURL      string `mapstructure:"schema_registry_url"`
+	SchemaRegistryFile     string `mapstructure:"schema_registry_file"`
+	SchemaRegistryUsername string `mapstructure:"schema_registry_username"`
+	SchemaRegistryPassword string `mapstructure:"schema_registry_password"`
+	AutoRegisterSchemas    bool   `mapstructure:"auto_register_schemas"`
+}
+
+// KafkaProvisioningConfig declares the desired state of the topics. Topics
+// is keyed by the Topics field name (orders, payments, rewards, users); DLQ
+// adds a "<topic><DLQSuffix>" topic with the same partitioning.
+type KafkaProvisioningConfig struct {
+	Enabled       bool                      `mapstructure:"enabled"`
+	CreateMissing bool                      `mapstructure:"create_missing"`
+	FailOnDrift   bool                      `mapstructure:"fail_on_drift"`
+	Timeout       time.Duration             `mapstructure:"timeout"`
+	DLQSuffix     string                    `mapstructure:"dlq_suffix"`
+	Topics        map[string]KafkaTopicSpec `mapstructure:"topics"`
+}
+
+// KafkaTopicSpec is the desired state of one topic. Configs holds extra
+// topic-level settings such as cleanup.policy.
+type KafkaTopicSpec struct {
+	Partitions        int               `mapstructure:"partitions"`
+	ReplicationFactor int               `mapstructure:"replication_factor"`
+	Retention         time.Duration     `mapstructure:"retention"`
+	DLQ               bool              `mapstructure:"dlq"`
+	DLQRetention      time.Duration     `mapstructure:"dlq_retention"`
+	Configs           map[string]string `mapstructure:"configs"`
+}
+
 type ExternalConfig struct {
 	MTNPay MTNPayConfig `mapstructure:"mtn_pay"`
 	MADAPI MADAPIConfig `mapstructure:"madapi"`
 	SOA    SOAConfig    `mapstructure:"soa"`
 }
 
+// MTNPayConfig configures the MTN Pay client. Secret signs outbound requests
+// and verifies callbacks; SignatureTolerance is the clock skew accepted on a
+// callback's timestamp. A callback repeating a transaction id and status
+// within CallbackDedupeTTL is acknowledged without being applied again.
 type MTNPayConfig struct {
-	BaseURL string `mapstructure:"base_url"`
-	APIKey  string `mapstructure:"api_key"`
-	Secret  string `mapstructure:"secret"`
+	BaseURL            string           `mapstructure:"base_url"`
+	APIKey             string           `mapstructure:"api_key"`
+	Secret             string           `mapstructure:"secret"`
+	SignatureTolerance time.Duration    `mapstructure:"signature_tolerance"`
+	CallbackDedupeTTL  time.Duration    `mapstructure:"callback_dedupe_ttl"`
+	OAuth2             OAuth2Config     `mapstructure:"oauth2"`
+	Resilience         ResilienceConfig `mapstructure:"resilience"`
 }
 
+// MADAPIConfig.PricingConcurrency bounds the pricing calls in flight for
+// one batch; MADAPI has no batch endpoint, so batches fan out
 type MADAPIConfig struct {
-	BaseURL string `mapstructure:"base_url"`
-	APIKey  string `mapstructure:"api_key"`
+	BaseURL            string           `mapstructure:"base_url"`
+	APIKey             string           `mapstructure:"api_key"`
+	PricingConcurrency int              `mapstructure:"pricing_concurrency"`
+	OAuth2             OAuth2Config     `mapstructure:"oauth2"`
+	Resilience         ResilienceConfig `mapstructure:"resilience"`
 }
 
+// SOAConfig.InventoryBatchSize is the most products sent to SOA's batch
+// inventory endpoint at once; zero, for deployments without it, checks
+// products one per call. InventoryConcurrency bounds the requests in flight
+// for one batch either way.
 type SOAConfig struct {
-	BaseURL string `mapstructure:"base_url"`
-	APIKey  string `mapstructure:"api_key"`
+	BaseURL              string           `mapstructure:"base_url"`
+	APIKey               string           `mapstructure:"api_key"`
+	InventoryBatchSize   int              `mapstructure:"inventory_batch_size"`
+	InventoryConcurrency int              `mapstructure:"inventory_concurrency"`
+	OAuth2               OAuth2Config     `mapstructure:"oauth2"`
+	Resilience           ResilienceConfig `mapstructure:"resilience"`
+}
+
+// OAuth2Config switches a client from its API key to bearer tokens from the
+// OAuth2 client credentials grant when TokenURL is set. Tokens are shared
+// between replicas through Redis and refreshed RefreshBefore they expire,
+// or halfway through their lifetime when that is shorter.
+type OAuth2Config struct {
+	TokenURL      string        `mapstructure:"token_url"`
+	ClientID      string        `mapstructure:"client_id"`
+	ClientSecret  string        `mapstructure:"client_secret"`
+	Scopes        []string      `mapstructure:"scopes"`
+	Audience      string        `mapstructure:"audience"`
+	RefreshBefore time.Duration `mapstructure:"refresh_before"`
+	Timeout       time.Duration `mapstructure:"timeout"`
+}
+
+// ResilienceConfig is the outbound policy of one external client. Only GETs
+// are retried, up to MaxRetries times with jittered exponential backoff
+// between RetryWait and RetryMaxWait. The circuit breaker opens after
+// FailureThreshold consecutive failures and, after OpenTimeout, lets
+// HalfOpenProbes requests through; it closes once they all succeed.
+// MaxConcurrent bounds the requests in flight, and callers wait at most
+// QueueTimeout for a slot. RateLimit paces the requests sent.
+type ResilienceConfig struct {
+	Timeout          time.Duration         `mapstructure:"timeout"`
+	MaxRetries       int                   `mapstructure:"max_retries"`
+	RetryWait        time.Duration         `mapstructure:"retry_wait"`
+	RetryMaxWait     time.Duration         `mapstructure:"retry_max_wait"`
+	FailureThreshold int                   `mapstructure:"failure_threshold"`
+	OpenTimeout      time.Duration         `mapstructure:"open_timeout"`
+	HalfOpenProbes   int                   `mapstructure:"half_open_probes"`
+	MaxConcurrent    int                   `mapstructure:"max_concurrent"`
+	QueueTimeout     time.Duration         `mapstructure:"queue_timeout"`
+	RateLimit        ClientRateLimitConfig `mapstructure:"rate_limit"`
+}
+
+// ClientRateLimitConfig is a token bucket holding Burst requests and
+// refilled at RequestsPerSecond; zero RequestsPerSecond disables it. Callers
+// wait for a token at most MaxWait, zero waiting as long as their context
+// allows. When Shared, replicas draw from one bucket in Redis so together
+// they stay within the provider's quota for our API key.
+type ClientRateLimitConfig struct {
+	RequestsPerSecond float64       `mapstructure:"requests_per_second"`
+	Burst             int           `mapstructure:"burst"`
+	MaxWait           time.Duration `mapstructure:"max_wait"`
+	Shared            bool          `mapstructure:"shared"`
 }
 
 type TelemetryConfig struct {
-	ServiceName                  string `mapstructure:"service_name"`
-	ServiceVersion               string `mapstructure:"service_version"`
-	JaegerEndpoint               string `mapstructure:"jaeger_endpoint"`
-	PrometheusPort               int    `mapstructure:"prometheus_port"`
-	AzureMonitorConnectionString string `mapstructure:"azure_monitor_connection_string"`
+	ServiceName                  string         `mapstructure:"service_name"`
+	ServiceVersion               string         `mapstructure:"service_version"`
+	OTLP                         OTLPConfig     `mapstructure:"otlp"`
+	PrometheusPort               int            `mapstructure:"prometheus_port"`
+	AzureMonitorConnectionString string         `mapstructure:"azure_monitor_connection_string"`
+	Sampling                     SamplingConfig `mapstructure:"sampling"`
+	Metrics                      MetricsConfig  `mapstructure:"metrics"`
+	Logs                         LogsConfig     `mapstructure:"logs"`
+}
+
+// OTLPConfig configures the OTLP exporters and mirrors the
+// OTEL_EXPORTER_OTLP_* variables. Endpoint is a URL whose scheme selects
+// plaintext (http) or TLS (https); for http/protobuf the signal path is
+// appended to it, while TracesEndpoint is used as-is. An empty endpoint
+// falls back to the console exporter. Headers use the "k1=v1,k2=v2" format
+// and Timeout is a duration or a number of milliseconds.
+type OTLPConfig struct {
+	Endpoint          string          `mapstructure:"endpoint"`
+	TracesEndpoint    string          `mapstructure:"traces_endpoint"`
+	MetricsEndpoint   string          `mapstructure:"metrics_endpoint"`
+	LogsEndpoint      string          `mapstructure:"logs_endpoint"`
+	Protocol          string          `mapstructure:"protocol"`
+	Headers           string          `mapstructure:"headers"`
+	Insecure          bool            `mapstructure:"insecure"`
+	Certificate       string          `mapstructure:"certificate"`
+	ClientCertificate string          `mapstructure:"client_certificate"`
+	ClientKey         string          `mapstructure:"client_key"`
+	Compression       string          `mapstructure:"compression"`
+	Timeout           string          `mapstructure:"timeout"`
+	Retry             OTLPRetryConfig `mapstructure:"retry"`
+}
+
+type OTLPRetryConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	InitialInterval time.Duration `mapstructure:"initial_interval"`
+	MaxInterval     time.Duration `mapstructure:"max_interval"`
+	MaxElapsedTime  time.Duration `mapstructure:"max_elapsed_time"`
+}
+
+// MetricsConfig selects the metric readers. Exporters is a comma-separated
+// list of "prometheus" and "otlp", as in OTEL_METRICS_EXPORTER. Interval is
+// the OTLP export interval (a duration or milliseconds) and Temporality is
+// "cumulative", "delta" or "lowmemory". The instrument lists hold glob
+// patterns of the instruments each reader exports; empty means all.
+// ExemplarFilter is "trace_based", "always_on" or "always_off", as in
+// OTEL_METRICS_EXEMPLAR_FILTER.
+type MetricsConfig struct {
+	Exporters             string             `mapstructure:"exporters"`
+	Interval              string             `mapstructure:"interval"`
+	Temporality           string             `mapstructure:"temporality"`
+	PrometheusInstruments []string           `mapstructure:"prometheus_instruments"`
+	OTLPInstruments       []string           `mapstructure:"otlp_instruments"`
+	ExemplarFilter        string             `mapstructure:"exemplar_filter"`
+	Views                 []MetricViewConfig `mapstructure:"views"`
+}
+
+// MetricViewConfig customises the instruments whose name matches
+// Instrument, which may contain * and ? wildcards. Buckets sets explicit
+// histogram boundaries; Exponential switches to a base-2 exponential
+// histogram of up to MaxSize buckets instead. DropAttributes are removed
+// before aggregation.
+type MetricViewConfig struct {
+	Instrument     string    `mapstructure:"instrument"`
+	Buckets        []float64 `mapstructure:"buckets"`
+	Exponential    bool      `mapstructure:"exponential"`
+	MaxSize        int32     `mapstructure:"max_size"`
+	DropAttributes []string  `mapstructure:"drop_attributes"`
+}
+
+// LogsConfig configures the OTLP logs pipeline fed by the zap logger.
+// Exporter is "otlp" or "none", as in OTEL_LOGS_EXPORTER. Records are
+// batched in a queue of QueueSize records, dropping the oldest when it is
+// full, and exported every Interval (a duration or milliseconds) or once
+// BatchSize records are waiting.
+type LogsConfig struct {
+	Exporter  string `mapstructure:"exporter"`
+	QueueSize int    `mapstructure:"queue_size"`
+	BatchSize int    `mapstructure:"batch_size"`
+	Interval  string `mapstructure:"interval"`
+}
+
+// SamplingConfig selects the trace sampler. Sampler takes the values of
+// OTEL_TRACES_SAMPLER plus "ratelimited" and "parentbased_ratelimited";
+// Arg is the ratio for the ratio samplers and traces per second for the
+// rate-limited ones. Rules take precedence over the sampler.
+type SamplingConfig struct {
+	Sampler string         `mapstructure:"sampler"`
+	Arg     string         `mapstructure:"arg"`
+	Rules   []SamplingRule `mapstructure:"rules"`
+}
+
+// SamplingRule samples requests whose path starts with Route at Ratio
+// (1 always samples, 0 never does)
+type SamplingRule struct {
+	Route string  `mapstructure:"route"`
+	Ratio float64 `mapstructure:"ratio"`
 }
 
 type RateLimitConfig struct {
@@ -79,6 +328,22 @@ type RateLimitConfig struct {
 	BurstSize         int `mapstructure:"burst_size"`
 }
 
+// ReconciliationConfig configures the job that resolves MTN Pay payments
+// stuck in pending or processing. Every Interval one replica, holding a
+// Redis lock for at most LockTTL, checks up to BatchSize payments older than
+// MinAge with the provider at RatePerSecond. Payments the provider has no
+// record of after FailAfter are failed; those it still reports as open are
+// logged and counted as overdue, since it may still complete them.
+type ReconciliationConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Interval      time.Duration `mapstructure:"interval"`
+	MinAge        time.Duration `mapstructure:"min_age"`
+	FailAfter     time.Duration `mapstructure:"fail_after"`
+	BatchSize     int           `mapstructure:"batch_size"`
+	RatePerSecond float64       `mapstructure:"rate_per_second"`
+	LockTTL       time.Duration `mapstructure:"lock_ttl"`
+}
+
 func Load() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
@@ -108,6 +373,24 @@ func Load() (*Config, error) {
 	return &config, nil
 }
 
+// OnChange calls fn with the reloaded configuration whenever the config file
+// changes. It does nothing when no config file was found.
+func OnChange(fn func(*Config)) {
+	if viper.ConfigFileUsed() == "" {
+		return
+	}
+
+	viper.OnConfigChange(func(fsnotify.Event) {
+		var config Config
+		if err := viper.Unmarshal(&config); err != nil {
+			fmt.Printf("Warning: ignoring invalid config change: %v\n", err)
+			return
+		}
+		fn(&config)
+	})
+	viper.WatchConfig()
+}
+
 func setDefaults() {
 	viper.SetDefault("server.port", "3000")
 	viper.SetDefault("server.environment", "development")
@@ -122,14 +405,116 @@ func setDefaults() {
 	viper.SetDefault("kafka.topics.payments", "payments")
 	viper.SetDefault("kafka.topics.rewards", "rewards")
 	viper.SetDefault("kafka.topics.users", "users")
+	viper.SetDefault("kafka.producer", "otel-fiber-demo")
+	viper.SetDefault("kafka.consumer.workers", 8)
+	viper.SetDefault("kafka.consumer.queue_size", 64)
+	viper.SetDefault("kafka.consumer.max_in_flight", 256)
+	viper.SetDefault("kafka.consumer.ordering_key", "key")
+	viper.SetDefault("kafka.consumer.trace_context", "parent")
+	viper.SetDefault("kafka.consumer.max_attempts", 5)
+	viper.SetDefault("kafka.consumer.retry_backoff", "1s")
+	viper.SetDefault("kafka.consumer.retry_max_backoff", "30s")
+	viper.SetDefault("kafka.groups.payment_settlement", "otel-fiber-demo.payment-settlement")
+	viper.SetDefault("kafka.groups.stock_availability", "otel-fiber-demo.stock-availability")
+	viper.SetDefault("kafka.groups.welcome_bonus", "otel-fiber-demo.welcome-bonus")
+	viper.SetDefault("kafka.serialization.format", "json")
+	viper.SetDefault("kafka.serialization.auto_register_schemas", true)
+	viper.SetDefault("kafka.client_id", "otel-fiber-demo")
+	viper.SetDefault("kafka.dial_timeout", "10s")
+	viper.SetDefault("kafka.tls.enabled", false)
+	viper.SetDefault("kafka.provisioning.enabled", true)
+	viper.SetDefault("kafka.provisioning.create_missing", true)
+	viper.SetDefault("kafka.provisioning.fail_on_drift", false)
+	viper.SetDefault("kafka.provisioning.timeout", "30s")
+	viper.SetDefault("kafka.provisioning.dlq_suffix", ".dlq")
+	for _, topic := range []string{"orders", "payments", "rewards", "users"} {
+		prefix := "kafka.provisioning.topics." + topic
+		viper.SetDefault(prefix+".partitions", 6)
+		viper.SetDefault(prefix+".replication_factor", 1)
+		viper.SetDefault(prefix+".retention", "168h")
+		viper.SetDefault(prefix+".dlq_retention", "336h")
+	}
+	// Rewards have no consumers in this service, so no DLQ topic
+	for _, topic := range []string{"orders", "payments", "users"} {
+		viper.SetDefault("kafka.provisioning.topics."+topic+".dlq", true)
+	}
+
+	viper.SetDefault("external.mtn_pay.signature_tolerance", "5m")
+	viper.SetDefault("external.mtn_pay.callback_dedupe_ttl", "24h")
+	viper.SetDefault("external.madapi.pricing_concurrency", 8)
+	viper.SetDefault("external.soa.inventory_batch_size", 0)
+	viper.SetDefault("external.soa.inventory_concurrency", 8)
+
+	for client, timeout := range map[string]string{"mtn_pay": "30s", "madapi": "20s", "soa": "25s"} {
+		prefix := "external." + client + ".resilience"
+		viper.SetDefault(prefix+".timeout", timeout)
+		viper.SetDefault(prefix+".max_retries", 3)
+		viper.SetDefault(prefix+".retry_wait", "200ms")
+		viper.SetDefault(prefix+".retry_max_wait", "2s")
+		viper.SetDefault(prefix+".failure_threshold", 5)
+		viper.SetDefault(prefix+".open_timeout", "30s")
+		viper.SetDefault(prefix+".half_open_probes", 1)
+		viper.SetDefault(prefix+".max_concurrent", 20)
+		viper.SetDefault(prefix+".queue_timeout", "250ms")
+		viper.SetDefault(prefix+".rate_limit.requests_per_second", 20)
+		viper.SetDefault(prefix+".rate_limit.burst", 40)
+		viper.SetDefault(prefix+".rate_limit.max_wait", "2s")
+		viper.SetDefault(prefix+".rate_limit.shared", true)
+
+		viper.SetDefault("external."+client+".oauth2.refresh_before", "1m")
+		viper.SetDefault("external."+client+".oauth2.timeout", "10s")
+	}
 
 	viper.SetDefault("telemetry.service_name", "otel-fiber-demo")
 	viper.SetDefault("telemetry.service_version", "1.0.0")
-	viper.SetDefault("telemetry.jaeger_endpoint", "http://localhost:14268/api/traces")
+	viper.SetDefault("telemetry.otlp.endpoint", "http://localhost:4318")
+	viper.SetDefault("telemetry.otlp.protocol", "http/protobuf")
+	viper.SetDefault("telemetry.otlp.compression", "gzip")
+	viper.SetDefault("telemetry.otlp.timeout", "10s")
+	viper.SetDefault("telemetry.otlp.retry.enabled", true)
+	viper.SetDefault("telemetry.otlp.retry.initial_interval", "5s")
+	viper.SetDefault("telemetry.otlp.retry.max_interval", "30s")
+	viper.SetDefault("telemetry.otlp.retry.max_elapsed_time", "1m")
 	viper.SetDefault("telemetry.prometheus_port", 8080)
+	viper.SetDefault("telemetry.metrics.exporters", "prometheus")
+	viper.SetDefault("telemetry.metrics.interval", "60s")
+	viper.SetDefault("telemetry.metrics.temporality", "cumulative")
+	viper.SetDefault("telemetry.metrics.exemplar_filter", "trace_based")
+	viper.SetDefault("telemetry.metrics.views", []map[string]interface{}{
+		{
+			"instrument": "http_request_duration_seconds",
+			"buckets":    []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		{
+			// Up to and past the 30s MTN Pay timeout
+			"instrument": "external_api_duration_seconds",
+			"buckets":    []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 20, 25, 30, 45},
+		},
+	})
+	viper.SetDefault("telemetry.logs.exporter", "none")
+	viper.SetDefault("telemetry.logs.queue_size", 2048)
+	viper.SetDefault("telemetry.logs.batch_size", 512)
+	viper.SetDefault("telemetry.logs.interval", "1s")
+	viper.SetDefault("telemetry.sampling.sampler", "parentbased_traceidratio")
+	viper.SetDefault("telemetry.sampling.arg", "0.1")
+	viper.SetDefault("telemetry.sampling.rules", []map[string]interface{}{
+		{"route": "/v1/payments", "ratio": 1.0},
+		{"route": "/v1/webhooks", "ratio": 1.0},
+		{"route": "/v1/health", "ratio": 0.0},
+		{"route": "/v1/ready", "ratio": 0.0},
+		{"route": "/v1/metrics", "ratio": 0.0},
+	})
 
 	viper.SetDefault("rate_limit.requests_per_minute", 100)
 	viper.SetDefault("rate_limit.burst_size", 10)
+
+	viper.SetDefault("reconciliation.enabled", true)
+	viper.SetDefault("reconciliation.interval", "1m")
+	viper.SetDefault("reconciliation.min_age", "2m")
+	viper.SetDefault("reconciliation.fail_after", "24h")
+	viper.SetDefault("reconciliation.batch_size", 100)
+	viper.SetDefault("reconciliation.rate_per_second", 5)
+	viper.SetDefault("reconciliation.lock_ttl", "5m")
 }
 
 func bindEnvVars() {
@@ -149,24 +534,116 @@ func bindEnvVars() {
 	viper.BindEnv("kafka.topics.payments", "KAFKA_TOPIC_PAYMENTS")
 	viper.BindEnv("kafka.topics.rewards", "KAFKA_TOPIC_REWARDS")
 	viper.BindEnv("kafka.topics.users", "KAFKA_TOPIC_USERS")
+	viper.BindEnv("kafka.producer", "KAFKA_PRODUCER")
+	viper.BindEnv("kafka.consumer.workers", "KAFKA_CONSUMER_WORKERS")
+	viper.BindEnv("kafka.consumer.queue_size", "KAFKA_CONSUMER_QUEUE_SIZE")
+	viper.BindEnv("kafka.consumer.max_in_flight", "KAFKA_CONSUMER_MAX_IN_FLIGHT")
+	viper.BindEnv("kafka.consumer.ordering_key", "KAFKA_CONSUMER_ORDERING_KEY")
+	viper.BindEnv("kafka.consumer.trace_context", "KAFKA_CONSUMER_TRACE_CONTEXT")
+	viper.BindEnv("kafka.consumer.max_attempts", "KAFKA_CONSUMER_MAX_ATTEMPTS")
+	viper.BindEnv("kafka.consumer.retry_backoff", "KAFKA_CONSUMER_RETRY_BACKOFF")
+	viper.BindEnv("kafka.consumer.retry_max_backoff", "KAFKA_CONSUMER_RETRY_MAX_BACKOFF")
+	viper.BindEnv("kafka.groups.payment_settlement", "KAFKA_GROUP_PAYMENT_SETTLEMENT")
+	viper.BindEnv("kafka.groups.stock_availability", "KAFKA_GROUP_STOCK_AVAILABILITY")
+	viper.BindEnv("kafka.groups.welcome_bonus", "KAFKA_GROUP_WELCOME_BONUS")
+	viper.BindEnv("kafka.serialization.format", "KAFKA_SERIALIZATION_FORMAT")
+	viper.BindEnv("kafka.serialization.schema_registry_url", "SCHEMA_REGISTRY_URL")
+	viper.BindEnv("kafka.serialization.schema_registry_file", "SCHEMA_REGISTRY_FILE")
+	viper.BindEnv("kafka.serialization.schema_registry_username", "SCHEMA_REGISTRY_USERNAME")
+	viper.BindEnv("kafka.serialization.schema_registry_password", "SCHEMA_REGISTRY_PASSWORD")
+	viper.BindEnv("kafka.serialization.auto_register_schemas", "SCHEMA_REGISTRY_AUTO_REGISTER")
+	viper.BindEnv("kafka.client_id", "KAFKA_CLIENT_ID")
+	viper.BindEnv("kafka.dial_timeout", "KAFKA_DIAL_TIMEOUT")
+	viper.BindEnv("kafka.tls.enabled", "KAFKA_TLS_ENABLED")
+	viper.BindEnv("kafka.tls.ca_file", "KAFKA_TLS_CA_FILE")
+	viper.BindEnv("kafka.tls.cert_file", "KAFKA_TLS_CERT_FILE")
+	viper.BindEnv("kafka.tls.key_file", "KAFKA_TLS_KEY_FILE")
+	viper.BindEnv("kafka.tls.insecure_skip_verify", "KAFKA_TLS_INSECURE_SKIP_VERIFY")
+	viper.BindEnv("kafka.sasl.mechanism", "KAFKA_SASL_MECHANISM")
+	viper.BindEnv("kafka.sasl.username", "KAFKA_SASL_USERNAME")
+	viper.BindEnv("kafka.sasl.password", "KAFKA_SASL_PASSWORD")
+	viper.BindEnv("kafka.provisioning.enabled", "KAFKA_PROVISIONING_ENABLED")
+	viper.BindEnv("kafka.provisioning.create_missing", "KAFKA_PROVISIONING_CREATE_MISSING")
+	viper.BindEnv("kafka.provisioning.fail_on_drift", "KAFKA_PROVISIONING_FAIL_ON_DRIFT")
+	for _, topic := range []string{"orders", "payments", "rewards", "users"} {
+		prefix := "kafka.provisioning.topics." + topic
+		env := "KAFKA_TOPIC_" + strings.ToUpper(topic)
+		viper.BindEnv(prefix+".partitions", env+"_PARTITIONS")
+		viper.BindEnv(prefix+".replication_factor", env+"_REPLICATION_FACTOR")
+		viper.BindEnv(prefix+".retention", env+"_RETENTION")
+	}
 
 	// External APIs
 	viper.BindEnv("external.mtn_pay.base_url", "MTN_PAY_BASE_URL")
 	viper.BindEnv("external.mtn_pay.api_key", "MTN_PAY_API_KEY")
 	viper.BindEnv("external.mtn_pay.secret", "MTN_PAY_SECRET")
+	viper.BindEnv("external.mtn_pay.signature_tolerance", "MTN_PAY_SIGNATURE_TOLERANCE")
+	viper.BindEnv("external.mtn_pay.callback_dedupe_ttl", "MTN_PAY_CALLBACK_DEDUPE_TTL")
 	viper.BindEnv("external.madapi.base_url", "MADAPI_BASE_URL")
 	viper.BindEnv("external.madapi.api_key", "MADAPI_API_KEY")
 	viper.BindEnv("external.soa.base_url", "SOA_BASE_URL")
 	viper.BindEnv("external.soa.api_key", "SOA_API_KEY")
+	viper.BindEnv("external.madapi.pricing_concurrency", "MADAPI_PRICING_CONCURRENCY")
+	viper.BindEnv("external.soa.inventory_batch_size", "SOA_INVENTORY_BATCH_SIZE")
+	viper.BindEnv("external.soa.inventory_concurrency", "SOA_INVENTORY_CONCURRENCY")
+	for client, env := range map[string]string{"mtn_pay": "MTN_PAY", "madapi": "MADAPI", "soa": "SOA"} {
+		prefix := "external." + client + ".resilience"
+		viper.BindEnv(prefix+".timeout", env+"_TIMEOUT")
+		viper.BindEnv(prefix+".max_retries", env+"_MAX_RETRIES")
+		viper.BindEnv(prefix+".failure_threshold", env+"_BREAKER_FAILURE_THRESHOLD")
+		viper.BindEnv(prefix+".open_timeout", env+"_BREAKER_OPEN_TIMEOUT")
+		viper.BindEnv(prefix+".max_concurrent", env+"_MAX_CONCURRENT")
+		viper.BindEnv(prefix+".rate_limit.requests_per_second", env+"_RATE_LIMIT_RPS")
+		viper.BindEnv(prefix+".rate_limit.burst", env+"_RATE_LIMIT_BURST")
+		viper.BindEnv(prefix+".rate_limit.max_wait", env+"_RATE_LIMIT_MAX_WAIT")
+		viper.BindEnv(prefix+".rate_limit.shared", env+"_RATE_LIMIT_SHARED")
+
+		oauth2 := "external." + client + ".oauth2"
+		viper.BindEnv(oauth2+".token_url", env+"_OAUTH2_TOKEN_URL")
+		viper.BindEnv(oauth2+".client_id", env+"_OAUTH2_CLIENT_ID")
+		viper.BindEnv(oauth2+".client_secret", env+"_OAUTH2_CLIENT_SECRET")
+		viper.BindEnv(oauth2+".scopes", env+"_OAUTH2_SCOPES")
+		viper.BindEnv(oauth2+".audience", env+"_OAUTH2_AUDIENCE")
+	}
 
 	// Telemetry
 	viper.BindEnv("telemetry.service_name", "OTEL_SERVICE_NAME")
 	viper.BindEnv("telemetry.service_version", "OTEL_SERVICE_VERSION")
-	viper.BindEnv("telemetry.jaeger_endpoint", "OTEL_EXPORTER_JAEGER_ENDPOINT")
+	viper.BindEnv("telemetry.otlp.endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT")
+	viper.BindEnv("telemetry.otlp.traces_endpoint", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	viper.BindEnv("telemetry.otlp.metrics_endpoint", "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
+	viper.BindEnv("telemetry.otlp.logs_endpoint", "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT")
+	viper.BindEnv("telemetry.otlp.protocol", "OTEL_EXPORTER_OTLP_PROTOCOL")
+	viper.BindEnv("telemetry.otlp.headers", "OTEL_EXPORTER_OTLP_HEADERS")
+	viper.BindEnv("telemetry.otlp.insecure", "OTEL_EXPORTER_OTLP_INSECURE")
+	viper.BindEnv("telemetry.otlp.certificate", "OTEL_EXPORTER_OTLP_CERTIFICATE")
+	viper.BindEnv("telemetry.otlp.client_certificate", "OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE")
+	viper.BindEnv("telemetry.otlp.client_key", "OTEL_EXPORTER_OTLP_CLIENT_KEY")
+	viper.BindEnv("telemetry.otlp.compression", "OTEL_EXPORTER_OTLP_COMPRESSION")
+	viper.BindEnv("telemetry.otlp.timeout", "OTEL_EXPORTER_OTLP_TIMEOUT")
 	viper.BindEnv("telemetry.prometheus_port", "OTEL_EXPORTER_PROMETHEUS_PORT")
 	viper.BindEnv("telemetry.azure_monitor_connection_string", "AZURE_MONITOR_CONNECTION_STRING")
+	viper.BindEnv("telemetry.metrics.exporters", "OTEL_METRICS_EXPORTER")
+	viper.BindEnv("telemetry.metrics.interval", "OTEL_METRIC_EXPORT_INTERVAL")
+	viper.BindEnv("telemetry.metrics.temporality", "OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE")
+	viper.BindEnv("telemetry.metrics.prometheus_instruments", "METRICS_PROMETHEUS_INSTRUMENTS")
+	viper.BindEnv("telemetry.metrics.otlp_instruments", "METRICS_OTLP_INSTRUMENTS")
+	viper.BindEnv("telemetry.metrics.exemplar_filter", "OTEL_METRICS_EXEMPLAR_FILTER")
+	viper.BindEnv("telemetry.logs.exporter", "OTEL_LOGS_EXPORTER")
+	viper.BindEnv("telemetry.logs.queue_size", "OTEL_BLRP_MAX_QUEUE_SIZE")
+	viper.BindEnv("telemetry.logs.batch_size", "OTEL_BLRP_MAX_EXPORT_BATCH_SIZE")
+	viper.BindEnv("telemetry.logs.interval", "OTEL_BLRP_SCHEDULE_DELAY")
+	viper.BindEnv("telemetry.sampling.sampler", "OTEL_TRACES_SAMPLER")
+	viper.BindEnv("telemetry.sampling.arg", "OTEL_TRACES_SAMPLER_ARG")
 
 	// Rate Limiting
 	viper.BindEnv("rate_limit.requests_per_minute", "RATE_LIMIT_REQUESTS_PER_MINUTE")
 	viper.BindEnv("rate_limit.burst_size", "RATE_LIMIT_BURST_SIZE")
+
+	viper.BindEnv("reconciliation.enabled", "RECONCILIATION_ENABLED")
+	viper.BindEnv("reconciliation.interval", "RECONCILIATION_INTERVAL")
+	viper.BindEnv("reconciliation.min_age", "RECONCILIATION_MIN_AGE")
+	viper.BindEnv("reconciliation.fail_after", "RECONCILIATION_FAIL_AFTER")
+	viper.BindEnv("reconciliation.batch_size", "RECONCILIATION_BATCH_SIZE")
+	viper.BindEnv("reconciliation.rate_per_second", "RECONCILIATION_RATE_PER_SECOND")
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
@@ -137,6 +138,13 @@ func (m *MongoDB) CreateIndexes(ctx context.Context) error {
 		{Keys: map[string]interface{}{"type": 1}},
 		{Keys: map[string]interface{}{"expires_at": 1}, Options: options.Index().SetSparse(true)},
 		{Keys: map[string]interface{}{"created_at": -1}},
+		// One reward per triggering event, e.g. a payment or a sign-up.
+		// Rewards without a reference are left out of it.
+		{
+			Keys: bson.D{{Key: "source", Value: 1}, {Key: "reference", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"reference": bson.M{"$type": "string"}}),
+		},
 	}
 	if _, err := m.RewardsCollection().Indexes().CreateMany(ctx, rewardsIndexes); err != nil {
 		return fmt.Errorf("failed to create rewards indexes: %w", err)
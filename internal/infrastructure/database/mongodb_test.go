@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/webbies/otel-fiber-demo/internal/core/entities"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// testMongoDB connects to MONGODB_TEST_URI with a throwaway database, which
+// is dropped when the test ends. The test is skipped when it is unset.
+func testMongoDB(t *testing.T) *MongoDB {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	m, err := NewMongoDB(&config.DatabaseConfig{MongoURI: uri})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Database = m.Client.Database(fmt.Sprintf("otel_demo_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		ctx := context.Background()
+		m.Database.Drop(ctx)
+		m.Disconnect(ctx)
+	})
+	return m
+}
+
+// TestRewardsIndex checks that rewards are unique per source and reference,
+// and that any number of rewards without a reference can share a source
+func TestRewardsIndex(t *testing.T) {
+	m := testMongoDB(t)
+	ctx := context.Background()
+	if err := m.CreateIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reward := func(reference string) entities.Reward {
+		return entities.Reward{
+			ID:        primitive.NewObjectID(),
+			UserID:    primitive.NewObjectID(),
+			Type:      entities.RewardTypePoints,
+			Points:    10,
+			Source:    entities.RewardSourceBonus,
+			Reference: reference,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+	}
+
+	rewards := m.RewardsCollection()
+	for i := 0; i < 2; i++ {
+		if _, err := rewards.InsertOne(ctx, reward("")); err != nil {
+			t.Fatalf("reward %d without a reference: %v", i+1, err)
+		}
+	}
+
+	if _, err := rewards.InsertOne(ctx, reward("payment-1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rewards.InsertOne(ctx, reward("payment-1")); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("second reward for payment-1: got %v, want a duplicate key error", err)
+	}
+}
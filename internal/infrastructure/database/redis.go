@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -95,6 +96,46 @@ func (r *Redis) Set(ctx context.Context, key string, value interface{}, expirati
 	return err
 }
 
+// SetNX sets key only when it does not exist and reports whether it did
+func (r *Redis) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	ctx, span := r.tracer.Start(ctx, "redis.setnx",
+		trace.WithAttributes(
+			attribute.String("redis.key", key),
+			attribute.String("redis.expiration", expiration.String()),
+		),
+	)
+	defer span.End()
+
+	ok, err := r.Client.SetNX(ctx, key, value, expiration).Result()
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.SetAttributes(attribute.Bool("redis.key_set", ok))
+	return ok, err
+}
+
+// unlockScript deletes a lock only while it still holds the caller's token
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// TryLock takes the lock key for ttl unless another holder has it. unlock
+// releases it, unless it expired and was taken by someone else since.
+func (r *Redis) TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(context.Context) error, ok bool, err error) {
+	token := uuid.NewString()
+	ok, err = r.SetNX(ctx, key, token, ttl)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	return func(ctx context.Context) error {
+		return unlockScript.Run(ctx, r.Client, []string{key}, token).Err()
+	}, true, nil
+}
+
 func (r *Redis) Del(ctx context.Context, keys ...string) error {
 	ctx, span := r.tracer.Start(ctx, "redis.del",
 		trace.WithAttributes(
@@ -203,6 +244,106 @@ func (r *Redis) CheckRateLimit(ctx context.Context, key string, limit int, windo
 	return allowed, nil
 }
 
+// tokenBucketScript reserves a token from the bucket in KEYS[1], kept as
+// the time in microseconds at which it will be full again. The caller is
+// told how long to wait for its token; when that exceeds ARGV[3] (unless
+// negative) nothing is reserved. Redis' clock is used so replicas agree.
+var tokenBucketScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local max_wait = tonumber(ARGV[3])
+
+local full_at = tonumber(redis.call("GET", KEYS[1]) or "0")
+if full_at < now then
+	full_at = now
+end
+local wait = full_at + interval - burst * interval - now
+if wait < 0 then
+	wait = 0
+end
+if max_wait >= 0 and wait > max_wait then
+	return {0, wait}
+end
+full_at = full_at + interval
+redis.call("SET", KEYS[1], string.format("%d", full_at), "PX", math.ceil((full_at - now) / 1000) + 1)
+return {1, wait}
+`)
+
+// delayBucketScript empties the bucket in KEYS[1] until ARGV[3]
+// microseconds from now, after which it refills as usual
+var delayBucketScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local full_at = now + tonumber(ARGV[3]) + (burst - 1) * interval
+if full_at > tonumber(redis.call("GET", KEYS[1]) or "0") then
+	redis.call("SET", KEYS[1], string.format("%d", full_at), "PX", math.ceil((full_at - now) / 1000) + 1)
+end
+return 0
+`)
+
+// ReserveToken takes a token from the bucket at key, which holds burst
+// tokens and gains one every interval, and returns how long the caller must
+// wait before using it. When the wait would exceed maxWait no token is
+// taken and ok is false; a negative maxWait waits for as long as it takes.
+func (r *Redis) ReserveToken(ctx context.Context, key string, interval time.Duration, burst int, maxWait time.Duration) (wait time.Duration, ok bool, err error) {
+	ctx, span := r.tracer.Start(ctx, "redis.reserve_token",
+		trace.WithAttributes(
+			attribute.String("redis.key", key),
+			attribute.String("rate_limit.interval", interval.String()),
+			attribute.Int("rate_limit.burst", burst),
+		),
+	)
+	defer span.End()
+
+	maxWaitMicros := int64(-1)
+	if maxWait >= 0 {
+		maxWaitMicros = maxWait.Microseconds()
+	}
+	result, err := tokenBucketScript.Run(ctx, r.Client, []string{key},
+		interval.Microseconds(), burst, maxWaitMicros).Int64Slice()
+	if err != nil {
+		span.RecordError(err)
+		return 0, false, err
+	}
+	if len(result) != 2 {
+		err := fmt.Errorf("unexpected token bucket reply %v", result)
+		span.RecordError(err)
+		return 0, false, err
+	}
+
+	ok = result[0] == 1
+	wait = time.Duration(result[1]) * time.Microsecond
+	span.SetAttributes(
+		attribute.Bool("rate_limit.allowed", ok),
+		attribute.String("rate_limit.wait", wait.String()),
+	)
+	return wait, ok, nil
+}
+
+// DelayBucket holds back every token of the bucket at key for delay, as
+// after a provider asked us to back off
+func (r *Redis) DelayBucket(ctx context.Context, key string, interval time.Duration, burst int, delay time.Duration) error {
+	ctx, span := r.tracer.Start(ctx, "redis.delay_bucket",
+		trace.WithAttributes(
+			attribute.String("redis.key", key),
+			attribute.String("rate_limit.delay", delay.String()),
+		),
+	)
+	defer span.End()
+
+	err := delayBucketScript.Run(ctx, r.Client, []string{key},
+		interval.Microseconds(), burst, delay.Microseconds()).Err()
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
 // Tracing hook for Redis operations
 type tracingHook struct {
 	tracer trace.Tracer
@@ -0,0 +1,67 @@
+package external
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultBatchConcurrency applies when a client's batch concurrency is unset
+const defaultBatchConcurrency = 8
+
+// dedupe returns the distinct requests of reqs and, for each request, the
+// index of its distinct one
+func dedupe[T comparable](reqs []T) (unique []T, index []int) {
+	seen := make(map[T]int, len(reqs))
+	index = make([]int, len(reqs))
+	for i, req := range reqs {
+		j, ok := seen[req]
+		if !ok {
+			j = len(unique)
+			seen[req] = j
+			unique = append(unique, req)
+		}
+		index[i] = j
+	}
+	return unique, index
+}
+
+// fanOut calls fn for every index below n with at most concurrency calls in
+// flight and returns their errors by index. Indexes not started before ctx
+// is done get its error.
+func fanOut(ctx context.Context, n, concurrency int, fn func(ctx context.Context, i int) error) []error {
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	errs := make([]error, n)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			for ; i < n; i++ {
+				errs[i] = ctx.Err()
+			}
+			wg.Wait()
+			return errs
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = fn(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Cache keeps provider responses for as long as they stay valid.
+// *database.Redis implements it.
+type Cache interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+}
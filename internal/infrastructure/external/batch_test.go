@@ -0,0 +1,262 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// memoryCache is a Cache that honours expirations
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !time.Now().Before(e.expiresAt) {
+		return "", errors.New("cache miss")
+	}
+	return e.value, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = memoryEntry{value: fmt.Sprint(value), expiresAt: time.Now().Add(expiration)}
+	return nil
+}
+
+// callCounter counts a stand-in's calls per product and its most calls in
+// flight at once
+type callCounter struct {
+	mu       sync.Mutex
+	calls    map[string]int
+	requests int
+	inFlight int
+	peak     int
+}
+
+func (c *callCounter) start(products ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	for _, p := range products {
+		c.calls[p]++
+	}
+	c.inFlight++
+	c.peak = max(c.peak, c.inFlight)
+}
+
+func (c *callCounter) end() {
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+}
+
+func (c *callCounter) snapshot() (calls map[string]int, requests, peak int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls = make(map[string]int, len(c.calls))
+	for p, n := range c.calls {
+		calls[p] = n
+	}
+	return calls, c.requests, c.peak
+}
+
+var batchTestResilience = config.ResilienceConfig{
+	Timeout:          5 * time.Second,
+	FailureThreshold: 100,
+	OpenTimeout:      time.Second,
+	HalfOpenProbes:   1,
+}
+
+// TestPricingBatch prices p1, p2 and p3, p1 twice, with p2's quote already
+// expired and p4 unknown to the stand-in
+func TestPricingBatch(t *testing.T) {
+	counter := &callCounter{calls: map[string]int{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PricingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		counter.start(req.ProductID)
+		defer counter.end()
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		validUntil := time.Now().Add(time.Minute)
+		switch req.ProductID {
+		case "p2":
+			validUntil = time.Now().Add(-time.Second)
+		case "p4":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"error":"unknown_product","message":"no price for p4"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(PricingResponse{
+			ProductID:  req.ProductID,
+			BasePrice:  float64(req.Quantity),
+			FinalPrice: float64(req.Quantity),
+			Currency:   "EUR",
+			ValidUntil: validUntil,
+		})
+	}))
+	defer srv.Close()
+
+	client := NewMADAPIClient(&config.MADAPIConfig{
+		BaseURL:            srv.URL,
+		APIKey:             "check",
+		PricingConcurrency: 2,
+		Resilience:         batchTestResilience,
+	}, nil, nil, nil, &memoryCache{entries: map[string]memoryEntry{}})
+	ctx := context.Background()
+
+	reqs := []PricingRequest{
+		{ProductID: "p1", Quantity: 1},
+		{ProductID: "p2", Quantity: 2},
+		{ProductID: "p1", Quantity: 1},
+		{ProductID: "p3", Quantity: 3},
+	}
+	prices, err := client.GetPricingBatch(ctx, reqs)
+	if err != nil {
+		t.Errorf("pricing batch: %v", err)
+	}
+	checkBatchOrder(t, "pricing batch", reqs, prices,
+		func(r PricingRequest) string { return r.ProductID }, func(p *PricingResponse) string { return p.ProductID })
+	calls, _, peak := counter.snapshot()
+	if calls["p1"] != 1 || calls["p2"] != 1 || calls["p3"] != 1 {
+		t.Errorf("pricing batch: calls per product %v, want one each", calls)
+	}
+	if peak > 2 {
+		t.Errorf("pricing batch: %d calls in flight, want at most 2", peak)
+	}
+
+	reqs = append(reqs, PricingRequest{ProductID: "p4", Quantity: 1})
+	prices, err = client.GetPricingBatch(ctx, reqs)
+	if !IsInvalidRequest(err) {
+		t.Errorf("pricing batch with an unknown product: got %v, want its 422", err)
+	}
+	if len(prices) != len(reqs) || prices[0] == nil || prices[3] == nil || prices[4] != nil {
+		t.Errorf("pricing batch with an unknown product: the other prices were not returned")
+	}
+	calls, _, _ = counter.snapshot()
+	if calls["p1"] != 1 || calls["p3"] != 1 {
+		t.Errorf("pricing batch: cached prices fetched again, calls per product %v", calls)
+	}
+	if calls["p2"] != 2 {
+		t.Errorf("pricing batch: expired quote called %d times, want 2", calls["p2"])
+	}
+}
+
+// TestInventoryBatch checks five products, one of them twice, one per call
+// and through the batch endpoint in batches of two
+func TestInventoryBatch(t *testing.T) {
+	for _, batchSize := range []int{0, 2} {
+		t.Run(fmt.Sprintf("batch size %d", batchSize), func(t *testing.T) {
+			testInventoryBatch(t, batchSize)
+		})
+	}
+}
+
+func testInventoryBatch(t *testing.T, batchSize int) {
+	name := fmt.Sprintf("inventory batch of %d", batchSize)
+	counter := &callCounter{calls: map[string]int{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var items []InventoryRequest
+		if r.URL.Path == "/inventory/check/batch" {
+			var req inventoryBatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			items = req.Items
+		} else {
+			var req InventoryRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			items = []InventoryRequest{req}
+		}
+		products := make([]string, len(items))
+		resp := make([]InventoryResponse, len(items))
+		for i, item := range items {
+			products[i] = item.ProductID
+			resp[i] = InventoryResponse{ProductID: item.ProductID, Available: true, StockLevel: 10}
+		}
+		counter.start(products...)
+		defer counter.end()
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/inventory/check/batch" {
+			json.NewEncoder(w).Encode(inventoryBatchResponse{Items: resp})
+			return
+		}
+		json.NewEncoder(w).Encode(resp[0])
+	}))
+	defer srv.Close()
+
+	client := NewSOAClient(&config.SOAConfig{
+		BaseURL:              srv.URL,
+		APIKey:               "check",
+		InventoryBatchSize:   batchSize,
+		InventoryConcurrency: 2,
+		Resilience:           batchTestResilience,
+	}, nil, nil, nil)
+
+	reqs := []InventoryRequest{
+		{ProductID: "p1", Quantity: 1},
+		{ProductID: "p2", Quantity: 1},
+		{ProductID: "p3", Quantity: 1},
+		{ProductID: "p1", Quantity: 1},
+		{ProductID: "p4", Quantity: 1},
+		{ProductID: "p5", Quantity: 1},
+	}
+	stock, err := client.CheckInventoryBatch(context.Background(), reqs)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+	}
+	checkBatchOrder(t, name, reqs, stock,
+		func(r InventoryRequest) string { return r.ProductID }, func(s *InventoryResponse) string { return s.ProductID })
+
+	calls, requests, peak := counter.snapshot()
+	for _, p := range []string{"p1", "p2", "p3", "p4", "p5"} {
+		if calls[p] != 1 {
+			t.Errorf("%s: %s checked %d times, want once", name, p, calls[p])
+		}
+	}
+	wantRequests := 5
+	if batchSize > 0 {
+		wantRequests = (5 + batchSize - 1) / batchSize
+	}
+	if requests != wantRequests {
+		t.Errorf("%s: %d requests, want %d", name, requests, wantRequests)
+	}
+	if peak > 2 {
+		t.Errorf("%s: %d requests in flight, want at most 2", name, peak)
+	}
+}
+
+// checkBatchOrder expects a result for every request, in request order
+func checkBatchOrder[Req, Resp any](t *testing.T, name string, reqs []Req, results []*Resp, reqProduct func(Req) string, product func(*Resp) string) {
+	t.Helper()
+	if len(results) != len(reqs) {
+		t.Errorf("%s: %d results for %d requests", name, len(results), len(reqs))
+		return
+	}
+	for i, result := range results {
+		if result == nil || product(result) != reqProduct(reqs[i]) {
+			t.Errorf("%s: result %d is not for %s", name, i, reqProduct(reqs[i]))
+			return
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// contractDir holds one contract per client call, <service>/<operation>.json
+const contractDir = "testdata/contracts"
+
+// contractAnyValue in a contract header only requires the header, for
+// values that change per request such as signatures
+const contractAnyValue = "*"
+
+// contract pins a client call: the request the client must send, and
+// provider responses the client must decode without losing fields
+type contract struct {
+	Request struct {
+		Method string `json:"method"`
+		// Path includes the query string, if any
+		Path    string            `json:"path"`
+		Headers map[string]string `json:"headers"`
+		// Body is absent for calls without one
+		Body json.RawMessage `json:"body,omitempty"`
+	} `json:"request"`
+	Response contractResponse `json:"response"`
+	// Error, when present, is an error response the client must return as
+	// an APIError
+	Error *contractResponse `json:"error,omitempty"`
+}
+
+type contractResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+type contractClients struct {
+	mtnPay *MTNPayClient
+	madapi *MADAPIClient
+	soa    *SOAClient
+}
+
+// contractCalls makes each pinned call with fixed arguments. A call and its
+// contract file are added together.
+var contractCalls = map[string]func(context.Context, *contractClients) (interface{}, error){
+	"mtnpay/process_payment": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.mtnPay.ProcessPayment(ctx, MTNPayRequest{
+			Amount:      150.5,
+			Currency:    "EUR",
+			PhoneNumber: "233241234567",
+			Reference:   "order-1001",
+			Description: "Order 1001",
+			Metadata:    map[string]string{"order_id": "1001"},
+		})
+	},
+	"mtnpay/get_payment_status": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.mtnPay.GetPaymentStatus(ctx, "MTN-0001")
+	},
+	"mtnpay/get_balance": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.mtnPay.GetBalance(ctx, "233241234567")
+	},
+	"madapi/validate_user": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.madapi.ValidateUser(ctx, UserValidationRequest{
+			UserID:   "user-1",
+			Email:    "ama@example.com",
+			Phone:    "233241234567",
+			Document: "GHA-123456789-0",
+		})
+	},
+	"madapi/get_pricing": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.madapi.GetPricing(ctx, PricingRequest{ProductID: "prod-001", Quantity: 10, UserID: "user-1", Region: "GH"})
+	},
+	"madapi/validate_reward": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.madapi.ValidateReward(ctx, RewardValidationRequest{UserID: "user-1", RewardType: "cashback", Points: 1500, Amount: 10})
+	},
+	"madapi/get_user_profile": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.madapi.GetUserProfile(ctx, "user-1")
+	},
+	"soa/check_inventory": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.soa.CheckInventory(ctx, InventoryRequest{ProductID: "prod-001", Quantity: 2, Location: "accra-1"})
+	},
+	"soa/check_inventory_batch": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.soa.checkInventoryBatch(ctx, []InventoryRequest{
+			{ProductID: "prod-001", Quantity: 2, Location: "accra-1"},
+			{ProductID: "prod-002", Quantity: 1},
+		})
+	},
+	"soa/create_shipping": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.soa.CreateShipping(ctx, ShippingRequest{
+			OrderID: "order-1001",
+			UserID:  "user-1",
+			Items: []ShippingItem{
+				{ProductID: "prod-001", Name: "Notebook", Quantity: 2, Weight: 0.4},
+			},
+			Address: Address{
+				Street:     "12 Independence Ave",
+				City:       "Accra",
+				State:      "Greater Accra",
+				PostalCode: "GA-100",
+				Country:    "GH",
+			},
+			Weight:     0.8,
+			Dimensions: Dimensions{Length: 30, Width: 20, Height: 10},
+		})
+	},
+	"soa/get_product_catalog": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.soa.GetProductCatalog(ctx, ProductCatalogRequest{
+			Category: "books",
+			Tags:     []string{"sale"},
+			MinPrice: 5,
+			MaxPrice: 50,
+			Limit:    10,
+			Offset:   20,
+		})
+	},
+	"soa/get_shipping_status": func(ctx context.Context, c *contractClients) (interface{}, error) {
+		return c.soa.GetShippingStatus(ctx, "SHP-0001")
+	},
+}
+
+// TestContracts holds the clients to the contracts in testdata/contracts:
+// each call must send exactly the pinned request, and decoding the pinned
+// response must keep every field, so renamed tags or provider changes fail
+func TestContracts(t *testing.T) {
+	files, err := contractFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range contractCalls {
+		if !files[name] {
+			t.Errorf("%s: no contract file", name)
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			call, ok := contractCalls[name]
+			if !ok {
+				t.Fatal("contract has no call")
+			}
+			testContract(t, name, call)
+		})
+	}
+}
+
+func contractFiles() (map[string]bool, error) {
+	files := map[string]bool{}
+	services, err := os.ReadDir(contractDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contracts: %w", err)
+	}
+	for _, service := range services {
+		entries, err := os.ReadDir(filepath.Join(contractDir, service.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read contracts: %w", err)
+		}
+		for _, entry := range entries {
+			if operation, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+				files[service.Name()+"/"+operation] = true
+			}
+		}
+	}
+	return files, nil
+}
+
+func testContract(t *testing.T, name string, call func(context.Context, *contractClients) (interface{}, error)) {
+	data, err := os.ReadFile(filepath.Join(contractDir, filepath.FromSlash(name)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c contract
+	if err := json.Unmarshal(data, &c); err != nil {
+		t.Fatalf("invalid contract: %v", err)
+	}
+
+	req, result, err := recordCall(call, c.Response)
+	switch {
+	case err != nil:
+		t.Errorf("call failed: %v", err)
+	case req == nil:
+		t.Errorf("client sent no request")
+	default:
+		compareRequest(t, c, req)
+		compareResponse(t, c.Response.Body, result)
+	}
+
+	if c.Error != nil {
+		_, _, err := recordCall(call, *c.Error)
+		compareError(t, name, *c.Error, err)
+	}
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// recordCall makes the call against a stand-in answering with resp and
+// returns the request it received
+func recordCall(call func(context.Context, *contractClients) (interface{}, error), resp contractResponse) (*recordedRequest, interface{}, error) {
+	var mu sync.Mutex
+	var recorded *recordedRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		recorded = &recordedRequest{method: r.Method, path: r.URL.RequestURI(), header: r.Header.Clone(), body: body}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		for key, value := range resp.Headers {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(resp.Status)
+		w.Write(resp.Body)
+	}))
+	defer srv.Close()
+
+	// No retries, so an error fixture is sent once
+	resilience := config.ResilienceConfig{
+		Timeout:          5 * time.Second,
+		FailureThreshold: 5,
+		OpenTimeout:      time.Second,
+		HalfOpenProbes:   1,
+	}
+	clients := &contractClients{
+		mtnPay: NewMTNPayClient(&config.MTNPayConfig{
+			BaseURL:    srv.URL,
+			APIKey:     "contract-mtnpay-key",
+			Secret:     "contract-mtnpay-secret",
+			Resilience: resilience,
+		}, nil, nil, nil),
+		madapi: NewMADAPIClient(&config.MADAPIConfig{
+			BaseURL:    srv.URL,
+			APIKey:     "contract-madapi-key",
+			Resilience: resilience,
+		}, nil, nil, nil, nil),
+		soa: NewSOAClient(&config.SOAConfig{
+			BaseURL:    srv.URL,
+			APIKey:     "contract-soa-key",
+			Resilience: resilience,
+		}, nil, nil, nil),
+	}
+
+	result, err := call(context.Background(), clients)
+
+	mu.Lock()
+	defer mu.Unlock()
+	return recorded, result, err
+}
+
+func compareRequest(t *testing.T, c contract, req *recordedRequest) {
+	t.Helper()
+	if req.method != c.Request.Method {
+		t.Errorf("request method %s, contract has %s", req.method, c.Request.Method)
+	}
+	if req.path != c.Request.Path {
+		t.Errorf("request path %s, contract has %s", req.path, c.Request.Path)
+	}
+
+	keys := make([]string, 0, len(c.Request.Headers))
+	for key := range c.Request.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		want, got := c.Request.Headers[key], req.header.Get(key)
+		switch {
+		case got == "":
+			t.Errorf("request header %s missing", key)
+		case want != contractAnyValue && got != want:
+			t.Errorf("request header %s is %q, contract has %q", key, got, want)
+		}
+	}
+
+	if len(c.Request.Body) == 0 {
+		if len(req.body) > 0 {
+			t.Errorf("request has a body, contract has none: %s", req.body)
+		}
+		return
+	}
+	var want, got interface{}
+	if err := json.Unmarshal(c.Request.Body, &want); err != nil {
+		t.Errorf("invalid contract request body: %v", err)
+		return
+	}
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Errorf("request body is not JSON: %v", err)
+		return
+	}
+	for _, diff := range jsonDiff("request body", want, got) {
+		t.Error(diff)
+	}
+}
+
+// compareResponse encodes what the client decoded and compares it to the
+// fixture: a field the struct dropped, renamed or reshaped shows up here
+func compareResponse(t *testing.T, fixture json.RawMessage, result interface{}) {
+	t.Helper()
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		t.Errorf("failed to encode decoded response: %v", err)
+		return
+	}
+
+	var want, got interface{}
+	if err := json.Unmarshal(fixture, &want); err != nil {
+		t.Errorf("invalid contract response body: %v", err)
+		return
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range jsonDiff("response body", want, got) {
+		t.Error(diff)
+	}
+}
+
+// compareError checks the client returned the error fixture as an APIError
+func compareError(t *testing.T, name string, fixture contractResponse, err error) {
+	t.Helper()
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		t.Errorf("error response %d: client returned %v, want an APIError", fixture.Status, err)
+		return
+	}
+
+	var body struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if jerr := json.Unmarshal(fixture.Body, &body); jerr != nil {
+		t.Errorf("invalid contract error body: %v", jerr)
+		return
+	}
+
+	got := fmt.Sprintf("%s/%s %d %s %q", apiErr.Provider, apiErr.Operation, apiErr.StatusCode, apiErr.Code, apiErr.Message)
+	want := fmt.Sprintf("%s %d %s %q", name, fixture.Status, body.Error, body.Message)
+	if got != want {
+		t.Errorf("error response: client returned %s, want %s", got, want)
+	}
+	if value, ok := fixture.Headers["Retry-After"]; ok {
+		if want := parseRetryAfter(value, time.Now()); apiErr.RetryAfter != want {
+			t.Errorf("error response: retry after %s, want %s", apiErr.RetryAfter, want)
+		}
+	}
+}
+
+// jsonDiff lists the differences between two decoded JSON values
+func jsonDiff(at string, want, got interface{}) []string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want an object, got %s", at, jsonText(got))}
+		}
+		keys := map[string]bool{}
+		for key := range w {
+			keys[key] = true
+		}
+		for key := range g {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		var diffs []string
+		for _, key := range sorted {
+			wv, inWant := w[key]
+			gv, inGot := g[key]
+			switch {
+			case !inGot:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: missing, want %s", at, key, jsonText(wv)))
+			case !inWant:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: not in contract, got %s", at, key, jsonText(gv)))
+			default:
+				diffs = append(diffs, jsonDiff(at+"."+key, wv, gv)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			return []string{fmt.Sprintf("%s: want %s, got %s", at, jsonText(want), jsonText(got))}
+		}
+		var diffs []string
+		for i := range w {
+			diffs = append(diffs, jsonDiff(fmt.Sprintf("%s[%d]", at, i), w[i], g[i])...)
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		return []string{fmt.Sprintf("%s: want %s, got %s", at, jsonText(want), jsonText(got))}
+	}
+	return nil
+}
+
+func jsonText(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,114 @@
+package external
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// APIError is an error response from a provider. Clients return it for
+// every 4xx and 5xx answer; transport failures, timeouts and rejections by
+// the breaker or bulkhead are not APIErrors.
+type APIError struct {
+	// Provider is the client's service name, e.g. "mtnpay"
+	Provider string
+	// Operation is the client call, e.g. "process_payment"
+	Operation  string
+	StatusCode int
+	// Code is the provider's error code, e.g. "insufficient_funds"
+	Code    string
+	Message string
+	// Retryable reports whether the same request may succeed later: on
+	// 408, 429 and 5xx other than 501
+	Retryable bool
+	// RetryAfter is the wait the provider asked for, zero when it did not
+	RetryAfter time.Duration
+
+	summary string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s - %s", e.summary, e.Code, e.Message)
+}
+
+// newAPIError builds the error for resp; summary reads like "MTN Pay
+// payment failed"
+func newAPIError(provider string, resp *resty.Response, summary, code, message string) *APIError {
+	operation, _ := resp.Request.Context().Value(operationKey{}).(string)
+	status := resp.StatusCode()
+	if code == "" {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+
+	return &APIError{
+		Provider:   provider,
+		Operation:  operation,
+		StatusCode: status,
+		Code:       code,
+		Message:    message,
+		Retryable: status == http.StatusRequestTimeout || status == http.StatusTooManyRequests ||
+			(status >= 500 && status != http.StatusNotImplemented),
+		RetryAfter: parseRetryAfter(resp.Header().Get("Retry-After"), time.Now()),
+		summary:    summary,
+	}
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// AsAPIError returns the APIError in err's chain
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// IsRateLimited reports whether a provider answered 429
+func IsRateLimited(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+// IsNotFound reports whether a provider answered 404
+func IsNotFound(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsInvalidRequest reports whether a provider rejected the request's
+// content with 400 or 422
+func IsInvalidRequest(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity)
+}
+
+// IsRetryable reports whether the call may succeed if made again later: a
+// retryable APIError, or a call the breaker, rate limiter or bulkhead
+// turned away
+func IsRetryable(err error) bool {
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.Retryable
+	}
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBulkheadFull) || errors.Is(err, ErrRateLimited)
+}
@@ -0,0 +1,189 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
+)
+
+// Outcomes recorded on the external API metrics
+const (
+	outcomeSuccess  = "success"
+	outcomeError    = "error"
+	outcomeTimeout  = "timeout"
+	outcomeRejected = "rejected"
+)
+
+// errAttemptFailed ends the span of an attempt that was retried before a
+// response was read
+var errAttemptFailed = errors.New("request attempt failed")
+
+type operationKey struct{}
+
+type callKey struct{}
+
+// newRequest starts a request recorded under operation. Paths should use
+// path params ("/payments/{transactionID}") so the span name and url.template
+// stay low-cardinality.
+func newRequest(ctx context.Context, client *resty.Client, operation string) *resty.Request {
+	return client.R().SetContext(context.WithValue(ctx, operationKey{}, operation))
+}
+
+// call tracks the attempts of one request. Each attempt gets its own client
+// span, a child of the caller's span.
+type call struct {
+	parent    context.Context
+	operation string
+	span      trace.Span
+	start     time.Time
+}
+
+// instrumentation records a client span and the external API metrics for
+// every attempt of every request of a client, and injects the trace context
+// into the request headers
+type instrumentation struct {
+	service string
+	tracer  trace.Tracer
+	metrics *observability.BusinessMetrics
+}
+
+func instrument(client *resty.Client, service string, tracer trace.Tracer, metrics *observability.BusinessMetrics) {
+	in := &instrumentation{service: service, tracer: tracer, metrics: metrics}
+	client.OnBeforeRequest(in.beforeRequest)
+	client.OnAfterResponse(in.afterResponse)
+	client.OnError(in.onError)
+}
+
+// beforeRequest runs before resty resolves the URL, so r.URL is still the
+// path template
+func (in *instrumentation) beforeRequest(_ *resty.Client, r *resty.Request) error {
+	ctx := r.Context()
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		c = &call{}
+		c.parent = context.WithValue(ctx, callKey{}, c)
+		c.operation, _ = ctx.Value(operationKey{}).(string)
+		if c.operation == "" {
+			c.operation = r.Method + " " + r.URL
+		}
+	} else if c.span != nil {
+		in.finish(c, r, nil, errAttemptFailed)
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(r.Method),
+		semconv.URLTemplate(r.URL),
+		semconv.PeerService(in.service),
+		attribute.String("external.operation", c.operation),
+	}
+	if r.Attempt > 1 {
+		attrs = append(attrs, semconv.HTTPRequestResendCount(r.Attempt-1))
+	}
+
+	ctx, span := in.tracer.Start(c.parent, r.Method+" "+r.URL,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	c.span = span
+	c.start = time.Now()
+	r.SetContext(ctx)
+	return nil
+}
+
+func (in *instrumentation) afterResponse(_ *resty.Client, resp *resty.Response) error {
+	if c, ok := resp.Request.Context().Value(callKey{}).(*call); ok && c.span != nil {
+		in.finish(c, resp.Request, resp, nil)
+	}
+	return nil
+}
+
+// onError ends the last attempt when it failed before the response
+// middleware ran, e.g. on a transport error or an undecodable body
+func (in *instrumentation) onError(r *resty.Request, err error) {
+	c, ok := r.Context().Value(callKey{}).(*call)
+	if !ok || c.span == nil {
+		return
+	}
+	var resp *resty.Response
+	var respErr *resty.ResponseError
+	if errors.As(err, &respErr) {
+		resp, err = respErr.Response, respErr.Err
+	}
+	in.finish(c, r, resp, err)
+}
+
+func (in *instrumentation) finish(c *call, r *resty.Request, resp *resty.Response, err error) {
+	span := c.span
+	c.span = nil
+
+	if r.RawRequest != nil {
+		u := *r.RawRequest.URL
+		u.User, u.RawQuery = nil, ""
+		span.SetAttributes(semconv.URLFull(u.String()), semconv.ServerAddress(u.Hostname()))
+		if port, perr := strconv.Atoi(u.Port()); perr == nil {
+			span.SetAttributes(semconv.ServerPort(port))
+		}
+	}
+
+	statusClass, outcome := "none", outcomeSuccess
+	if resp != nil && resp.RawResponse != nil {
+		code := resp.StatusCode()
+		statusClass = strconv.Itoa(code/100) + "xx"
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		if code >= 400 {
+			outcome = outcomeError
+			span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(code)))
+			span.SetStatus(codes.Error, "")
+		}
+	}
+	if err != nil {
+		outcome = outcomeError
+		switch {
+		case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrBulkheadFull), errors.Is(err, ErrRateLimited):
+			outcome = outcomeRejected
+		case isTimeout(err):
+			outcome = outcomeTimeout
+		}
+		span.SetAttributes(semconv.ErrorTypeKey.String(outcome))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	if in.metrics == nil {
+		return
+	}
+	// Recorded in the client span's context so exemplars link to it
+	ctx := trace.ContextWithSpan(c.parent, span)
+	attrs := metric.WithAttributes(
+		attribute.String("service", in.service),
+		attribute.String("operation", c.operation),
+		attribute.String("status_class", statusClass),
+		attribute.String("outcome", outcome),
+	)
+	in.metrics.ExternalAPICounter.Add(ctx, 1, attrs)
+	in.metrics.ExternalAPIDuration.Record(ctx, time.Since(c.start).Seconds(), attrs)
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -2,7 +2,11 @@ package external
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -11,28 +15,49 @@ import (
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
 )
 
 type MADAPIClient struct {
-	client *resty.Client
-	config *config.MADAPIConfig
-	tracer trace.Tracer
+	client  *resty.Client
+	config  *config.MADAPIConfig
+	tracer  trace.Tracer
+	breaker *CircuitBreaker
+	prices  Cache
 }
 
-func NewMADAPIClient(cfg *config.MADAPIConfig) *MADAPIClient {
+// NewMADAPIClient authenticates with OAuth2 client credentials when
+// cfg.OAuth2.TokenURL is set, sharing tokens through tokens (may be nil),
+// and with the API key otherwise. limits (may be nil) shares its rate limit
+// between replicas, and prices (may be nil) caches batch pricing.
+func NewMADAPIClient(cfg *config.MADAPIConfig, metrics *observability.BusinessMetrics, tokens TokenCache, limits RateLimitStore, prices Cache) *MADAPIClient {
 	client := resty.New().
 		SetBaseURL(cfg.BaseURL).
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Authorization", "Bearer "+cfg.APIKey).
-		SetTimeout(20 * time.Second)
+		SetHeader("Content-Type", "application/json")
+
+	tracer := otel.Tracer("madapi-client")
+	instrument(client, "madapi", tracer, metrics)
+	breaker := applyResilience(client, "madapi", &cfg.Resilience, metrics, limits)
+	if cfg.OAuth2.TokenURL != "" {
+		useOAuth2(client, NewTokenSource("madapi", &cfg.OAuth2, tokens), nil)
+	} else {
+		client.SetHeader("Authorization", "Bearer "+cfg.APIKey)
+	}
 
 	return &MADAPIClient{
-		client: client,
-		config: cfg,
-		tracer: otel.Tracer("madapi-client"),
+		client:  client,
+		config:  cfg,
+		tracer:  tracer,
+		breaker: breaker,
+		prices:  prices,
 	}
 }
 
+// Breaker returns the circuit breaker guarding MADAPI calls
+func (c *MADAPIClient) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
 type UserValidationRequest struct {
 	UserID   string `json:"user_id"`
 	Email    string `json:"email"`
@@ -98,8 +123,7 @@ func (c *MADAPIClient) ValidateUser(ctx context.Context, req UserValidationReque
 		Code    int    `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "validate_user").
 		SetBody(req).
 		SetResult(&response).
 		SetError(&errorResp).
@@ -110,14 +134,8 @@ func (c *MADAPIClient) ValidateUser(ctx context.Context, req UserValidationReque
 		return nil, fmt.Errorf("MADAPI user validation request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "POST"),
-		attribute.String("http.url", "/validate/user"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("MADAPI user validation failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("madapi", resp, "MADAPI user validation failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -147,8 +165,7 @@ func (c *MADAPIClient) GetPricing(ctx context.Context, req PricingRequest) (*Pri
 		Code    int    `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "get_pricing").
 		SetBody(req).
 		SetResult(&response).
 		SetError(&errorResp).
@@ -159,14 +176,8 @@ func (c *MADAPIClient) GetPricing(ctx context.Context, req PricingRequest) (*Pri
 		return nil, fmt.Errorf("MADAPI pricing request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "POST"),
-		attribute.String("http.url", "/pricing"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("MADAPI pricing failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("madapi", resp, "MADAPI pricing failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -181,6 +192,83 @@ func (c *MADAPIClient) GetPricing(ctx context.Context, req PricingRequest) (*Pri
 	return &response, nil
 }
 
+// GetPricingBatch prices many products, returning the prices in the order
+// of reqs. Repeated requests are priced once and prices are cached until
+// their ValidUntil. MADAPI has no batch endpoint, so the rest fan out with
+// at most PricingConcurrency calls in flight. When some fail, the prices
+// that were fetched are returned with the failures joined.
+func (c *MADAPIClient) GetPricingBatch(ctx context.Context, reqs []PricingRequest) ([]*PricingResponse, error) {
+	ctx, span := c.tracer.Start(ctx, "madapi.get_pricing_batch",
+		trace.WithAttributes(
+			attribute.Int("batch.size", len(reqs)),
+		),
+	)
+	defer span.End()
+
+	unique, index := dedupe(reqs)
+	prices := make([]*PricingResponse, len(unique))
+	var misses []int
+	for i, req := range unique {
+		if prices[i] = c.cachedPrice(ctx, req); prices[i] == nil {
+			misses = append(misses, i)
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("batch.unique", len(unique)),
+		attribute.Int("batch.cache_hits", len(unique)-len(misses)),
+	)
+
+	errs := fanOut(ctx, len(misses), c.config.PricingConcurrency, func(ctx context.Context, n int) error {
+		req := unique[misses[n]]
+		price, err := c.GetPricing(ctx, req)
+		if err != nil {
+			return fmt.Errorf("pricing %s: %w", req.ProductID, err)
+		}
+		prices[misses[n]] = price
+		c.cachePrice(ctx, req, price)
+		return nil
+	})
+
+	results := make([]*PricingResponse, len(reqs))
+	for i, j := range index {
+		results[i] = prices[j]
+	}
+	if err := errors.Join(errs...); err != nil {
+		span.RecordError(err)
+		return results, err
+	}
+	return results, nil
+}
+
+func pricingCacheKey(req PricingRequest) string {
+	return "madapi:pricing:" + req.ProductID + ":" + strconv.Itoa(req.Quantity) + ":" + req.UserID + ":" + req.Region
+}
+
+func (c *MADAPIClient) cachedPrice(ctx context.Context, req PricingRequest) *PricingResponse {
+	if c.prices == nil {
+		return nil
+	}
+	data, err := c.prices.Get(ctx, pricingCacheKey(req))
+	if err != nil {
+		return nil
+	}
+	var price PricingResponse
+	if err := json.Unmarshal([]byte(data), &price); err != nil || !time.Now().Before(price.ValidUntil) {
+		return nil
+	}
+	return &price
+}
+
+func (c *MADAPIClient) cachePrice(ctx context.Context, req PricingRequest, price *PricingResponse) {
+	ttl := time.Until(price.ValidUntil)
+	if c.prices == nil || ttl <= 0 {
+		return
+	}
+	if data, err := json.Marshal(price); err == nil {
+		c.prices.Set(ctx, pricingCacheKey(req), string(data), ttl)
+	}
+}
+
 func (c *MADAPIClient) ValidateReward(ctx context.Context, req RewardValidationRequest) (*RewardValidationResponse, error) {
 	ctx, span := c.tracer.Start(ctx, "madapi.validate_reward",
 		trace.WithAttributes(
@@ -198,8 +286,7 @@ func (c *MADAPIClient) ValidateReward(ctx context.Context, req RewardValidationR
 		Code    int    `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "validate_reward").
 		SetBody(req).
 		SetResult(&response).
 		SetError(&errorResp).
@@ -210,14 +297,8 @@ func (c *MADAPIClient) ValidateReward(ctx context.Context, req RewardValidationR
 		return nil, fmt.Errorf("MADAPI reward validation request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "POST"),
-		attribute.String("http.url", "/validate/reward"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("MADAPI reward validation failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("madapi", resp, "MADAPI reward validation failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -245,24 +326,19 @@ func (c *MADAPIClient) GetUserProfile(ctx context.Context, userID string) (*User
 		Code    int    `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "get_user_profile").
 		SetResult(&response).
 		SetError(&errorResp).
-		Get(fmt.Sprintf("/users/%s/profile", userID))
+		SetPathParam("userID", userID).
+		Get("/users/{userID}/profile")
 
 	if err != nil {
 		span.RecordError(err)
 		return nil, fmt.Errorf("MADAPI user profile request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "GET"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("MADAPI user profile failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("madapi", resp, "MADAPI user profile failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -290,7 +366,16 @@ func (c *MADAPIClient) SimulateRateLimit(ctx context.Context) error {
 	ctx, span := c.tracer.Start(ctx, "madapi.simulate_rate_limit")
 	defer span.End()
 
-	err := fmt.Errorf("MADAPI rate limit exceeded: 429 Too Many Requests")
+	err := &APIError{
+		Provider:   "madapi",
+		Operation:  "simulate_rate_limit",
+		StatusCode: http.StatusTooManyRequests,
+		Code:       "rate_limited",
+		Message:    "Too Many Requests",
+		Retryable:  true,
+		RetryAfter: time.Second,
+		summary:    "MADAPI rate limit exceeded",
+	}
 	span.RecordError(err)
 	span.SetAttributes(
 		attribute.Int("http.status_code", 429),
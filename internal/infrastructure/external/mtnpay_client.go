@@ -11,28 +11,52 @@ import (
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
 )
 
 type MTNPayClient struct {
-	client *resty.Client
-	config *config.MTNPayConfig
-	tracer trace.Tracer
+	client  *resty.Client
+	config  *config.MTNPayConfig
+	tracer  trace.Tracer
+	breaker *CircuitBreaker
 }
 
-func NewMTNPayClient(cfg *config.MTNPayConfig) *MTNPayClient {
+// NewMTNPayClient authenticates with OAuth2 client credentials when
+// cfg.OAuth2.TokenURL is set, sharing tokens through tokens (may be nil),
+// and with the API key otherwise. limits (may be nil) shares its rate limit
+// between replicas.
+func NewMTNPayClient(cfg *config.MTNPayConfig, metrics *observability.BusinessMetrics, tokens TokenCache, limits RateLimitStore) *MTNPayClient {
 	client := resty.New().
 		SetBaseURL(cfg.BaseURL).
-		SetHeader("Content-Type", "application/json").
-		SetHeader("X-API-Key", cfg.APIKey).
-		SetTimeout(30 * time.Second)
+		SetHeader("Content-Type", "application/json")
+
+	tracer := otel.Tracer("mtnpay-client")
+	instrument(client, "mtnpay", tracer, metrics)
+	breaker := applyResilience(client, "mtnpay", &cfg.Resilience, metrics, limits)
+	var signer *MTNPaySigner
+	if cfg.Secret != "" {
+		signer = NewMTNPaySigner(cfg.Secret)
+		client.SetPreRequestHook(signer.preRequestHook)
+	}
+	if cfg.OAuth2.TokenURL != "" {
+		useOAuth2(client, NewTokenSource("mtnpay", &cfg.OAuth2, tokens), signer)
+	} else {
+		client.SetHeader("X-API-Key", cfg.APIKey)
+	}
 
 	return &MTNPayClient{
-		client: client,
-		config: cfg,
-		tracer: otel.Tracer("mtnpay-client"),
+		client:  client,
+		config:  cfg,
+		tracer:  tracer,
+		breaker: breaker,
 	}
 }
 
+// Breaker returns the circuit breaker guarding MTNPay calls
+func (c *MTNPayClient) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
 type MTNPayRequest struct {
 	Amount      float64           `json:"amount"`
 	Currency    string            `json:"currency"`
@@ -53,6 +77,19 @@ type MTNPayResponse struct {
 	CreatedAt     time.Time         `json:"created_at"`
 }
 
+// MTNPayCallback is the body of the asynchronous payment result MTN Pay posts
+// to the webhook
+type MTNPayCallback struct {
+	TransactionID string            `json:"transaction_id"`
+	Reference     string            `json:"reference"`
+	Status        string            `json:"status"`
+	Amount        float64           `json:"amount"`
+	Currency      string            `json:"currency"`
+	FailureReason string            `json:"failure_reason,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+}
+
 type MTNPayStatusResponse struct {
 	TransactionID string     `json:"transaction_id"`
 	Status        string     `json:"status"`
@@ -80,8 +117,7 @@ func (c *MTNPayClient) ProcessPayment(ctx context.Context, req MTNPayRequest) (*
 		Message string `json:"message"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "process_payment").
 		SetBody(req).
 		SetResult(&response).
 		SetError(&errorResp).
@@ -92,14 +128,8 @@ func (c *MTNPayClient) ProcessPayment(ctx context.Context, req MTNPayRequest) (*
 		return nil, fmt.Errorf("MTN Pay API request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "POST"),
-		attribute.String("http.url", "/payments"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("MTN Pay payment failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("mtnpay", resp, "MTN Pay payment failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -126,24 +156,19 @@ func (c *MTNPayClient) GetPaymentStatus(ctx context.Context, transactionID strin
 		Message string `json:"message"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "get_payment_status").
 		SetResult(&response).
 		SetError(&errorResp).
-		Get(fmt.Sprintf("/payments/%s", transactionID))
+		SetPathParam("transactionID", transactionID).
+		Get("/payments/{transactionID}")
 
 	if err != nil {
 		span.RecordError(err)
 		return nil, fmt.Errorf("MTN Pay status request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "GET"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("MTN Pay status check failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("mtnpay", resp, "MTN Pay status check failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -169,24 +194,19 @@ func (c *MTNPayClient) GetBalance(ctx context.Context, phoneNumber string) (*Bal
 		Message string `json:"message"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "get_balance").
 		SetResult(&response).
 		SetError(&errorResp).
-		Get(fmt.Sprintf("/balance/%s", phoneNumber))
+		SetPathParam("phoneNumber", phoneNumber).
+		Get("/balance/{phoneNumber}")
 
 	if err != nil {
 		span.RecordError(err)
 		return nil, fmt.Errorf("MTN Pay balance request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "GET"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("MTN Pay balance check failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("mtnpay", resp, "MTN Pay balance check failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -0,0 +1,161 @@
+package external
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Headers carrying an MTN Pay request signature
+const (
+	HeaderMTNPayTimestamp = "X-MTN-Timestamp"
+	HeaderMTNPayNonce     = "X-MTN-Nonce"
+	HeaderMTNPayDigest    = "X-MTN-Content-SHA256"
+	HeaderMTNPaySignature = "X-MTN-Signature"
+)
+
+var (
+	// ErrSignatureMissing is returned when a signature header is absent
+	ErrSignatureMissing = errors.New("MTN Pay signature headers missing")
+	// ErrSignatureExpired is returned when the signed timestamp is further
+	// from now than the tolerance allows
+	ErrSignatureExpired = errors.New("MTN Pay signature timestamp outside tolerance")
+	// ErrSignatureMismatch is returned when the body digest or the signature
+	// does not match the request
+	ErrSignatureMismatch = errors.New("MTN Pay signature mismatch")
+)
+
+// mtnPayStringToSign joins the signed parts of a request, one per line:
+// method, request target (path and query), unix timestamp, nonce and the
+// hex SHA-256 digest of the body
+func mtnPayStringToSign(method, target, timestamp, nonce, digest string) string {
+	return strings.Join([]string{strings.ToUpper(method), target, timestamp, nonce, digest}, "\n")
+}
+
+func bodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func mtnPaySignature(secret []byte, stringToSign string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MTNPaySigner signs outbound MTN Pay requests with HMAC-SHA256
+type MTNPaySigner struct {
+	secret []byte
+	now    func() time.Time
+	nonce  func() string
+}
+
+func NewMTNPaySigner(secret string) *MTNPaySigner {
+	return &MTNPaySigner{secret: []byte(secret), now: time.Now, nonce: newNonce}
+}
+
+// Sign sets the signature headers on req. The body is read through GetBody
+// when set, so the request can still be sent.
+func (s *MTNPaySigner) Sign(req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return fmt.Errorf("failed to read MTN Pay request body: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	nonce := s.nonce()
+	digest := bodyDigest(body)
+
+	req.Header.Set(HeaderMTNPayTimestamp, timestamp)
+	req.Header.Set(HeaderMTNPayNonce, nonce)
+	req.Header.Set(HeaderMTNPayDigest, digest)
+	req.Header.Set(HeaderMTNPaySignature, mtnPaySignature(s.secret,
+		mtnPayStringToSign(req.Method, req.URL.RequestURI(), timestamp, nonce, digest)))
+	return nil
+}
+
+// preRequestHook signs every attempt, so a retry carries a fresh timestamp
+// and nonce
+func (s *MTNPaySigner) preRequestHook(_ *resty.Client, req *http.Request) error {
+	return s.Sign(req)
+}
+
+// MTNPayVerifier checks the signature of inbound MTN Pay callbacks
+type MTNPayVerifier struct {
+	secret    []byte
+	tolerance time.Duration
+	now       func() time.Time
+}
+
+func NewMTNPayVerifier(secret string, tolerance time.Duration) *MTNPayVerifier {
+	return &MTNPayVerifier{secret: []byte(secret), tolerance: tolerance, now: time.Now}
+}
+
+// Verify checks a request given its method, request target (path and
+// query), a header lookup such as http.Header.Get, and its raw body
+func (v *MTNPayVerifier) Verify(method, target string, header func(string) string, body []byte) error {
+	timestamp := header(HeaderMTNPayTimestamp)
+	nonce := header(HeaderMTNPayNonce)
+	signature := header(HeaderMTNPaySignature)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return ErrSignatureMissing
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrSignatureExpired, timestamp)
+	}
+	if skew := v.now().Sub(time.Unix(unix, 0)).Abs(); skew > v.tolerance {
+		return fmt.Errorf("%w: skew %s", ErrSignatureExpired, skew)
+	}
+
+	digest := bodyDigest(body)
+	if sent := header(HeaderMTNPayDigest); sent != "" && !hmac.Equal([]byte(sent), []byte(digest)) {
+		return fmt.Errorf("%w: body digest", ErrSignatureMismatch)
+	}
+
+	want := mtnPaySignature(v.secret, mtnPayStringToSign(method, target, timestamp, nonce, digest))
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(want)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
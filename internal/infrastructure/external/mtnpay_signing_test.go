@@ -0,0 +1,280 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+const testMTNPaySecret = "mtnpay-test-secret"
+
+// mtnPaySigningVectors were computed independently of this package (Python
+// hmac/hashlib) with testMTNPaySecret
+var mtnPaySigningVectors = []struct {
+	method, target, timestamp, nonce, body string
+	digest, signature                      string
+}{
+	{
+		method: "GET", target: "/payments/txn-123", timestamp: "1700000000",
+		nonce:     "0123456789abcdef0123456789abcdef",
+		digest:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		signature: "5aa974e2ab9d7a88af03134a668d74999726829e8c4ef7e31bb07c1c223c566b",
+	},
+	{
+		method: "POST", target: "/payments", timestamp: "1700000000",
+		nonce:     "fedcba9876543210fedcba9876543210",
+		body:      `{"amount":150.5,"currency":"EUR","msisdn":"233241234567"}`,
+		digest:    "520055099fc6ba1f9a73fa6a4a6b5a9ddea88ebaf825f92f4570280f2c2c3d78",
+		signature: "a5bb038488a531b1ccaedd357b4a3da2582affac2f3ae6043dde9ba2dbd66066",
+	},
+	{
+		method: "GET", target: "/payments?status=pending&limit=10", timestamp: "1700000060",
+		nonce:     "00000000000000000000000000000001",
+		digest:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		signature: "72d67c4dd763c7231f9b1c1bc0dac90b7f816ac679edf7cf56905fa5081cbfe1",
+	},
+}
+
+// TestMTNPaySigner signs the known-answer vectors and verifies the result
+func TestMTNPaySigner(t *testing.T) {
+	for _, v := range mtnPaySigningVectors {
+		t.Run(v.method+" "+v.target, func(t *testing.T) {
+			unix, err := strconv.ParseInt(v.timestamp, 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := time.Unix(unix, 0)
+
+			signer := NewMTNPaySigner(testMTNPaySecret)
+			signer.now = func() time.Time { return ts }
+			signer.nonce = func() string { return v.nonce }
+
+			req, err := http.NewRequest(v.method, "https://mtnpay.example.com"+v.target, strings.NewReader(v.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := signer.Sign(req); err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			for header, want := range map[string]string{
+				HeaderMTNPayTimestamp: v.timestamp,
+				HeaderMTNPayNonce:     v.nonce,
+				HeaderMTNPayDigest:    v.digest,
+				HeaderMTNPaySignature: v.signature,
+			} {
+				if got := req.Header.Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			if sent, _ := io.ReadAll(req.Body); string(sent) != v.body {
+				t.Errorf("signing consumed the request body")
+			}
+
+			verifier := NewMTNPayVerifier(testMTNPaySecret, 5*time.Minute)
+			verifier.now = func() time.Time { return ts.Add(4 * time.Minute) }
+			if err := verifier.Verify(v.method, v.target, req.Header.Get, []byte(v.body)); err != nil {
+				t.Errorf("verify: %v", err)
+			}
+		})
+	}
+}
+
+// TestMTNPayVerifierRejections tampers with a signed request and expects
+// the verifier to reject it
+func TestMTNPayVerifierRejections(t *testing.T) {
+	v := mtnPaySigningVectors[1]
+	signed := time.Unix(1700000000, 0)
+	header := func(overrides map[string]string) func(string) string {
+		h := http.Header{}
+		h.Set(HeaderMTNPayTimestamp, v.timestamp)
+		h.Set(HeaderMTNPayNonce, v.nonce)
+		h.Set(HeaderMTNPayDigest, v.digest)
+		h.Set(HeaderMTNPaySignature, v.signature)
+		for k, val := range overrides {
+			h.Set(k, val)
+		}
+		return h.Get
+	}
+
+	cases := []struct {
+		name   string
+		now    time.Time
+		target string
+		body   string
+		header func(string) string
+		want   error
+	}{
+		{"stale timestamp", signed.Add(5*time.Minute + time.Second), v.target, v.body, header(nil), ErrSignatureExpired},
+		{"future timestamp", signed.Add(-5*time.Minute - time.Second), v.target, v.body, header(nil), ErrSignatureExpired},
+		{"tampered body", signed, v.target, strings.Replace(v.body, "150.5", "1500.5", 1), header(nil), ErrSignatureMismatch},
+		{"tampered body and digest", signed, v.target, strings.Replace(v.body, "150.5", "1500.5", 1),
+			header(map[string]string{HeaderMTNPayDigest: ""}), ErrSignatureMismatch},
+		{"other target", signed, "/payments/refunds", v.body, header(nil), ErrSignatureMismatch},
+		{"other nonce", signed, v.target, v.body, header(map[string]string{HeaderMTNPayNonce: "replayed"}), ErrSignatureMismatch},
+		{"missing signature", signed, v.target, v.body, header(map[string]string{HeaderMTNPaySignature: ""}), ErrSignatureMissing},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			verifier := NewMTNPayVerifier(testMTNPaySecret, 5*time.Minute)
+			verifier.now = func() time.Time { return c.now }
+			if err := verifier.Verify(v.method, c.target, c.header, []byte(c.body)); !errors.Is(err, c.want) {
+				t.Errorf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+
+	wrongKey := NewMTNPayVerifier("other-secret", 5*time.Minute)
+	wrongKey.now = func() time.Time { return signed }
+	if err := wrongKey.Verify(v.method, v.target, header(nil), []byte(v.body)); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("verifier accepted a signature made with another secret: %v", err)
+	}
+}
+
+// TestMTNPayClientSigning sends a POST and a retried GET through the MTN Pay
+// client to a stand-in that verifies every attempt
+func TestMTNPayClientSigning(t *testing.T) {
+	verifier := NewMTNPayVerifier(testMTNPaySecret, time.Minute)
+
+	var mu sync.Mutex
+	nonces := map[string]bool{}
+	attempts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		if err := verifier.Verify(r.Method, r.URL.RequestURI(), r.Header.Get, body); err != nil {
+			t.Errorf("client %s %s: %v", r.Method, r.URL.Path, err)
+		}
+		nonce := r.Header.Get(HeaderMTNPayNonce)
+		if nonces[nonce] {
+			t.Errorf("client reused nonce %q", nonce)
+		}
+		nonces[nonce] = true
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			if attempts++; attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{}`))
+				return
+			}
+		}
+		w.Write([]byte(`{"transaction_id":"txn-1","status":"pending"}`))
+	}))
+	defer srv.Close()
+
+	client := NewMTNPayClient(&config.MTNPayConfig{
+		BaseURL: srv.URL,
+		Secret:  testMTNPaySecret,
+		Resilience: config.ResilienceConfig{
+			Timeout:          5 * time.Second,
+			MaxRetries:       1,
+			RetryWait:        time.Millisecond,
+			RetryMaxWait:     time.Millisecond,
+			FailureThreshold: 5,
+			OpenTimeout:      time.Second,
+			HalfOpenProbes:   1,
+		},
+	}, nil, nil, nil)
+	client.client.SetLogger(discardLogger{})
+
+	ctx := context.Background()
+	if _, err := client.ProcessPayment(ctx, MTNPayRequest{Amount: 10, Currency: "EUR", Reference: "ref-1"}); err != nil {
+		t.Errorf("client POST: %v", err)
+	}
+	if _, err := client.GetPaymentStatus(ctx, "txn-1"); err != nil {
+		t.Errorf("client GET: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(nonces) != 3 {
+		t.Errorf("stand-in saw %d signed attempts, want 3", len(nonces))
+	}
+}
+
+// TestMTNPayClientSigningTokenReplay rejects the first bearer token so the
+// OAuth2 transport replays the POST, which must be signed again
+func TestMTNPayClientSigningTokenReplay(t *testing.T) {
+	verifier := NewMTNPayVerifier(testMTNPaySecret, time.Minute)
+
+	var mu sync.Mutex
+	nonces := map[string]bool{}
+	tokens := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			tokens++
+			w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(tokens) + `","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if err := verifier.Verify(r.Method, r.URL.RequestURI(), r.Header.Get, body); err != nil {
+			t.Errorf("client %s %s: %v", r.Method, r.URL.Path, err)
+		}
+		nonce := r.Header.Get(HeaderMTNPayNonce)
+		if nonces[nonce] {
+			t.Errorf("client reused nonce %q", nonce)
+		}
+		nonces[nonce] = true
+
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(`{"transaction_id":"txn-1","status":"pending"}`))
+	}))
+	defer srv.Close()
+
+	client := NewMTNPayClient(&config.MTNPayConfig{
+		BaseURL: srv.URL,
+		Secret:  testMTNPaySecret,
+		OAuth2: config.OAuth2Config{
+			TokenURL:     srv.URL + "/token",
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Timeout:      5 * time.Second,
+		},
+		Resilience: config.ResilienceConfig{
+			Timeout:          5 * time.Second,
+			FailureThreshold: 5,
+			OpenTimeout:      time.Second,
+			HalfOpenProbes:   1,
+		},
+	}, nil, nil, nil)
+	client.client.SetLogger(discardLogger{})
+
+	if _, err := client.ProcessPayment(context.Background(), MTNPayRequest{Amount: 10, Currency: "EUR", Reference: "ref-1"}); err != nil {
+		t.Errorf("client POST: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(nonces) != 2 {
+		t.Errorf("stand-in saw %d signed attempts, want 2", len(nonces))
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Errorf(string, ...interface{}) {}
+func (discardLogger) Warnf(string, ...interface{})  {}
+func (discardLogger) Debugf(string, ...interface{}) {}
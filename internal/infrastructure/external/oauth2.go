@@ -0,0 +1,377 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+const (
+	// defaultTokenLifetime applies when the token response has no expires_in
+	defaultTokenLifetime = time.Hour
+	// tokenWaitInterval is how often a replica waiting for another one's
+	// token checks the cache
+	tokenWaitInterval = 100 * time.Millisecond
+)
+
+// TokenCache shares OAuth2 tokens between replicas. *database.Redis
+// implements it.
+type TokenCache interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(context.Context) error, ok bool, err error)
+}
+
+type oauth2Token struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// RefreshAt starts the refresh window: RefreshBefore ahead of the
+	// expiry, but no more than half the token's lifetime
+	RefreshAt time.Time `json:"refresh_at"`
+}
+
+// TokenSource fetches bearer tokens with the OAuth2 client credentials grant
+// and caches them in memory and in the shared cache. One replica at a time
+// fetches a token; the others wait for it to appear in the cache.
+type TokenSource struct {
+	service string
+	cfg     *config.OAuth2Config
+	client  *resty.Client
+	cache   TokenCache
+	tracer  trace.Tracer
+	now     func() time.Time
+
+	// fetchMu serialises fetches within the replica
+	fetchMu sync.Mutex
+
+	mu         sync.Mutex
+	token      *oauth2Token
+	refreshing bool
+}
+
+// NewTokenSource returns a token source for service. cache may be nil, in
+// which case every replica fetches its own tokens.
+func NewTokenSource(service string, cfg *config.OAuth2Config, cache TokenCache) *TokenSource {
+	return &TokenSource{
+		service: service,
+		cfg:     cfg,
+		client:  resty.New().SetTimeout(cfg.Timeout),
+		cache:   cache,
+		tracer:  otel.Tracer("oauth2-client"),
+		now:     time.Now,
+	}
+}
+
+// Token returns a valid access token. Within RefreshBefore of its expiry the
+// current token is still returned while a new one is fetched in the
+// background, so callers do not wait on the token endpoint.
+func (ts *TokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	token := ts.token
+	switch {
+	case ts.fresh(token):
+		ts.mu.Unlock()
+		return token.AccessToken, nil
+	case token != nil && ts.now().Before(token.ExpiresAt):
+		if !ts.refreshing {
+			ts.refreshing = true
+			go ts.refreshInBackground(trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx)))
+		}
+		ts.mu.Unlock()
+		return token.AccessToken, nil
+	}
+	ts.mu.Unlock()
+
+	token, err := ts.refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// Invalidate drops accessToken after the API rejected it, here and in the
+// shared cache, unless it was already replaced
+func (ts *TokenSource) Invalidate(ctx context.Context, accessToken string) {
+	ts.mu.Lock()
+	if ts.token != nil && ts.token.AccessToken == accessToken {
+		ts.token = nil
+	}
+	ts.mu.Unlock()
+
+	if ts.cache == nil {
+		return
+	}
+	if cached := ts.cached(ctx); cached != nil && cached.AccessToken == accessToken {
+		ts.cache.Del(ctx, ts.cacheKey())
+	}
+}
+
+func (ts *TokenSource) refreshInBackground(ctx context.Context) {
+	defer func() {
+		ts.mu.Lock()
+		ts.refreshing = false
+		ts.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, ts.cfg.Timeout)
+	defer cancel()
+	// A failure leaves the current token in place until it expires, when
+	// a caller refreshes it in the foreground and sees the error
+	ts.refresh(ctx)
+}
+
+func (ts *TokenSource) refresh(ctx context.Context) (*oauth2Token, error) {
+	ts.fetchMu.Lock()
+	defer ts.fetchMu.Unlock()
+
+	// Another caller may have refreshed it while this one waited
+	ts.mu.Lock()
+	token := ts.token
+	ts.mu.Unlock()
+	if ts.fresh(token) {
+		return token, nil
+	}
+
+	token, err := ts.obtain(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	ts.token = token
+	ts.mu.Unlock()
+	return token, nil
+}
+
+// obtain takes a fresh token from the shared cache or, holding the cache
+// lock, from the token endpoint
+func (ts *TokenSource) obtain(ctx context.Context) (*oauth2Token, error) {
+	if ts.cache == nil {
+		return ts.fetch(ctx)
+	}
+	if token := ts.cached(ctx); ts.fresh(token) {
+		return token, nil
+	}
+
+	unlock, ok, err := ts.cache.TryLock(ctx, ts.lockKey(), ts.cfg.Timeout)
+	switch {
+	case err != nil:
+		// Without the lock the worst case is a redundant fetch
+	case ok:
+		defer unlock(context.WithoutCancel(ctx))
+		if token := ts.cached(ctx); ts.fresh(token) {
+			return token, nil
+		}
+	default:
+		if token := ts.waitForCached(ctx); token != nil {
+			return token, nil
+		}
+	}
+
+	token, err := ts.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(token); err == nil {
+		ts.cache.Set(ctx, ts.cacheKey(), string(data), token.ExpiresAt.Sub(ts.now()))
+	}
+	return token, nil
+}
+
+// waitForCached waits up to the fetch timeout for the replica holding the
+// lock to cache a token
+func (ts *TokenSource) waitForCached(ctx context.Context) *oauth2Token {
+	timer := time.NewTimer(ts.cfg.Timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(tokenWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if token := ts.cached(ctx); ts.fresh(token) {
+				return token
+			}
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (ts *TokenSource) cached(ctx context.Context) *oauth2Token {
+	data, err := ts.cache.Get(ctx, ts.cacheKey())
+	if err != nil {
+		return nil
+	}
+	var token oauth2Token
+	if err := json.Unmarshal([]byte(data), &token); err != nil || token.AccessToken == "" || token.RefreshAt.IsZero() {
+		return nil
+	}
+	return &token
+}
+
+func (ts *TokenSource) fetch(ctx context.Context) (*oauth2Token, error) {
+	ctx, span := ts.tracer.Start(ctx, "oauth2.token",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.PeerService(ts.service),
+			attribute.String("oauth2.grant_type", "client_credentials"),
+			attribute.String("oauth2.client_id", ts.cfg.ClientID),
+		),
+	)
+	defer span.End()
+
+	form := map[string]string{"grant_type": "client_credentials"}
+	if len(ts.cfg.Scopes) > 0 {
+		form["scope"] = strings.Join(ts.cfg.Scopes, " ")
+	}
+	if ts.cfg.Audience != "" {
+		form["audience"] = ts.cfg.Audience
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	var errorResp struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+
+	start := ts.now()
+	resp, err := ts.client.R().
+		SetContext(ctx).
+		SetBasicAuth(ts.cfg.ClientID, ts.cfg.ClientSecret).
+		SetFormData(form).
+		SetResult(&response).
+		SetError(&errorResp).
+		Post(ts.cfg.TokenURL)
+
+	if err == nil && resp.IsError() {
+		err = fmt.Errorf("%d %s - %s", resp.StatusCode(), errorResp.Error, errorResp.Description)
+	}
+	if err == nil && response.AccessToken == "" {
+		err = fmt.Errorf("response has no access_token")
+	}
+	if err == nil && response.TokenType != "" && !strings.EqualFold(response.TokenType, "bearer") {
+		err = fmt.Errorf("unsupported token type %q", response.TokenType)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s OAuth2 token request failed: %w", ts.service, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	lifetime := time.Duration(response.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	span.SetAttributes(attribute.Int64("oauth2.expires_in", int64(lifetime.Seconds())))
+
+	// Measured from the request so the token never outlives its real expiry.
+	// A short-lived token is refreshed halfway through its lifetime rather
+	// than on every use.
+	expiresAt := start.Add(lifetime)
+	return &oauth2Token{
+		AccessToken: response.AccessToken,
+		ExpiresAt:   expiresAt,
+		RefreshAt:   expiresAt.Add(-min(ts.cfg.RefreshBefore, lifetime/2)),
+	}, nil
+}
+
+// fresh reports whether token is valid and outside the refresh window
+func (ts *TokenSource) fresh(token *oauth2Token) bool {
+	return token != nil && ts.now().Before(token.RefreshAt)
+}
+
+func (ts *TokenSource) cacheKey() string {
+	return "oauth2:token:" + ts.service + ":" + ts.cfg.ClientID
+}
+
+func (ts *TokenSource) lockKey() string {
+	return "lock:oauth2:" + ts.service + ":" + ts.cfg.ClientID
+}
+
+// oauth2Transport sends every attempt with a bearer token. A 401 drops the
+// token and the request is sent once more with a new one; this is safe for
+// any method since the API refused the request unprocessed. A signed request
+// is signed again for the replay, so it carries a fresh timestamp and nonce.
+type oauth2Transport struct {
+	base   http.RoundTripper
+	source *TokenSource
+	signer *MTNPaySigner
+}
+
+// useOAuth2 authenticates client with tokens from source. signer (may be
+// nil) must be the one signing the client's requests.
+func useOAuth2(client *resty.Client, source *TokenSource, signer *MTNPaySigner) {
+	client.SetTransport(&oauth2Transport{base: client.GetClient().Transport, source: source, signer: signer})
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	token, err := t.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.send(req, req.Body, token, nil)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// A body that cannot be replayed rules out the retry
+	body := req.Body
+	if body != nil && body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+
+	t.source.Invalidate(ctx, token)
+	fresh, err := t.source.Token(ctx)
+	if err != nil || fresh == token {
+		return resp, nil
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	trace.SpanFromContext(ctx).AddEvent("oauth2.token_rejected")
+	return t.send(req, body, fresh, t.signer)
+}
+
+func (t *oauth2Transport) send(req *http.Request, body io.ReadCloser, token string, signer *MTNPaySigner) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Body = body
+	r.Header.Set("Authorization", "Bearer "+token)
+	if signer != nil {
+		if err := signer.Sign(r); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(r)
+}
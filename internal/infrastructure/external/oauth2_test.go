@@ -0,0 +1,77 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TestTokenSourceShortLifetime hands out tokens that live for less than
+// RefreshBefore; they must be refreshed halfway through their lifetime, not
+// fetched again on every call
+func TestTokenSourceShortLifetime(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":60}`))
+	}))
+	defer srv.Close()
+
+	ts := NewTokenSource("test", &config.OAuth2Config{
+		TokenURL:      srv.URL,
+		ClientID:      "client",
+		ClientSecret:  "secret",
+		RefreshBefore: 5 * time.Minute,
+		Timeout:       5 * time.Second,
+	}, nil)
+	ts.client.SetLogger(discardLogger{})
+
+	var mu sync.Mutex
+	now := time.Now()
+	ts.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+	tokens := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := ts.Token(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Let a background refresh finish
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			ts.mu.Lock()
+			refreshing := ts.refreshing
+			ts.mu.Unlock()
+			if !refreshing {
+				break
+			}
+		}
+	}
+
+	tokens(5)
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("fresh token: %d fetches, want 1", got)
+	}
+
+	// Past half the lifetime: one background refresh, then a fresh token
+	advance(40 * time.Second)
+	tokens(5)
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("in the refresh window: %d fetches, want 2", got)
+	}
+}
@@ -0,0 +1,179 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
+)
+
+// ErrRateLimited is returned without calling the API when the client's rate
+// limiter would hold the call for longer than its max wait
+var ErrRateLimited = errors.New("client rate limit exceeded")
+
+// defaultBackoff pauses the limiter after a 429 without a Retry-After
+const defaultBackoff = time.Second
+
+// RateLimitStore shares token buckets between replicas. *database.Redis
+// implements it.
+type RateLimitStore interface {
+	ReserveToken(ctx context.Context, key string, interval time.Duration, burst int, maxWait time.Duration) (wait time.Duration, ok bool, err error)
+	DelayBucket(ctx context.Context, key string, interval time.Duration, burst int, delay time.Duration) error
+}
+
+// rateLimiter paces the requests of one client with a token bucket, kept as
+// the time at which the bucket will be full again. When a provider asks us
+// to back off, the bucket is emptied until then.
+type rateLimiter struct {
+	service  string
+	interval time.Duration
+	burst    int
+	maxWait  time.Duration
+	// store is nil unless the bucket is shared; while it fails the replica
+	// falls back to its own bucket
+	store   RateLimitStore
+	metrics *observability.BusinessMetrics
+
+	mu     sync.Mutex
+	fullAt time.Time
+}
+
+// newRateLimiter returns nil when cfg disables rate limiting. store may be
+// nil, in which case every replica has its own bucket.
+func newRateLimiter(service string, cfg *config.ClientRateLimitConfig, store RateLimitStore, metrics *observability.BusinessMetrics) *rateLimiter {
+	if cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	l := &rateLimiter{
+		service:  service,
+		interval: time.Duration(float64(time.Second) / cfg.RequestsPerSecond),
+		burst:    cfg.Burst,
+		maxWait:  cfg.MaxWait,
+		metrics:  metrics,
+	}
+	if l.burst <= 0 {
+		l.burst = 1
+	}
+	if cfg.Shared {
+		l.store = store
+	}
+	return l
+}
+
+func (l *rateLimiter) key() string {
+	return "ratelimit:external:" + l.service
+}
+
+// wait blocks until the call may be sent. It fails with ErrRateLimited,
+// taking no token, when that would be later than the max wait or the
+// context's deadline allow.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	maxWait := l.maxWait
+	if maxWait <= 0 {
+		maxWait = -1
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); maxWait < 0 || remaining < maxWait {
+			maxWait = max(remaining, 0)
+		}
+	}
+
+	delay, ok := l.reserve(ctx, maxWait)
+	attrs := metric.WithAttributes(attribute.String("service", l.service))
+	if !ok {
+		if l.metrics != nil {
+			l.metrics.RateLimiterRejections.Add(ctx, 1, attrs)
+		}
+		return fmt.Errorf("%w: next token in %s", ErrRateLimited, delay)
+	}
+	if l.metrics != nil {
+		l.metrics.RateLimiterWait.Record(ctx, delay.Seconds(), attrs)
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("rate_limiter.wait",
+		trace.WithAttributes(attribute.String("rate_limiter.delay", delay.String())))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token and returns the wait before it may be used; ok is
+// false when the wait would exceed maxWait, negative meaning no bound
+func (l *rateLimiter) reserve(ctx context.Context, maxWait time.Duration) (time.Duration, bool) {
+	if l.store != nil {
+		delay, ok, err := l.store.ReserveToken(ctx, l.key(), l.interval, l.burst, maxWait)
+		if err == nil {
+			return delay, ok
+		}
+		trace.SpanFromContext(ctx).RecordError(fmt.Errorf("shared rate limiter unavailable, using the local one: %w", err))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	fullAt := l.fullAt
+	if fullAt.Before(now) {
+		fullAt = now
+	}
+	delay := max(fullAt.Add(l.interval-time.Duration(l.burst)*l.interval).Sub(now), 0)
+	if maxWait >= 0 && delay > maxWait {
+		return delay, false
+	}
+	l.fullAt = fullAt.Add(l.interval)
+	return delay, true
+}
+
+// backOff holds back every token for d, after which the bucket refills from
+// empty rather than letting a burst through at once
+func (l *rateLimiter) backOff(ctx context.Context, d time.Duration) {
+	trace.SpanFromContext(ctx).AddEvent("rate_limiter.back_off",
+		trace.WithAttributes(attribute.String("rate_limiter.delay", d.String())))
+
+	l.mu.Lock()
+	if fullAt := time.Now().Add(d + time.Duration(l.burst-1)*l.interval); fullAt.After(l.fullAt) {
+		l.fullAt = fullAt
+	}
+	l.mu.Unlock()
+
+	if l.store != nil {
+		// The response is already read; a slow store should not hold it up
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+		defer cancel()
+		if err := l.store.DelayBucket(ctx, l.key(), l.interval, l.burst, d); err != nil {
+			trace.SpanFromContext(ctx).RecordError(fmt.Errorf("failed to pause the shared rate limiter: %w", err))
+		}
+	}
+}
+
+// backOffFor returns how long the provider asked us to stop calling it: the
+// Retry-After of a 429 or 503, or a second on a 429 without one
+func backOffFor(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	if d := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); d > 0 {
+		return d, true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return defaultBackoff, true
+	}
+	return 0, false
+}
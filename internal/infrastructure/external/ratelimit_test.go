@@ -0,0 +1,143 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TestBucketPacing takes a burst of 2 at 20 per second: two tokens at
+// once, then one every 50ms
+func TestBucketPacing(t *testing.T) {
+	l := newRateLimiter("check", &config.ClientRateLimitConfig{RequestsPerSecond: 20, Burst: 2}, nil, nil)
+	for i, want := range []time.Duration{0, 0, 50 * time.Millisecond, 100 * time.Millisecond} {
+		wait, ok := l.reserve(context.Background(), -1)
+		if !ok || wait > want || wait < want-10*time.Millisecond {
+			t.Errorf("pacing: token %d after %s (ok %t), want %s", i+1, wait, ok, want)
+		}
+	}
+}
+
+// TestBucketMaxWait expects a call that would wait past the max wait to
+// fail fast without taking a token
+func TestBucketMaxWait(t *testing.T) {
+	l := newRateLimiter("check", &config.ClientRateLimitConfig{RequestsPerSecond: 5, Burst: 1, MaxWait: 100 * time.Millisecond}, nil, nil)
+	ctx := context.Background()
+
+	if err := l.wait(ctx); err != nil {
+		t.Errorf("max wait: first call: %v", err)
+	}
+	start := time.Now()
+	if err := l.wait(ctx); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("max wait: second call returned %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("max wait: rejection took %s", elapsed)
+	}
+	if wait, ok := l.reserve(ctx, -1); !ok || wait > 200*time.Millisecond {
+		t.Errorf("max wait: rejected call took a token, next one in %s", wait)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) ReserveToken(context.Context, string, time.Duration, int, time.Duration) (time.Duration, bool, error) {
+	return 0, false, errors.New("store down")
+}
+
+func (failingStore) DelayBucket(context.Context, string, time.Duration, int, time.Duration) error {
+	return errors.New("store down")
+}
+
+// TestStoreFallback expects a shared limiter to keep limiting with its own
+// bucket while the store fails
+func TestStoreFallback(t *testing.T) {
+	l := newRateLimiter("check", &config.ClientRateLimitConfig{RequestsPerSecond: 10, Burst: 1, Shared: true}, failingStore{}, nil)
+	if l.store == nil {
+		t.Errorf("store fallback: shared limiter ignores its store")
+	}
+	if wait, ok := l.reserve(context.Background(), -1); !ok || wait != 0 {
+		t.Errorf("store fallback: first token after %s (ok %t)", wait, ok)
+	}
+	if wait, ok := l.reserve(context.Background(), -1); !ok || wait < 90*time.Millisecond {
+		t.Errorf("store fallback: local bucket not limiting, second token after %s", wait)
+	}
+}
+
+// TestRetryAfter has a stand-in answer 429 to the first GET. With a
+// Retry-After of 1s the retry waits for it; with one longer than the retry
+// max wait the call fails with the APIError and the next call is held back.
+func TestRetryAfter(t *testing.T) {
+
+	var mu sync.Mutex
+	var arrivals []time.Time
+	retryAfter := "1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		first := len(arrivals) == 1
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if first {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":"too_many_requests","message":"slow down"}`))
+			return
+		}
+		w.Write([]byte(`{"user_id":"u-1"}`))
+	}))
+	defer srv.Close()
+
+	newClient := func() *MADAPIClient {
+		client := NewMADAPIClient(&config.MADAPIConfig{
+			BaseURL: srv.URL,
+			APIKey:  "check",
+			Resilience: config.ResilienceConfig{
+				Timeout:          5 * time.Second,
+				MaxRetries:       1,
+				RetryWait:        time.Millisecond,
+				RetryMaxWait:     2 * time.Second,
+				FailureThreshold: 5,
+				OpenTimeout:      time.Second,
+				HalfOpenProbes:   1,
+				RateLimit:        config.ClientRateLimitConfig{RequestsPerSecond: 100, Burst: 10, MaxWait: 200 * time.Millisecond},
+			},
+		}, nil, nil, nil, nil)
+		client.client.SetLogger(discardLogger{})
+		return client
+	}
+	ctx := context.Background()
+
+	if _, err := newClient().GetUserProfile(ctx, "u-1"); err != nil {
+		t.Errorf("retry-after: %v", err)
+	}
+	mu.Lock()
+	if len(arrivals) != 2 {
+		t.Errorf("retry-after: stand-in saw %d attempts, want 2", len(arrivals))
+	} else if gap := arrivals[1].Sub(arrivals[0]); gap < 900*time.Millisecond {
+		t.Errorf("retry-after: retried after %s, want the 1s Retry-After", gap)
+	}
+	arrivals, retryAfter = nil, "5"
+	mu.Unlock()
+
+	client := newClient()
+	_, err := client.GetUserProfile(ctx, "u-1")
+	if apiErr, ok := AsAPIError(err); !ok || apiErr.RetryAfter != 5*time.Second {
+		t.Errorf("long retry-after: got %v, want a 429 APIError with its Retry-After", err)
+	}
+	if _, err := client.GetUserProfile(ctx, "u-1"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("long retry-after: call during the back-off returned %v, want ErrRateLimited", err)
+	}
+	mu.Lock()
+	if len(arrivals) != 1 {
+		t.Errorf("long retry-after: stand-in saw %d attempts, want 1", len(arrivals))
+	}
+	mu.Unlock()
+}
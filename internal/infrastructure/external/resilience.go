@@ -0,0 +1,370 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
+)
+
+var (
+	// ErrCircuitOpen is returned without calling the API while its circuit
+	// breaker is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrBulkheadFull is returned when the client stays at its concurrency
+	// limit for longer than the queue timeout
+	ErrBulkheadFull = errors.New("too many concurrent requests")
+)
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half_open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops calls to a failing API. It opens after a run of
+// consecutive failures, lets a few probes through once the open timeout has
+// passed, and closes when they all succeed; a failed probe opens it again.
+type CircuitBreaker struct {
+	service          string
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenProbes   int
+	onChange         func(from, to BreakerState)
+
+	mu         sync.Mutex
+	state      BreakerState
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	probes     int
+	successes  int
+}
+
+func newCircuitBreaker(service string, cfg *config.ResilienceConfig, onChange func(from, to BreakerState)) *CircuitBreaker {
+	b := &CircuitBreaker{
+		service:          service,
+		failureThreshold: cfg.FailureThreshold,
+		openTimeout:      cfg.OpenTimeout,
+		halfOpenProbes:   cfg.HalfOpenProbes,
+		onChange:         onChange,
+	}
+	if b.failureThreshold <= 0 {
+		b.failureThreshold = 5
+	}
+	if b.openTimeout <= 0 {
+		b.openTimeout = 30 * time.Second
+	}
+	if b.halfOpenProbes <= 0 {
+		b.halfOpenProbes = 1
+	}
+	return b
+}
+
+// Service is the name of the API the breaker protects
+func (b *CircuitBreaker) Service() string {
+	return b.service
+}
+
+// State returns the current state, moving an open breaker whose timeout has
+// passed to half-open
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireOpen()
+	return b.state
+}
+
+// allow reserves a call. Either done must be called with the outcome, or
+// release when the call never reached the API; outcomes of calls started
+// before the last state change are ignored.
+func (b *CircuitBreaker) allow() (done func(success bool), release func(), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expireOpen()
+	switch b.state {
+	case BreakerOpen:
+		return nil, nil, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.halfOpenProbes {
+			return nil, nil, ErrCircuitOpen
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	return func(success bool) { b.record(generation, success) },
+		func() { b.release(generation) }, nil
+}
+
+// release gives back the probe slot of a call that never reached the API,
+// recording nothing about it
+func (b *CircuitBreaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *CircuitBreaker) record(generation uint64, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if !success {
+			b.transition(BreakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenProbes {
+			b.transition(BreakerClosed)
+		}
+	}
+}
+
+func (b *CircuitBreaker) expireOpen() {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.openTimeout {
+		b.transition(BreakerHalfOpen)
+	}
+}
+
+// transition must be called with b.mu held
+func (b *CircuitBreaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if to == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	if b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
+
+// bulkhead bounds the requests in flight to one API
+type bulkhead struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newBulkhead(size int, timeout time.Duration) *bulkhead {
+	if size <= 0 {
+		return nil
+	}
+	return &bulkhead{slots: make(chan struct{}, size), timeout: timeout}
+}
+
+func (b *bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBulkheadFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+}
+
+// resilientTransport passes every attempt through the circuit breaker, the
+// rate limiter and the bulkhead. Transport errors and 5xx responses count as
+// breaker failures; other responses, 4xx included, show the API is up. A 429,
+// or a 503 with Retry-After, pauses the rate limiter for every caller.
+type resilientTransport struct {
+	base     http.RoundTripper
+	service  string
+	breaker  *CircuitBreaker
+	limiter  *rateLimiter
+	bulkhead *bulkhead
+	metrics  *observability.BusinessMetrics
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, release, err := t.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	// Calls held back by the limiter or the bulkhead never reach the API;
+	// leave the breaker as it was
+	if t.limiter != nil {
+		if err := t.limiter.wait(req.Context()); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	if t.bulkhead != nil {
+		if err := t.bulkhead.acquire(req.Context()); err != nil {
+			release()
+			if errors.Is(err, ErrBulkheadFull) && t.metrics != nil {
+				t.metrics.BulkheadRejections.Add(req.Context(), 1,
+					metric.WithAttributes(attribute.String("service", t.service)))
+			}
+			return nil, err
+		}
+		defer t.bulkhead.release()
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// A caller giving up says nothing about the API
+		release()
+	case err != nil:
+		done(false)
+	default:
+		done(resp.StatusCode < http.StatusInternalServerError)
+		if d, ok := backOffFor(resp); ok && t.limiter != nil {
+			t.limiter.backOff(req.Context(), d)
+		}
+	}
+	return resp, err
+}
+
+// applyResilience sets the timeout, retry policy, rate limiter, bulkhead and
+// circuit breaker of cfg on client. limits shares the rate limiter's bucket
+// between replicas and may be nil.
+func applyResilience(client *resty.Client, service string, cfg *config.ResilienceConfig, metrics *observability.BusinessMetrics, limits RateLimitStore) *CircuitBreaker {
+	breaker := newCircuitBreaker(service, cfg, func(from, to BreakerState) {
+		if metrics != nil {
+			metrics.BreakerTransitions.Add(context.Background(), 1, metric.WithAttributes(
+				attribute.String("service", service),
+				attribute.String("from", from.String()),
+				attribute.String("to", to.String()),
+			))
+		}
+	})
+
+	base := client.GetClient().Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	client.
+		SetTimeout(cfg.Timeout).
+		SetTransport(&resilientTransport{
+			base:     base,
+			service:  service,
+			breaker:  breaker,
+			limiter:  newRateLimiter(service, &cfg.RateLimit, limits, metrics),
+			bulkhead: newBulkhead(cfg.MaxConcurrent, cfg.QueueTimeout),
+			metrics:  metrics,
+		}).
+		SetRetryCount(cfg.MaxRetries).
+		SetRetryWaitTime(cfg.RetryWait).
+		SetRetryMaxWaitTime(cfg.RetryMaxWait).
+		SetRetryAfter(retryAfter).
+		AddRetryCondition(retryable(cfg.RetryMaxWait))
+
+	return breaker
+}
+
+// retryable retries idempotent GETs on transport errors, 429 and 5xx. Calls
+// rejected by the breaker, rate limiter or bulkhead, cancelled calls, and
+// responses asking for a longer wait than maxWait are not retried.
+func retryable(maxWait time.Duration) resty.RetryConditionFunc {
+	return func(resp *resty.Response, err error) bool {
+		if resp == nil || resp.Request == nil || resp.Request.Method != http.MethodGet {
+			return false
+		}
+		if resp.Request.Context().Err() != nil {
+			return false
+		}
+		if err != nil {
+			return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrBulkheadFull) && !errors.Is(err, ErrRateLimited)
+		}
+		code := resp.StatusCode()
+		if code != http.StatusTooManyRequests && code < http.StatusInternalServerError {
+			return false
+		}
+		if wait, _ := retryAfter(nil, resp); maxWait > 0 && wait > maxWait {
+			return false
+		}
+		return true
+	}
+}
+
+// retryAfter waits as long as a 429 or 503 asks, and leaves the backoff to
+// resty otherwise
+func retryAfter(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+	if resp.RawResponse == nil {
+		return 0, nil
+	}
+	wait, _ := backOffFor(resp.RawResponse)
+	return wait, nil
+}
+
+// ObserveBreakers reports the state of breakers as the
+// external_circuit_breaker_state gauge: 0 closed, 1 half-open, 2 open
+func ObserveBreakers(meter metric.Meter, breakers ...*CircuitBreaker) error {
+	_, err := meter.Int64ObservableGauge(
+		"external_circuit_breaker_state",
+		metric.WithDescription("Circuit breaker state of external clients: 0 closed, 1 half-open, 2 open"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for _, b := range breakers {
+				o.Observe(int64(b.State()), metric.WithAttributes(attribute.String("service", b.service)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register circuit breaker gauge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TestBreakerTransitions opens a breaker with a threshold of 2, closes it
+// through two half-open probes, then opens it again with a failed probe
+func TestBreakerTransitions(t *testing.T) {
+	var transitions []string
+	b := newCircuitBreaker("check", &config.ResilienceConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      50 * time.Millisecond,
+		HalfOpenProbes:   2,
+	}, func(from, to BreakerState) {
+		transitions = append(transitions, from.String()+">"+to.String())
+	})
+
+	call := func(name string) func(bool) {
+		done, _, err := b.allow()
+		if err != nil {
+			t.Errorf("breaker: %s: %v", name, err)
+			return func(bool) {}
+		}
+		return done
+	}
+	expect := func(name string, want BreakerState) {
+		if got := b.State(); got != want {
+			t.Errorf("breaker: %s: state %s, want %s", name, got, want)
+		}
+	}
+	rejected := func(name string) {
+		if _, _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("breaker: %s: allow returned %v, want ErrCircuitOpen", name, err)
+		}
+	}
+
+	// A success in between resets the run of failures
+	call("first failure")(false)
+	call("success")(true)
+	call("failure after a success")(false)
+	expect("interrupted failures", BreakerClosed)
+
+	stale := call("call outliving the closed state")
+	call("second failure")(false)
+	expect("two failures", BreakerOpen)
+	rejected("open")
+	stale(true)
+	expect("late success from the closed state", BreakerOpen)
+
+	time.Sleep(60 * time.Millisecond)
+	expect("after the open timeout", BreakerHalfOpen)
+	probe1 := call("first probe")
+	probe2 := call("second probe")
+	rejected("third probe")
+	stale(true)
+	probe1(true)
+	expect("one of two probes succeeded", BreakerHalfOpen)
+	probe2(true)
+	expect("both probes succeeded", BreakerClosed)
+
+	call("failure")(false)
+	call("failure")(false)
+	time.Sleep(60 * time.Millisecond)
+	probe := call("probe")
+	call("second probe")
+	probe(false)
+	expect("failed probe", BreakerOpen)
+	rejected("reopened")
+
+	want := "closed>open open>half_open half_open>closed closed>open open>half_open half_open>open"
+	if got := strings.Join(transitions, " "); got != want {
+		t.Errorf("breaker: transitions %q, want %q", got, want)
+	}
+}
+
+// TestBreakerRelease sends a half-open probe through a transport whose rate
+// limiter rejects it, and expects the breaker to stay half-open with the
+// probe slot free; in the closed state a rejection must not reset the run
+// of failures
+func TestBreakerRelease(t *testing.T) {
+	b := newCircuitBreaker("check", &config.ResilienceConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      50 * time.Millisecond,
+		HalfOpenProbes:   1,
+	}, nil)
+	limiter := newRateLimiter("check", &config.ClientRateLimitConfig{RequestsPerSecond: 1, Burst: 1, MaxWait: time.Millisecond}, nil, nil)
+	transport := &resilientTransport{
+		base: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("API not reachable in this check")
+		}),
+		service: "check",
+		breaker: b,
+		limiter: limiter,
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://check.invalid/", nil)
+
+	limited := func(name string) {
+		limiter.reserve(context.Background(), -1)
+		if _, err := transport.RoundTrip(req); !errors.Is(err, ErrRateLimited) {
+			t.Errorf("release: %s returned %v, want ErrRateLimited", name, err)
+		}
+	}
+
+	done, _, _ := b.allow()
+	done(false)
+	limited("rejection after a failure")
+	done, _, _ = b.allow()
+	done(false)
+	if got := b.State(); got != BreakerOpen {
+		t.Errorf("release: two failures around a rejection left the breaker %s, want open", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	limited("half-open probe")
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Errorf("release: rejected probe left the breaker %s, want half_open", got)
+	}
+	done, _, err := b.allow()
+	if err != nil {
+		t.Fatalf("release: probe slot not given back: %v", err)
+	}
+	done(false)
+	if got := b.State(); got != BreakerOpen {
+		t.Errorf("release: failed probe after a rejection left the breaker %s, want open", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// TestBulkhead fills a bulkhead of one and expects the next call to wait
+// out the queue timeout, or its context, and the slot to be usable again
+// once released
+func TestBulkhead(t *testing.T) {
+	if newBulkhead(0, time.Second) != nil {
+		t.Errorf("bulkhead: size 0 does not disable it")
+	}
+
+	b := newBulkhead(1, 50*time.Millisecond)
+	ctx := context.Background()
+	if err := b.acquire(ctx); err != nil {
+		t.Fatalf("bulkhead: first call: %v", err)
+	}
+
+	start := time.Now()
+	if err := b.acquire(ctx); !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("bulkhead: call over the limit returned %v, want ErrBulkheadFull", err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("bulkhead: rejected after %s, want the 50ms queue timeout", waited)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := b.acquire(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("bulkhead: cancelled call returned %v, want its context error", err)
+	}
+
+	released := make(chan error)
+	go func() { released <- b.acquire(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	b.release()
+	if err := <-released; err != nil {
+		t.Errorf("bulkhead: queued call after a release: %v", err)
+	}
+}
+
+// TestRetryCondition runs the retry condition over GETs and a POST with
+// the responses and errors a call can end with
+func TestRetryCondition(t *testing.T) {
+	retry := retryable(2 * time.Second)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name       string
+		method     string
+		ctx        context.Context
+		status     int
+		retryAfter string
+		err        error
+		want       bool
+	}{
+		{"GET 500", http.MethodGet, nil, http.StatusInternalServerError, "", nil, true},
+		{"GET 503", http.MethodGet, nil, http.StatusServiceUnavailable, "", nil, true},
+		{"GET 429", http.MethodGet, nil, http.StatusTooManyRequests, "", nil, true},
+		{"GET 429 with a short Retry-After", http.MethodGet, nil, http.StatusTooManyRequests, "1", nil, true},
+		{"GET 429 with a long Retry-After", http.MethodGet, nil, http.StatusTooManyRequests, "5", nil, false},
+		{"GET 404", http.MethodGet, nil, http.StatusNotFound, "", nil, false},
+		{"GET 200", http.MethodGet, nil, http.StatusOK, "", nil, false},
+		{"POST 503", http.MethodPost, nil, http.StatusServiceUnavailable, "", nil, false},
+		{"GET transport error", http.MethodGet, nil, 0, "", errors.New("connection reset"), true},
+		{"GET open breaker", http.MethodGet, nil, 0, "", fmt.Errorf("wrapped: %w", ErrCircuitOpen), false},
+		{"GET full bulkhead", http.MethodGet, nil, 0, "", ErrBulkheadFull, false},
+		{"GET rate limited", http.MethodGet, nil, 0, "", ErrRateLimited, false},
+		{"GET cancelled", http.MethodGet, cancelled, http.StatusServiceUnavailable, "", nil, false},
+	}
+	for _, tc := range cases {
+		req := resty.New().R()
+		req.Method = tc.method
+		if tc.ctx != nil {
+			req.SetContext(tc.ctx)
+		}
+		resp := &resty.Response{Request: req}
+		if tc.status != 0 {
+			resp.RawResponse = &http.Response{StatusCode: tc.status, Header: http.Header{}}
+			if tc.retryAfter != "" {
+				resp.RawResponse.Header.Set("Retry-After", tc.retryAfter)
+			}
+		}
+		if got := retry(resp, tc.err); got != tc.want {
+			t.Errorf("retry condition: %s retried %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
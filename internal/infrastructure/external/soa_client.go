@@ -2,6 +2,7 @@ package external
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,28 +12,47 @@ import (
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
 )
 
 type SOAClient struct {
-	client *resty.Client
-	config *config.SOAConfig
-	tracer trace.Tracer
+	client  *resty.Client
+	config  *config.SOAConfig
+	tracer  trace.Tracer
+	breaker *CircuitBreaker
 }
 
-func NewSOAClient(cfg *config.SOAConfig) *SOAClient {
+// NewSOAClient authenticates with OAuth2 client credentials when
+// cfg.OAuth2.TokenURL is set, sharing tokens through tokens (may be nil),
+// and with the API key otherwise. limits (may be nil) shares its rate limit
+// between replicas.
+func NewSOAClient(cfg *config.SOAConfig, metrics *observability.BusinessMetrics, tokens TokenCache, limits RateLimitStore) *SOAClient {
 	client := resty.New().
 		SetBaseURL(cfg.BaseURL).
-		SetHeader("Content-Type", "application/json").
-		SetHeader("X-API-Key", cfg.APIKey).
-		SetTimeout(25 * time.Second)
+		SetHeader("Content-Type", "application/json")
+
+	tracer := otel.Tracer("soa-client")
+	instrument(client, "soa", tracer, metrics)
+	breaker := applyResilience(client, "soa", &cfg.Resilience, metrics, limits)
+	if cfg.OAuth2.TokenURL != "" {
+		useOAuth2(client, NewTokenSource("soa", &cfg.OAuth2, tokens), nil)
+	} else {
+		client.SetHeader("X-API-Key", cfg.APIKey)
+	}
 
 	return &SOAClient{
-		client: client,
-		config: cfg,
-		tracer: otel.Tracer("soa-client"),
+		client:  client,
+		config:  cfg,
+		tracer:  tracer,
+		breaker: breaker,
 	}
 }
 
+// Breaker returns the circuit breaker guarding SOA calls
+func (c *SOAClient) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
 type InventoryRequest struct {
 	ProductID string `json:"product_id"`
 	Quantity  int    `json:"quantity"`
@@ -140,8 +160,7 @@ func (c *SOAClient) CheckInventory(ctx context.Context, req InventoryRequest) (*
 		Code    string `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "check_inventory").
 		SetBody(req).
 		SetResult(&response).
 		SetError(&errorResp).
@@ -152,14 +171,8 @@ func (c *SOAClient) CheckInventory(ctx context.Context, req InventoryRequest) (*
 		return nil, fmt.Errorf("SOA inventory check request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "POST"),
-		attribute.String("http.url", "/inventory/check"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("SOA inventory check failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("soa", resp, "SOA inventory check failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -173,6 +186,104 @@ func (c *SOAClient) CheckInventory(ctx context.Context, req InventoryRequest) (*
 	return &response, nil
 }
 
+type inventoryBatchRequest struct {
+	Items []InventoryRequest `json:"items"`
+}
+
+type inventoryBatchResponse struct {
+	Items []InventoryResponse `json:"items"`
+}
+
+// CheckInventoryBatch checks many products, returning their stock in the
+// order of reqs; repeated requests are checked once. With
+// InventoryBatchSize set the products go to SOA's batch endpoint in chunks
+// of that size, otherwise one per call, with at most InventoryConcurrency
+// requests in flight. When some fail, the stock that was checked is
+// returned with the failures joined.
+func (c *SOAClient) CheckInventoryBatch(ctx context.Context, reqs []InventoryRequest) ([]*InventoryResponse, error) {
+	ctx, span := c.tracer.Start(ctx, "soa.check_inventory_batch",
+		trace.WithAttributes(
+			attribute.Int("batch.size", len(reqs)),
+		),
+	)
+	defer span.End()
+
+	unique, index := dedupe(reqs)
+	stock := make([]*InventoryResponse, len(unique))
+	span.SetAttributes(attribute.Int("batch.unique", len(unique)))
+
+	var errs []error
+	if size := c.config.InventoryBatchSize; size > 0 {
+		chunks := (len(unique) + size - 1) / size
+		errs = fanOut(ctx, chunks, c.config.InventoryConcurrency, func(ctx context.Context, n int) error {
+			start, end := n*size, min((n+1)*size, len(unique))
+			resp, err := c.checkInventoryBatch(ctx, unique[start:end])
+			if err != nil {
+				return err
+			}
+			for i := range resp.Items {
+				stock[start+i] = &resp.Items[i]
+			}
+			return nil
+		})
+	} else {
+		errs = fanOut(ctx, len(unique), c.config.InventoryConcurrency, func(ctx context.Context, i int) error {
+			resp, err := c.CheckInventory(ctx, unique[i])
+			if err != nil {
+				return fmt.Errorf("inventory %s: %w", unique[i].ProductID, err)
+			}
+			stock[i] = resp
+			return nil
+		})
+	}
+
+	results := make([]*InventoryResponse, len(reqs))
+	for i, j := range index {
+		results[i] = stock[j]
+	}
+	if err := errors.Join(errs...); err != nil {
+		span.RecordError(err)
+		return results, err
+	}
+	return results, nil
+}
+
+// checkInventoryBatch makes one call to the batch endpoint, which answers
+// in the order of the request
+func (c *SOAClient) checkInventoryBatch(ctx context.Context, reqs []InventoryRequest) (*inventoryBatchResponse, error) {
+	var response inventoryBatchResponse
+	var errorResp struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+		Code    string `json:"code"`
+	}
+
+	resp, err := newRequest(ctx, c.client, "check_inventory_batch").
+		SetBody(inventoryBatchRequest{Items: reqs}).
+		SetResult(&response).
+		SetError(&errorResp).
+		Post("/inventory/check/batch")
+
+	if err != nil {
+		return nil, fmt.Errorf("SOA batch inventory check request failed: %w", err)
+	}
+
+	if resp.IsError() {
+		return nil, newAPIError("soa", resp, "SOA batch inventory check failed", errorResp.Error, errorResp.Message)
+	}
+
+	if len(response.Items) != len(reqs) {
+		return nil, fmt.Errorf("SOA batch inventory check answered %d items for %d products", len(response.Items), len(reqs))
+	}
+	for i := range reqs {
+		if response.Items[i].ProductID != reqs[i].ProductID {
+			return nil, fmt.Errorf("SOA batch inventory check answered %s in place of %s", response.Items[i].ProductID, reqs[i].ProductID)
+		}
+	}
+
+	return &response, nil
+}
+
 func (c *SOAClient) CreateShipping(ctx context.Context, req ShippingRequest) (*ShippingResponse, error) {
 	ctx, span := c.tracer.Start(ctx, "soa.create_shipping",
 		trace.WithAttributes(
@@ -190,8 +301,7 @@ func (c *SOAClient) CreateShipping(ctx context.Context, req ShippingRequest) (*S
 		Code    string `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "create_shipping").
 		SetBody(req).
 		SetResult(&response).
 		SetError(&errorResp).
@@ -202,14 +312,8 @@ func (c *SOAClient) CreateShipping(ctx context.Context, req ShippingRequest) (*S
 		return nil, fmt.Errorf("SOA shipping request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "POST"),
-		attribute.String("http.url", "/shipping"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("SOA shipping failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("soa", resp, "SOA shipping failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -242,8 +346,7 @@ func (c *SOAClient) GetProductCatalog(ctx context.Context, req ProductCatalogReq
 		Code    string `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "get_product_catalog").
 		SetBody(req).
 		SetResult(&response).
 		SetError(&errorResp).
@@ -254,14 +357,8 @@ func (c *SOAClient) GetProductCatalog(ctx context.Context, req ProductCatalogReq
 		return nil, fmt.Errorf("SOA catalog request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "POST"),
-		attribute.String("http.url", "/catalog/products"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("SOA catalog failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("soa", resp, "SOA catalog failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -290,24 +387,19 @@ func (c *SOAClient) GetShippingStatus(ctx context.Context, shippingID string) (*
 		Code    string `json:"code"`
 	}
 
-	resp, err := c.client.R().
-		SetContext(ctx).
+	resp, err := newRequest(ctx, c.client, "get_shipping_status").
 		SetResult(&response).
 		SetError(&errorResp).
-		Get(fmt.Sprintf("/shipping/%s/status", shippingID))
+		SetPathParam("shippingID", shippingID).
+		Get("/shipping/{shippingID}/status")
 
 	if err != nil {
 		span.RecordError(err)
 		return nil, fmt.Errorf("SOA shipping status request failed: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("http.status_code", resp.StatusCode()),
-		attribute.String("http.method", "GET"),
-	)
-
 	if resp.IsError() {
-		err := fmt.Errorf("SOA shipping status failed: %s - %s", errorResp.Error, errorResp.Message)
+		err := newAPIError("soa", resp, "SOA shipping status failed", errorResp.Error, errorResp.Message)
 		span.RecordError(err)
 		return nil, err
 	}
@@ -0,0 +1,106 @@
+package messaging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// SASL mechanisms
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// connection holds the broker connection settings shared by every reader
+// and writer: the Dialer is used by readers, the Transport by writers
+type connection struct {
+	dialer    *kafka.Dialer
+	transport *kafka.Transport
+}
+
+func newConnection(cfg *config.KafkaConfig) (*connection, error) {
+	tlsConfig, err := newTLSConfig(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure Kafka TLS: %w", err)
+	}
+
+	mechanism, err := newSASLMechanism(&cfg.SASL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure Kafka SASL: %w", err)
+	}
+
+	return &connection{
+		dialer: &kafka.Dialer{
+			ClientID:      cfg.ClientID,
+			Timeout:       cfg.DialTimeout,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+		transport: &kafka.Transport{
+			ClientID:    cfg.ClientID,
+			DialTimeout: cfg.DialTimeout,
+			TLS:         tlsConfig,
+			SASL:        mechanism,
+		},
+	}, nil
+}
+
+func newTLSConfig(cfg *config.KafkaTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func newSASLMechanism(cfg *config.KafkaSASLConfig) (sasl.Mechanism, error) {
+	switch strings.ToUpper(cfg.Mechanism) {
+	case "":
+		return nil, nil
+	case SASLPlain:
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism: %s", cfg.Mechanism)
+	}
+}
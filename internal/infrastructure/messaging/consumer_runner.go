@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const consumerRestartDelay = 5 * time.Second
+
+// ConsumerErrorHandler is called when a background consumer stops with an
+// error. The consumer is restarted after a short delay.
+type ConsumerErrorHandler func(topic, groupID string, err error)
+
+// ConsumerRunner runs consumers in the background and drains them on shutdown
+type ConsumerRunner struct {
+	km      *KafkaManager
+	ctx     context.Context
+	cancel  context.CancelFunc
+	onError ConsumerErrorHandler
+	// onFailure is called for every failed attempt to handle a message
+	onFailure MessageFailureHandler
+
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	consumers []*Consumer
+}
+
+func (km *KafkaManager) NewConsumerRunner(onError ConsumerErrorHandler, onFailure MessageFailureHandler) *ConsumerRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ConsumerRunner{
+		km:        km,
+		ctx:       ctx,
+		cancel:    cancel,
+		onError:   onError,
+		onFailure: onFailure,
+	}
+}
+
+// Start consumes topic under groupID on the worker pool until Shutdown
+func (r *ConsumerRunner) Start(topic, groupID string, handler MessageHandler) {
+	consumer := r.km.NewConsumer(topic, groupID, r.onFailure)
+
+	r.mu.Lock()
+	r.consumers = append(r.consumers, consumer)
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		for {
+			err := consumer.StartConsumingConcurrently(r.ctx, handler)
+			if r.ctx.Err() != nil {
+				return
+			}
+
+			if r.onError != nil {
+				r.onError(topic, groupID, err)
+			}
+
+			select {
+			case <-time.After(consumerRestartDelay):
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops fetching, waits for in-flight messages to be handled (or
+// for ctx to expire) and closes the consumers
+func (r *ConsumerRunner) Shutdown(ctx context.Context) error {
+	r.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	var errs []error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("consumers did not drain: %w", ctx.Err()))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, consumer := range r.consumers {
+		if err := consumer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("consumer shutdown errors: %v", errs)
+	}
+
+	return nil
+}
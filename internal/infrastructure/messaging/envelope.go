@@ -0,0 +1,265 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Event types carried in Envelope.Type
+const (
+	EventTypeUserCreated      = "user.created"
+	EventTypePaymentProcessed = "payment.processed"
+	EventTypeOrderCreated     = "order.created"
+	EventTypeRewardProcessed  = "reward.processed"
+)
+
+var (
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrMissingEventType = errors.New("message is not an event envelope")
+	// ErrMalformedEvent is returned for an envelope or payload that does not
+	// decode
+	ErrMalformedEvent = errors.New("malformed event")
+)
+
+// Event is implemented by every payload that can be published in an Envelope
+type Event interface {
+	EventType() string
+	SchemaVersion() int
+}
+
+func (UserCreatedEvent) EventType() string      { return EventTypeUserCreated }
+func (PaymentProcessedEvent) EventType() string { return EventTypePaymentProcessed }
+func (OrderCreatedEvent) EventType() string     { return EventTypeOrderCreated }
+func (RewardProcessedEvent) EventType() string  { return EventTypeRewardProcessed }
+
+func (UserCreatedEvent) SchemaVersion() int      { return 1 }
+func (PaymentProcessedEvent) SchemaVersion() int { return 1 }
+func (OrderCreatedEvent) SchemaVersion() int     { return 1 }
+func (RewardProcessedEvent) SchemaVersion() int  { return 1 }
+
+// Envelope is the common wrapper for every event published to Kafka
+type Envelope struct {
+	EventID       string            `json:"event_id"`
+	Type          string            `json:"type"`
+	SchemaVersion int               `json:"schema_version"`
+	OccurredAt    time.Time         `json:"occurred_at"`
+	Producer      string            `json:"producer"`
+	TraceContext  map[string]string `json:"trace_context,omitempty"`
+	Payload       json.RawMessage   `json:"payload"`
+}
+
+// NewEnvelope returns the envelope metadata for event. The payload is filled
+// in by the Serializer.
+func NewEnvelope(producer string, event Event) *Envelope {
+	return &Envelope{
+		EventID:       uuid.NewString(),
+		Type:          event.EventType(),
+		SchemaVersion: event.SchemaVersion(),
+		OccurredAt:    time.Now().UTC(),
+		Producer:      producer,
+	}
+}
+
+// injectTraceContext stores the trace context of ctx in the envelope so it
+// survives tools that drop Kafka headers
+func (e *Envelope) injectTraceContext(ctx context.Context) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		e.TraceContext = carrier
+	}
+}
+
+// SpanContext returns the span context stored in the envelope, if any
+func (e *Envelope) SpanContext() trace.SpanContext {
+	if len(e.TraceContext) == 0 {
+		return trace.SpanContext{}
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(e.TraceContext))
+	return trace.SpanContextFromContext(ctx)
+}
+
+type eventKey struct {
+	eventType string
+	version   int
+}
+
+// EventRegistry maps event types and schema versions to Go types
+type EventRegistry struct {
+	mu        sync.RWMutex
+	factories map[eventKey]func() Event
+}
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{
+		factories: make(map[eventKey]func() Event),
+	}
+}
+
+// DefaultEventRegistry returns a registry with every domain event registered
+func DefaultEventRegistry() *EventRegistry {
+	r := NewEventRegistry()
+	r.Register(EventTypeUserCreated, 1, func() Event { return &UserCreatedEvent{} })
+	r.Register(EventTypePaymentProcessed, 1, func() Event { return &PaymentProcessedEvent{} })
+	r.Register(EventTypeOrderCreated, 1, func() Event { return &OrderCreatedEvent{} })
+	r.Register(EventTypeRewardProcessed, 1, func() Event { return &RewardProcessedEvent{} })
+	return r
+}
+
+// Register adds the decoder for one version of an event type. factory must
+// return a pointer that the payload can be unmarshalled into.
+func (r *EventRegistry) Register(eventType string, version int, factory func() Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.factories[eventKey{eventType: eventType, version: version}] = factory
+}
+
+// Decode unmarshals an envelope and its payload into the registered Go type
+func (r *EventRegistry) Decode(data []byte) (*Envelope, Event, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to deserialize envelope: %w", ErrMalformedEvent, err)
+	}
+	if env.Type == "" {
+		return nil, nil, ErrMissingEventType
+	}
+
+	event, err := r.DecodePayload(&env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &env, event, nil
+}
+
+// NewEvent returns an empty value of the Go type registered for one version
+// of an event type
+func (r *EventRegistry) NewEvent(eventType string, version int) (Event, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[eventKey{eventType: eventType, version: version}]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnknownEventType, eventType, version)
+	}
+
+	return factory(), nil
+}
+
+// DecodePayload unmarshals the JSON payload of env into the registered Go type
+func (r *EventRegistry) DecodePayload(env *Envelope) (Event, error) {
+	event, err := r.NewEvent(env.Type, env.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(env.Payload, event); err != nil {
+		return nil, fmt.Errorf("%w: failed to deserialize %s v%d payload: %w", ErrMalformedEvent, env.Type, env.SchemaVersion, err)
+	}
+
+	return event, nil
+}
+
+// EventHandler processes one decoded event
+type EventHandler func(ctx context.Context, env *Envelope, event Event) error
+
+// EventDispatcher decodes envelopes and routes them to the handler registered
+// for their event type. Events without a handler are skipped.
+type EventDispatcher struct {
+	serializer Serializer
+	handlers   map[string]EventHandler
+}
+
+func NewEventDispatcher(serializer Serializer) *EventDispatcher {
+	return &EventDispatcher{
+		serializer: serializer,
+		handlers:   make(map[string]EventHandler),
+	}
+}
+
+func (d *EventDispatcher) Handle(eventType string, handler EventHandler) {
+	d.handlers[eventType] = handler
+}
+
+// HandleEvent registers a handler that receives the concrete event type
+func HandleEvent[T any, PT interface {
+	*T
+	Event
+}](d *EventDispatcher, handler func(ctx context.Context, env *Envelope, event PT) error) {
+	var zero T
+	eventType := PT(&zero).EventType()
+
+	d.Handle(eventType, func(ctx context.Context, env *Envelope, event Event) error {
+		typed, ok := event.(PT)
+		if !ok {
+			return fmt.Errorf("unexpected payload type %T for %s", event, eventType)
+		}
+		return handler(ctx, env, typed)
+	})
+}
+
+// MessageHandler adapts the dispatcher to Consumer.StartConsuming. Messages
+// that cannot be decoded fail as NonRetryable.
+func (d *EventDispatcher) MessageHandler() MessageHandler {
+	return func(ctx context.Context, key string, value []byte) error {
+		// Binary formats keep the envelope in the message headers
+		msg, ok := MessageFromContext(ctx)
+		if !ok {
+			msg = kafka.Message{Key: []byte(key), Value: value}
+		}
+
+		env, event, err := d.serializer.Deserialize(ctx, msg)
+		if err != nil {
+			if undecodable(err) {
+				return NonRetryable(err)
+			}
+			return err
+		}
+		return d.dispatch(ctx, env, event)
+	}
+}
+
+// undecodable reports whether err means the message will never decode, as
+// opposed to e.g. the schema registry being unreachable
+func undecodable(err error) bool {
+	return errors.Is(err, ErrMalformedEvent) ||
+		errors.Is(err, ErrMissingEventType) ||
+		errors.Is(err, ErrUnknownEventType) ||
+		errors.Is(err, ErrInvalidWireFormat) ||
+		errors.Is(err, ErrSchemaNotFound)
+}
+
+func (d *EventDispatcher) dispatch(ctx context.Context, env *Envelope, event Event) error {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("event.id", env.EventID),
+		attribute.String("event.type", env.Type),
+		attribute.Int("event.schema_version", env.SchemaVersion),
+		attribute.String("event.producer", env.Producer),
+	)
+
+	// Link to the producer's trace when the headers did not carry it
+	if sc := env.SpanContext(); sc.IsValid() && sc.TraceID() != span.SpanContext().TraceID() &&
+		!linksTrace(ctx, sc.TraceID()) {
+		span.AddLink(trace.Link{SpanContext: sc})
+	}
+
+	handler, ok := d.handlers[env.Type]
+	if !ok {
+		span.SetAttributes(attribute.Bool("event.skipped", true))
+		return nil
+	}
+
+	return handler(ctx, env, event)
+}
@@ -2,34 +2,65 @@ package messaging
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
 )
 
 type KafkaManager struct {
-	config *config.KafkaConfig
-	tracer trace.Tracer
+	config     *config.KafkaConfig
+	tracer     trace.Tracer
+	metrics    *consumerMetrics
+	serializer Serializer
+	conn       *connection
 }
 
-func NewKafkaManager(cfg *config.KafkaConfig) *KafkaManager {
-	return &KafkaManager{
-		config: cfg,
-		tracer: otel.Tracer("kafka-client"),
+func NewKafkaManager(cfg *config.KafkaConfig) (*KafkaManager, error) {
+	metrics, err := newConsumerMetrics(otel.Meter("kafka-client"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer metrics: %w", err)
+	}
+
+	conn, err := newConnection(cfg)
+	if err != nil {
+		return nil, err
 	}
+
+	serializer, err := NewSerializer(&cfg.Serialization, DefaultEventRegistry())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create serializer: %w", err)
+	}
+
+	return &KafkaManager{
+		config:     cfg,
+		tracer:     otel.Tracer("kafka-client"),
+		metrics:    metrics,
+		serializer: serializer,
+		conn:       conn,
+	}, nil
+}
+
+// NewEventDispatcher returns a dispatcher that decodes events with the
+// configured Serializer
+func (km *KafkaManager) NewEventDispatcher() *EventDispatcher {
+	return NewEventDispatcher(km.serializer)
 }
 
 // Publisher for sending messages
 type Publisher struct {
-	writer *kafka.Writer
-	tracer trace.Tracer
+	writer     *kafka.Writer
+	tracer     trace.Tracer
+	producer   string
+	clientID   string
+	serializer Serializer
 }
 
 func (km *KafkaManager) NewPublisher(topic string) *Publisher {
@@ -39,40 +70,52 @@ func (km *KafkaManager) NewPublisher(topic string) *Publisher {
 		Balancer:     &kafka.LeastBytes{},
 		RequiredAcks: kafka.RequireOne,
 		Async:        false,
+		Transport:    km.conn.transport,
 	}
 
 	return &Publisher{
-		writer: writer,
-		tracer: km.tracer,
+		writer:     writer,
+		tracer:     km.tracer,
+		producer:   km.config.Producer,
+		clientID:   km.config.ClientID,
+		serializer: km.serializer,
 	}
 }
 
-func (p *Publisher) PublishMessage(ctx context.Context, key string, value interface{}) error {
-	ctx, span := p.tracer.Start(ctx, "kafka.publish",
-		trace.WithAttributes(
-			attribute.String("kafka.topic", p.writer.Topic),
-			attribute.String("kafka.key", key),
-		),
-	)
+// PublishEvent wraps event in an Envelope and publishes it with the
+// configured Serializer
+func (p *Publisher) PublishEvent(ctx context.Context, key string, event Event) error {
+	ctx, span := p.startSpan(ctx, key)
 	defer span.End()
 
-	// Serialize value to JSON
-	valueBytes, err := json.Marshal(value)
+	env := NewEnvelope(p.producer, event)
+	env.injectTraceContext(ctx)
+
+	span.SetAttributes(
+		semconv.MessagingMessageID(env.EventID),
+		attribute.String("event.id", env.EventID),
+		attribute.String("event.type", env.Type),
+		attribute.Int("event.schema_version", env.SchemaVersion),
+		attribute.String("kafka.serialization_format", p.serializer.Format()),
+	)
+
+	valueBytes, headers, err := p.serializer.Serialize(ctx, p.writer.Topic, env, event)
 	if err != nil {
 		span.RecordError(err)
-		return fmt.Errorf("failed to serialize message: %w", err)
+		return fmt.Errorf("failed to serialize event: %w", err)
 	}
 
-	// Create message with tracing headers
-	headers := make([]kafka.Header, 0)
+	return p.write(ctx, span, key, valueBytes, headers)
+}
 
+func (p *Publisher) write(ctx context.Context, span trace.Span, key string, value []byte, headers []kafka.Header) error {
 	// Inject trace context into headers
 	carrier := &headerCarrier{headers: &headers}
 	otel.GetTextMapPropagator().Inject(ctx, carrier)
 
 	msg := kafka.Message{
 		Key:     []byte(key),
-		Value:   valueBytes,
+		Value:   value,
 		Headers: headers,
 		Time:    time.Now(),
 	}
@@ -83,10 +126,7 @@ func (p *Publisher) PublishMessage(ctx context.Context, key string, value interf
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
-	span.SetAttributes(
-		attribute.Int("kafka.message_size", len(valueBytes)),
-		attribute.String("kafka.operation", "publish"),
-	)
+	span.SetAttributes(semconv.MessagingMessageBodySize(len(value)))
 
 	return nil
 }
@@ -97,11 +137,18 @@ func (p *Publisher) Close() error {
 
 // Consumer for receiving messages
 type Consumer struct {
-	reader *kafka.Reader
-	tracer trace.Tracer
+	reader     *kafka.Reader
+	tracer     trace.Tracer
+	metrics    *consumerMetrics
+	clientID   string
+	poolConfig config.KafkaConsumerConfig
+	// deadLetter is nil when the topic has no dead-letter topic
+	deadLetter *kafka.Writer
+	onFailure  MessageFailureHandler
 }
 
-func (km *KafkaManager) NewConsumer(topic, groupID string) *Consumer {
+// NewConsumer reads topic under groupID. onFailure may be nil.
+func (km *KafkaManager) NewConsumer(topic, groupID string, onFailure MessageFailureHandler) *Consumer {
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:        km.config.Brokers,
 		Topic:          topic,
@@ -109,16 +156,48 @@ func (km *KafkaManager) NewConsumer(topic, groupID string) *Consumer {
 		MinBytes:       10e3,
 		MaxBytes:       10e6,
 		CommitInterval: time.Second,
+		Dialer:         km.conn.dialer,
 	})
 
+	// Dead-letter topics only exist when provisioning creates them;
+	// StartConsumingConcurrently checks that this one does
+	var deadLetter *kafka.Writer
+	if dlq := DeadLetterTopic(km.config, topic); dlq != "" && km.config.Provisioning.Enabled {
+		deadLetter = &kafka.Writer{
+			Addr:         kafka.TCP(km.config.Brokers...),
+			Topic:        dlq,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			Transport:    km.conn.transport,
+		}
+	}
+
 	return &Consumer{
-		reader: reader,
-		tracer: km.tracer,
+		reader:     reader,
+		tracer:     km.tracer,
+		metrics:    km.metrics,
+		clientID:   km.config.ClientID,
+		poolConfig: km.config.Consumer,
+		deadLetter: deadLetter,
+		onFailure:  onFailure,
 	}
 }
 
 type MessageHandler func(ctx context.Context, key string, value []byte) error
 
+type messageContextKey struct{}
+
+func contextWithMessage(ctx context.Context, msg kafka.Message) context.Context {
+	return context.WithValue(ctx, messageContextKey{}, msg)
+}
+
+// MessageFromContext returns the Kafka message being handled, including its
+// headers, from a MessageHandler context
+func MessageFromContext(ctx context.Context) (kafka.Message, bool) {
+	msg, ok := ctx.Value(messageContextKey{}).(kafka.Message)
+	return msg, ok
+}
+
 func (c *Consumer) StartConsuming(ctx context.Context, handler MessageHandler) error {
 	for {
 		select {
@@ -130,79 +209,79 @@ func (c *Consumer) StartConsuming(ctx context.Context, handler MessageHandler) e
 				return fmt.Errorf("failed to read message: %w", err)
 			}
 
-			// Extract trace context from headers
-			carrier := &headerCarrier{headers: &msg.Headers}
-			msgCtx := otel.GetTextMapPropagator().Extract(ctx, carrier)
-
-			// Start span for message processing
-			msgCtx, span := c.tracer.Start(msgCtx, "kafka.consume",
-				trace.WithAttributes(
-					attribute.String("kafka.topic", msg.Topic),
-					attribute.Int("kafka.partition", msg.Partition),
-					attribute.Int64("kafka.offset", msg.Offset),
-					attribute.String("kafka.key", string(msg.Key)),
-					attribute.Int("kafka.message_size", len(msg.Value)),
-				),
-			)
-
-			// Process message
-			if err := handler(msgCtx, string(msg.Key), msg.Value); err != nil {
-				span.RecordError(err)
-				// In production, you might want to send to a dead letter queue
-			}
-
-			span.End()
+			// Errors are only recorded on the span; StartConsumingConcurrently
+			// retries and dead-letters failed messages
+			_ = c.processMessage(ctx, msg, handler)
 		}
 	}
 }
 
+// processMessage runs handler for a single message inside a consumer span
+// tied to the trace propagated through the message headers.
+func (c *Consumer) processMessage(ctx context.Context, msg kafka.Message, handler MessageHandler) error {
+	msgCtx, span := c.startProcessSpan(contextWithMessage(ctx, msg), msg)
+	defer span.End()
+
+	// Process message
+	err := handler(msgCtx, string(msg.Key), msg.Value)
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
 func (c *Consumer) Close() error {
-	return c.reader.Close()
+	err := c.reader.Close()
+	if c.deadLetter != nil {
+		err = errors.Join(err, c.deadLetter.Close())
+	}
+	return err
 }
 
 // Event structures for different message types
 type UserCreatedEvent struct {
-	UserID    string            `json:"user_id"`
-	Email     string            `json:"email"`
-	FirstName string            `json:"first_name"`
-	LastName  string            `json:"last_name"`
-	Metadata  map[string]string `json:"metadata,omitempty"`
-	Timestamp time.Time         `json:"timestamp"`
+	UserID    string            `json:"user_id" avro:"user_id"`
+	Email     string            `json:"email" avro:"email"`
+	FirstName string            `json:"first_name" avro:"first_name"`
+	LastName  string            `json:"last_name" avro:"last_name"`
+	Metadata  map[string]string `json:"metadata,omitempty" avro:"metadata"`
+	Timestamp time.Time         `json:"timestamp" avro:"timestamp"`
 }
 
 type PaymentProcessedEvent struct {
-	PaymentID     string            `json:"payment_id"`
-	UserID        string            `json:"user_id"`
-	OrderID       string            `json:"order_id,omitempty"`
-	Amount        float64           `json:"amount"`
-	Currency      string            `json:"currency"`
-	Status        string            `json:"status"`
-	ExternalTxnID string            `json:"external_txn_id,omitempty"`
-	Metadata      map[string]string `json:"metadata,omitempty"`
-	Timestamp     time.Time         `json:"timestamp"`
+	PaymentID     string            `json:"payment_id" avro:"payment_id"`
+	UserID        string            `json:"user_id" avro:"user_id"`
+	OrderID       string            `json:"order_id,omitempty" avro:"order_id"`
+	Amount        float64           `json:"amount" avro:"amount"`
+	Currency      string            `json:"currency" avro:"currency"`
+	Status        string            `json:"status" avro:"status"`
+	ExternalTxnID string            `json:"external_txn_id,omitempty" avro:"external_txn_id"`
+	Metadata      map[string]string `json:"metadata,omitempty" avro:"metadata"`
+	Timestamp     time.Time         `json:"timestamp" avro:"timestamp"`
 }
 
 type OrderCreatedEvent struct {
-	OrderID   string            `json:"order_id"`
-	UserID    string            `json:"user_id"`
-	Total     float64           `json:"total"`
-	Currency  string            `json:"currency"`
-	Status    string            `json:"status"`
-	ItemCount int               `json:"item_count"`
-	Metadata  map[string]string `json:"metadata,omitempty"`
-	Timestamp time.Time         `json:"timestamp"`
+	OrderID   string            `json:"order_id" avro:"order_id"`
+	UserID    string            `json:"user_id" avro:"user_id"`
+	Total     float64           `json:"total" avro:"total"`
+	Currency  string            `json:"currency" avro:"currency"`
+	Status    string            `json:"status" avro:"status"`
+	ItemCount int               `json:"item_count" avro:"item_count"`
+	Metadata  map[string]string `json:"metadata,omitempty" avro:"metadata"`
+	Timestamp time.Time         `json:"timestamp" avro:"timestamp"`
 }
 
 type RewardProcessedEvent struct {
-	RewardID  string            `json:"reward_id"`
-	UserID    string            `json:"user_id"`
-	Type      string            `json:"type"`
-	Points    int64             `json:"points"`
-	Value     float64           `json:"value,omitempty"`
-	Currency  string            `json:"currency,omitempty"`
-	Source    string            `json:"source"`
-	Metadata  map[string]string `json:"metadata,omitempty"`
-	Timestamp time.Time         `json:"timestamp"`
+	RewardID  string            `json:"reward_id" avro:"reward_id"`
+	UserID    string            `json:"user_id" avro:"user_id"`
+	Type      string            `json:"type" avro:"type"`
+	Points    int64             `json:"points" avro:"points"`
+	Value     float64           `json:"value,omitempty" avro:"value"`
+	Currency  string            `json:"currency,omitempty" avro:"currency"`
+	Source    string            `json:"source" avro:"source"`
+	Metadata  map[string]string `json:"metadata,omitempty" avro:"metadata"`
+	Timestamp time.Time         `json:"timestamp" avro:"timestamp"`
 }
 
 // Header carrier for trace context propagation
@@ -244,26 +323,26 @@ func (km *KafkaManager) PublishUserCreated(ctx context.Context, event UserCreate
 	publisher := km.NewPublisher(km.config.Topics.Users)
 	defer publisher.Close()
 
-	return publisher.PublishMessage(ctx, event.UserID, event)
+	return publisher.PublishEvent(ctx, event.UserID, event)
 }
 
 func (km *KafkaManager) PublishPaymentProcessed(ctx context.Context, event PaymentProcessedEvent) error {
 	publisher := km.NewPublisher(km.config.Topics.Payments)
 	defer publisher.Close()
 
-	return publisher.PublishMessage(ctx, event.PaymentID, event)
+	return publisher.PublishEvent(ctx, event.PaymentID, event)
 }
 
 func (km *KafkaManager) PublishOrderCreated(ctx context.Context, event OrderCreatedEvent) error {
 	publisher := km.NewPublisher(km.config.Topics.Orders)
 	defer publisher.Close()
 
-	return publisher.PublishMessage(ctx, event.OrderID, event)
+	return publisher.PublishEvent(ctx, event.OrderID, event)
 }
 
 func (km *KafkaManager) PublishRewardProcessed(ctx context.Context, event RewardProcessedEvent) error {
 	publisher := km.NewPublisher(km.config.Topics.Rewards)
 	defer publisher.Close()
 
-	return publisher.PublishMessage(ctx, event.RewardID, event)
+	return publisher.PublishEvent(ctx, event.RewardID, event)
 }
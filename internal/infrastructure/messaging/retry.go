@@ -0,0 +1,185 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// Headers added to a message when it is moved to its dead-letter topic
+const (
+	headerOriginalTopic     = "dlq_original_topic"
+	headerOriginalPartition = "dlq_original_partition"
+	headerOriginalOffset    = "dlq_original_offset"
+	headerError             = "dlq_error"
+	headerAttempts          = "dlq_attempts"
+)
+
+// ErrNonRetryable marks handler errors that fail the same way on every
+// attempt, such as a message that does not decode
+var ErrNonRetryable = errors.New("non-retryable")
+
+// NonRetryable marks err so that the message is moved to its dead-letter
+// topic, or skipped without one, on the first failure
+func NonRetryable(err error) error {
+	return nonRetryableError{err}
+}
+
+type nonRetryableError struct{ err error }
+
+func (e nonRetryableError) Error() string        { return e.err.Error() }
+func (e nonRetryableError) Unwrap() error        { return e.err }
+func (e nonRetryableError) Is(target error) bool { return target == ErrNonRetryable }
+
+// What happened to a message after a failed attempt
+const (
+	// The handler will be called again after a back-off
+	FailureRetrying = "retrying"
+	// The message was moved to its dead-letter topic and committed
+	FailureDeadLettered = "dead_lettered"
+	// The message failed with a NonRetryable error, or MaxAttempts times,
+	// and without a dead-letter topic was committed unhandled
+	FailureSkipped = "skipped"
+	// The consumer is shutting down; the message is not committed and will
+	// be delivered again
+	FailureUncommitted = "uncommitted"
+)
+
+// MessageFailure describes a failed attempt to handle a message
+type MessageFailure struct {
+	Topic           string
+	Partition       int
+	Offset          int64
+	Key             string
+	Attempt         int
+	Outcome         string
+	DeadLetterTopic string
+	Err             error
+}
+
+// MessageFailureHandler is called after every failed attempt to handle a
+// message, so that failures can be logged
+type MessageFailureHandler func(MessageFailure)
+
+// handleWithRetry calls handler until it succeeds or, after MaxAttempts, the
+// message is moved to the dead-letter topic, or skipped without one. It
+// returns false when the message must not be committed: ctx was cancelled
+// before either happened. NonRetryable errors are not retried: the message
+// is dead-lettered, or skipped, right away.
+func (c *Consumer) handleWithRetry(ctx, workCtx context.Context, cfg config.KafkaConsumerConfig, msg kafka.Message, handler MessageHandler) bool {
+	topic := attribute.String("topic", msg.Topic)
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := c.processMessage(workCtx, msg, handler)
+
+		status := "success"
+		if err != nil {
+			status = "error"
+		}
+		c.metrics.handlerDuration.Record(workCtx, time.Since(start).Seconds(), metric.WithAttributes(
+			topic,
+			attribute.String("status", status),
+		))
+		if err == nil {
+			return true
+		}
+
+		failure := MessageFailure{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Key:       string(msg.Key),
+			Attempt:   attempt,
+			Err:       err,
+		}
+
+		exhausted := errors.Is(err, ErrNonRetryable) || attempt >= cfg.MaxAttempts
+		if exhausted && c.deadLetter != nil {
+			dlqErr := c.publishDeadLetter(workCtx, msg, err, attempt)
+			if dlqErr == nil {
+				failure.Outcome = FailureDeadLettered
+				failure.DeadLetterTopic = c.deadLetter.Topic
+				c.metrics.deadLettered.Add(workCtx, 1, metric.WithAttributes(topic))
+				c.reportFailure(failure)
+				return true
+			}
+			failure.Err = errors.Join(err, dlqErr)
+		} else if exhausted {
+			failure.Outcome = FailureSkipped
+			c.metrics.skipped.Add(workCtx, 1, metric.WithAttributes(topic))
+			c.reportFailure(failure)
+			return true
+		}
+
+		if ctx.Err() != nil {
+			failure.Outcome = FailureUncommitted
+			c.reportFailure(failure)
+			return false
+		}
+
+		failure.Outcome = FailureRetrying
+		c.reportFailure(failure)
+		c.metrics.retries.Add(workCtx, 1, metric.WithAttributes(topic))
+
+		timer := time.NewTimer(retryBackoff(cfg, attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			failure.Outcome = FailureUncommitted
+			c.reportFailure(failure)
+			return false
+		}
+	}
+}
+
+// retryBackoff doubles RetryBackoff with every attempt up to RetryMaxBackoff
+func retryBackoff(cfg config.KafkaConsumerConfig, attempt int) time.Duration {
+	backoff := cfg.RetryBackoff
+	for i := 1; i < attempt && backoff < cfg.RetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, cfg.RetryMaxBackoff)
+}
+
+// publishDeadLetter copies msg, with its headers, to the dead-letter topic
+// and records where it came from and why it failed
+func (c *Consumer) publishDeadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int) error {
+	headers := append(slices.Clone(msg.Headers),
+		kafka.Header{Key: headerOriginalTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: headerOriginalPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: headerOriginalOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: headerError, Value: []byte(cause.Error())},
+		kafka.Header{Key: headerAttempts, Value: []byte(strconv.Itoa(attempts))},
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, commitTimeout)
+	defer cancel()
+
+	err := c.deadLetter.WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", c.deadLetter.Topic, err)
+	}
+	return nil
+}
+
+func (c *Consumer) reportFailure(failure MessageFailure) {
+	if c.onFailure != nil {
+		c.onFailure(failure)
+	}
+}
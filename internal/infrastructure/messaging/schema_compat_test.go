@@ -0,0 +1,199 @@
+package messaging
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hamba/avro/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// TestSchemas verifies the checked-in schemas of every registered event:
+// each Go struct must match its Avro and Protobuf schemas field for field,
+// and every schema version must stay both backward and forward compatible
+// with the versions before it, so neither old nor new consumers break.
+func TestSchemas(t *testing.T) {
+	registry := DefaultEventRegistry()
+	for _, key := range registry.keys() {
+		t.Run(fmt.Sprintf("%s v%d", key.eventType, key.version), func(t *testing.T) {
+			event, err := registry.NewEvent(key.eventType, key.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fields := jsonFieldNames(event)
+			t.Run("avro", func(t *testing.T) { testAvroSchemas(t, key, event, fields) })
+			t.Run("protobuf", func(t *testing.T) { testProtobufSchemas(t, key, fields) })
+		})
+	}
+}
+
+func (r *EventRegistry) keys() []eventKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]eventKey, 0, len(r.factories))
+	for key := range r.factories {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].eventType != keys[j].eventType {
+			return keys[i].eventType < keys[j].eventType
+		}
+		return keys[i].version < keys[j].version
+	})
+	return keys
+}
+
+// jsonFieldNames returns the JSON names of the exported fields of event
+func jsonFieldNames(event Event) map[string]reflect.StructField {
+	typ := reflect.TypeOf(event)
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	fields := make(map[string]reflect.StructField, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func testAvroSchemas(t *testing.T, key eventKey, event Event, fields map[string]reflect.StructField) {
+	versions := make([]avro.Schema, 0, key.version)
+	for version := 1; version <= key.version; version++ {
+		text, err := loadSchema(FormatAvro, key.eventType, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := parseAvroSchema(text)
+		if err != nil {
+			t.Fatalf("v%d does not parse: %v", version, err)
+		}
+		versions = append(versions, schema)
+	}
+
+	current, ok := versions[len(versions)-1].(*avro.RecordSchema)
+	if !ok {
+		t.Fatalf("schema is not a record")
+	}
+
+	schemaFields := make(map[string]bool)
+	for _, f := range current.Fields() {
+		schemaFields[f.Name()] = true
+		if _, ok := fields[f.Name()]; !ok {
+			t.Errorf("field %q has no struct field", f.Name())
+		}
+	}
+	for name, field := range fields {
+		if !schemaFields[name] {
+			t.Errorf("struct field %s (%q) is missing from the schema", field.Name, name)
+		}
+		if tag := field.Tag.Get("avro"); tag != name {
+			t.Errorf("struct field %s has avro tag %q, want %q", field.Name, tag, name)
+		}
+	}
+
+	// The struct must round-trip through the schema
+	if !t.Failed() {
+		if _, err := avro.Marshal(current, event); err != nil {
+			t.Errorf("struct does not encode: %v", err)
+		}
+	}
+
+	compat := avro.NewSchemaCompatibility()
+	latest := len(versions)
+	for i := 0; i < latest-1; i++ {
+		if err := compat.Compatible(versions[latest-1], versions[i]); err != nil {
+			t.Errorf("v%d cannot read v%d data: %v", latest, i+1, err)
+		}
+		if err := compat.Compatible(versions[i], versions[latest-1]); err != nil {
+			t.Errorf("v%d consumers cannot read v%d data: %v", i+1, latest, err)
+		}
+	}
+}
+
+func testProtobufSchemas(t *testing.T, key eventKey, fields map[string]reflect.StructField) {
+	versions := make([]protoreflect.MessageDescriptor, 0, key.version)
+	for version := 1; version <= key.version; version++ {
+		text, err := loadSchema(FormatProtobuf, key.eventType, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := compileProtoSchema(text)
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if file.Messages().Len() == 0 {
+			t.Fatalf("v%d has no messages", version)
+		}
+		versions = append(versions, file.Messages().Get(0))
+	}
+
+	current := versions[len(versions)-1]
+	schemaFields := current.Fields()
+	for i := 0; i < schemaFields.Len(); i++ {
+		name := string(schemaFields.Get(i).Name())
+		if _, ok := fields[name]; !ok {
+			t.Errorf("field %q has no struct field", name)
+		}
+	}
+	for name, field := range fields {
+		if schemaFields.ByName(protoreflect.Name(name)) == nil {
+			t.Errorf("struct field %s (%q) is missing from the schema", field.Name, name)
+		}
+	}
+
+	for i := 0; i < len(versions)-1; i++ {
+		for _, err := range protobufCompatible(versions[i], current) {
+			t.Errorf("v%d -> v%d: %v", i+1, len(versions), err)
+		}
+	}
+}
+
+// protobufCompatible reports changes from old to new that break readers of
+// either version: changing the name, type or cardinality of a field number,
+// or removing a field without reserving its number and name
+func protobufCompatible(old, new protoreflect.MessageDescriptor) []error {
+	var errs []error
+
+	oldFields := old.Fields()
+	for i := 0; i < oldFields.Len(); i++ {
+		of := oldFields.Get(i)
+		nf := new.Fields().ByNumber(of.Number())
+
+		if nf == nil {
+			if !new.ReservedRanges().Has(of.Number()) || !new.ReservedNames().Has(of.Name()) {
+				errs = append(errs, fmt.Errorf("field %s (%d) removed without reserving its number and name", of.Name(), of.Number()))
+			}
+			continue
+		}
+
+		if nf.Name() != of.Name() {
+			errs = append(errs, fmt.Errorf("field %d renamed from %s to %s", of.Number(), of.Name(), nf.Name()))
+		}
+		if nf.Kind() != of.Kind() || nf.Cardinality() != of.Cardinality() || nf.IsMap() != of.IsMap() {
+			errs = append(errs, fmt.Errorf("field %s (%d) changed type", of.Name(), of.Number()))
+		}
+		if nf.Kind() == protoreflect.MessageKind && of.Kind() == protoreflect.MessageKind &&
+			nf.Message().FullName() != of.Message().FullName() {
+			errs = append(errs, fmt.Errorf("field %s (%d) changed message type", of.Name(), of.Number()))
+		}
+	}
+
+	return errs
+}
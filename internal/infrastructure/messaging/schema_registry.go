@@ -0,0 +1,419 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// Schema types as reported by a Confluent-compatible schema registry
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+)
+
+var ErrSchemaNotFound = errors.New("schema not found")
+
+type Schema struct {
+	ID         int    `json:"id"`
+	Subject    string `json:"subject,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// SchemaRegistry is the subset of the Confluent schema registry API used by
+// the Avro and Protobuf serializers
+type SchemaRegistry interface {
+	// Register adds schema to subject (if it is not there yet) and returns its id
+	Register(ctx context.Context, subject, schemaType, schema string) (int, error)
+	// Lookup returns the id of schema under subject without registering it
+	Lookup(ctx context.Context, subject, schemaType, schema string) (int, error)
+	// SchemaByID returns the schema registered under id
+	SchemaByID(ctx context.Context, id int) (*Schema, error)
+}
+
+// NewSchemaRegistry returns a caching client for the configured registry URL,
+// or a file-backed registry when only SchemaRegistryFile is set
+func NewSchemaRegistry(cfg *config.KafkaSerializationConfig) (SchemaRegistry, error) {
+	switch {
+	case cfg.SchemaRegistryURL != "":
+		return NewCachedSchemaRegistry(NewSchemaRegistryClient(cfg)), nil
+	case cfg.SchemaRegistryFile != "":
+		return NewFileSchemaRegistry(cfg.SchemaRegistryFile)
+	default:
+		return nil, fmt.Errorf("%s serialization requires a schema registry URL or file", cfg.Format)
+	}
+}
+
+// SchemaRegistryClient talks to a Confluent-compatible schema registry over HTTP
+type SchemaRegistryClient struct {
+	client *resty.Client
+	tracer trace.Tracer
+}
+
+func NewSchemaRegistryClient(cfg *config.KafkaSerializationConfig) *SchemaRegistryClient {
+	client := resty.New().
+		SetBaseURL(cfg.SchemaRegistryURL).
+		SetHeader("Content-Type", "application/vnd.schemaregistry.v1+json").
+		SetHeader("Accept", "application/vnd.schemaregistry.v1+json").
+		SetTimeout(10 * time.Second)
+
+	if cfg.SchemaRegistryUsername != "" {
+		client.SetBasicAuth(cfg.SchemaRegistryUsername, cfg.SchemaRegistryPassword)
+	}
+
+	return &SchemaRegistryClient{
+		client: client,
+		tracer: otel.Tracer("schema-registry-client"),
+	}
+}
+
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+type registerRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+func (c *SchemaRegistryClient) Register(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	ctx, span := c.tracer.Start(ctx, "schema_registry.register",
+		trace.WithAttributes(
+			attribute.String("schema_registry.subject", subject),
+			attribute.String("schema_registry.schema_type", schemaType),
+		),
+	)
+	defer span.End()
+
+	var response Schema
+	var errorResp registryError
+
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetBody(registerRequest{Schema: schema, SchemaType: schemaType}).
+		SetResult(&response).
+		SetError(&errorResp).
+		Post("/subjects/" + url.PathEscape(subject) + "/versions")
+
+	if err != nil {
+		span.RecordError(err)
+		return 0, fmt.Errorf("schema registry register request failed: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode()))
+
+	if resp.IsError() {
+		err := fmt.Errorf("schema registry register failed: %d - %s", errorResp.ErrorCode, errorResp.Message)
+		span.RecordError(err)
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.Int("schema_registry.schema_id", response.ID))
+
+	return response.ID, nil
+}
+
+func (c *SchemaRegistryClient) Lookup(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	ctx, span := c.tracer.Start(ctx, "schema_registry.lookup",
+		trace.WithAttributes(
+			attribute.String("schema_registry.subject", subject),
+			attribute.String("schema_registry.schema_type", schemaType),
+		),
+	)
+	defer span.End()
+
+	var response Schema
+	var errorResp registryError
+
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetBody(registerRequest{Schema: schema, SchemaType: schemaType}).
+		SetResult(&response).
+		SetError(&errorResp).
+		Post("/subjects/" + url.PathEscape(subject))
+
+	if err != nil {
+		span.RecordError(err)
+		return 0, fmt.Errorf("schema registry lookup request failed: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode()))
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return 0, fmt.Errorf("%w: subject %s", ErrSchemaNotFound, subject)
+	}
+	if resp.IsError() {
+		err := fmt.Errorf("schema registry lookup failed: %d - %s", errorResp.ErrorCode, errorResp.Message)
+		span.RecordError(err)
+		return 0, err
+	}
+
+	return response.ID, nil
+}
+
+func (c *SchemaRegistryClient) SchemaByID(ctx context.Context, id int) (*Schema, error) {
+	ctx, span := c.tracer.Start(ctx, "schema_registry.get_schema",
+		trace.WithAttributes(
+			attribute.Int("schema_registry.schema_id", id),
+		),
+	)
+	defer span.End()
+
+	var response Schema
+	var errorResp registryError
+
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetResult(&response).
+		SetError(&errorResp).
+		Get(fmt.Sprintf("/schemas/ids/%d", id))
+
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("schema registry schema request failed: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode()))
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: id %d", ErrSchemaNotFound, id)
+	}
+	if resp.IsError() {
+		err := fmt.Errorf("schema registry schema request failed: %d - %s", errorResp.ErrorCode, errorResp.Message)
+		span.RecordError(err)
+		return nil, err
+	}
+
+	// The registry omits schemaType for Avro schemas
+	response.ID = id
+	if response.SchemaType == "" {
+		response.SchemaType = SchemaTypeAvro
+	}
+
+	return &response, nil
+}
+
+// CachedSchemaRegistry memoizes schema ids and schemas, which never change
+// once registered
+type CachedSchemaRegistry struct {
+	next    SchemaRegistry
+	mu      sync.RWMutex
+	ids     map[string]int
+	schemas map[int]*Schema
+}
+
+func NewCachedSchemaRegistry(next SchemaRegistry) *CachedSchemaRegistry {
+	return &CachedSchemaRegistry{
+		next:    next,
+		ids:     make(map[string]int),
+		schemas: make(map[int]*Schema),
+	}
+}
+
+func (c *CachedSchemaRegistry) Register(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	return c.cachedID(subject, schemaType, schema, func() (int, error) {
+		return c.next.Register(ctx, subject, schemaType, schema)
+	})
+}
+
+func (c *CachedSchemaRegistry) Lookup(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	return c.cachedID(subject, schemaType, schema, func() (int, error) {
+		return c.next.Lookup(ctx, subject, schemaType, schema)
+	})
+}
+
+func (c *CachedSchemaRegistry) cachedID(subject, schemaType, schema string, fetch func() (int, error)) (int, error) {
+	key := subject + "\x00" + schemaType + "\x00" + schema
+
+	c.mu.RLock()
+	id, ok := c.ids[key]
+	c.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := fetch()
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.ids[key] = id
+	c.mu.Unlock()
+
+	return id, nil
+}
+
+func (c *CachedSchemaRegistry) SchemaByID(ctx context.Context, id int) (*Schema, error) {
+	c.mu.RLock()
+	schema, ok := c.schemas[id]
+	c.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := c.next.SchemaByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.schemas[id] = schema
+	c.mu.Unlock()
+
+	return schema, nil
+}
+
+// FileSchemaRegistry is a local stand-in for the schema registry that keeps
+// its schemas in a JSON file. An empty path keeps them in memory only.
+type FileSchemaRegistry struct {
+	path    string
+	mu      sync.Mutex
+	schemas []Schema
+}
+
+func NewFileSchemaRegistry(path string) (*FileSchemaRegistry, error) {
+	r := &FileSchemaRegistry{path: path}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema registry file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &r.schemas); err != nil {
+		return nil, fmt.Errorf("failed to decode schema registry file: %w", err)
+	}
+
+	return r, nil
+}
+
+func (r *FileSchemaRegistry) Register(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s := r.find(subject, schemaType, schema); s != nil {
+		return s.ID, nil
+	}
+
+	version := 1
+	for _, s := range r.schemas {
+		if s.Subject == subject && s.Version >= version {
+			version = s.Version + 1
+		}
+	}
+
+	// Like the real registry, an identical schema keeps its id across subjects
+	id := len(r.schemas) + 1
+	for _, s := range r.schemas {
+		if s.SchemaType == schemaType && s.Schema == schema {
+			id = s.ID
+			break
+		}
+	}
+
+	// Only kept once it is on disk, so no id is handed out that a restart
+	// would lose
+	schemas := append(slices.Clone(r.schemas), Schema{
+		ID:         id,
+		Subject:    subject,
+		Version:    version,
+		SchemaType: schemaType,
+		Schema:     schema,
+	})
+	if err := r.save(schemas); err != nil {
+		return 0, err
+	}
+	r.schemas = schemas
+
+	return id, nil
+}
+
+func (r *FileSchemaRegistry) Lookup(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s := r.find(subject, schemaType, schema); s != nil {
+		return s.ID, nil
+	}
+
+	return 0, fmt.Errorf("%w: subject %s", ErrSchemaNotFound, subject)
+}
+
+func (r *FileSchemaRegistry) SchemaByID(ctx context.Context, id int) (*Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.schemas {
+		if s.ID == id {
+			schema := s
+			return &schema, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: id %d", ErrSchemaNotFound, id)
+}
+
+func (r *FileSchemaRegistry) find(subject, schemaType, schema string) *Schema {
+	for i, s := range r.schemas {
+		if s.Subject == subject && s.SchemaType == schemaType && s.Schema == schema {
+			return &r.schemas[i]
+		}
+	}
+	return nil
+}
+
+func (r *FileSchemaRegistry) save(schemas []Schema) error {
+	if r.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(schemas, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema registry file: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write schema registry file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write schema registry file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write schema registry file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("failed to write schema registry file: %w", err)
+	}
+
+	return nil
+}
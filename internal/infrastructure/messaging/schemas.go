@@ -0,0 +1,49 @@
+package messaging
+
+import (
+	"embed"
+	"fmt"
+)
+
+// Checked-in Avro and Protobuf schemas, one file per event type and version:
+// schemas/avro/<name>.v<version>.avsc and schemas/protobuf/<name>.v<version>.proto
+//
+//go:embed schemas
+var schemaFS embed.FS
+
+// schemaNames maps event types to their schema file names
+var schemaNames = map[string]string{
+	EventTypeUserCreated:      "user_created",
+	EventTypePaymentProcessed: "payment_processed",
+	EventTypeOrderCreated:     "order_created",
+	EventTypeRewardProcessed:  "reward_processed",
+}
+
+var schemaExtensions = map[string]string{
+	FormatAvro:     "avsc",
+	FormatProtobuf: "proto",
+}
+
+// schemaFileName is the schema file name for one version of an event type
+func schemaFileName(format, eventType string, version int) (string, error) {
+	name, ok := schemaNames[eventType]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownEventType, eventType)
+	}
+	return fmt.Sprintf("%s.v%d.%s", name, version, schemaExtensions[format]), nil
+}
+
+// loadSchema returns the checked-in schema for one version of an event type
+func loadSchema(format, eventType string, version int) (string, error) {
+	file, err := schemaFileName(format, eventType, version)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := schemaFS.ReadFile("schemas/" + format + "/" + file)
+	if err != nil {
+		return "", fmt.Errorf("no %s schema for %s v%d: %w", format, eventType, version, err)
+	}
+
+	return string(data), nil
+}
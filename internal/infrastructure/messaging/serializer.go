@@ -0,0 +1,249 @@
+package messaging
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// Serialization formats
+const (
+	FormatJSON     = "json"
+	FormatAvro     = "avro"
+	FormatProtobuf = "protobuf"
+)
+
+// Kafka headers that carry the envelope for the binary formats
+const (
+	headerContentType   = "content-type"
+	headerEventID       = "event_id"
+	headerEventType     = "event_type"
+	headerSchemaVersion = "event_schema_version"
+	headerOccurredAt    = "event_occurred_at"
+	headerProducer      = "event_producer"
+)
+
+// Confluent wire format: a zero magic byte followed by the big-endian schema id
+const (
+	wireMagicByte  = 0
+	wireHeaderSize = 5
+)
+
+var ErrInvalidWireFormat = errors.New("invalid schema registry wire format")
+
+// Serializer converts event envelopes to and from Kafka message values and
+// headers
+type Serializer interface {
+	Format() string
+	Serialize(ctx context.Context, topic string, env *Envelope, event Event) ([]byte, []kafka.Header, error)
+	Deserialize(ctx context.Context, msg kafka.Message) (*Envelope, Event, error)
+}
+
+func NewSerializer(cfg *config.KafkaSerializationConfig, events *EventRegistry) (Serializer, error) {
+	switch cfg.Format {
+	case "", FormatJSON:
+		return NewJSONSerializer(events), nil
+	case FormatAvro, FormatProtobuf:
+		registry, err := NewSchemaRegistry(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Format == FormatAvro {
+			return NewAvroSerializer(events, registry, cfg.AutoRegisterSchemas), nil
+		}
+		return NewProtobufSerializer(events, registry, cfg.AutoRegisterSchemas), nil
+	default:
+		return nil, fmt.Errorf("unsupported serialization format: %s", cfg.Format)
+	}
+}
+
+// JSONSerializer writes the whole envelope, payload included, as JSON
+type JSONSerializer struct {
+	events *EventRegistry
+}
+
+func NewJSONSerializer(events *EventRegistry) *JSONSerializer {
+	return &JSONSerializer{events: events}
+}
+
+func (s *JSONSerializer) Format() string {
+	return FormatJSON
+}
+
+func (s *JSONSerializer) Serialize(ctx context.Context, topic string, env *Envelope, event Event) ([]byte, []kafka.Header, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize %s payload: %w", env.Type, err)
+	}
+
+	wrapped := *env
+	wrapped.Payload = payload
+
+	value, err := json.Marshal(&wrapped)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize envelope: %w", err)
+	}
+
+	headers := []kafka.Header{{Key: headerContentType, Value: []byte("application/json")}}
+	return value, headers, nil
+}
+
+func (s *JSONSerializer) Deserialize(ctx context.Context, msg kafka.Message) (*Envelope, Event, error) {
+	return s.events.Decode(msg.Value)
+}
+
+// schemaCodec encodes payloads for one schema registry format
+type schemaCodec interface {
+	format() string
+	schemaType() string
+	contentType() string
+	encode(schema string, event Event) ([]byte, error)
+	decode(writerSchema, readerSchema string, data []byte, event Event) error
+}
+
+// registrySerializer writes the payload in the Confluent wire format using
+// the checked-in schema for its event type and version, and the rest of the
+// envelope as message headers
+type registrySerializer struct {
+	events       *EventRegistry
+	registry     SchemaRegistry
+	autoRegister bool
+	codec        schemaCodec
+	json         *JSONSerializer
+}
+
+func (s *registrySerializer) Format() string {
+	return s.codec.format()
+}
+
+func (s *registrySerializer) Serialize(ctx context.Context, topic string, env *Envelope, event Event) ([]byte, []kafka.Header, error) {
+	schema, err := loadSchema(s.codec.format(), env.Type, env.SchemaVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Subjects follow the default TopicNameStrategy
+	subject := topic + "-value"
+
+	var schemaID int
+	if s.autoRegister {
+		schemaID, err = s.registry.Register(ctx, subject, s.codec.schemaType(), schema)
+	} else {
+		schemaID, err = s.registry.Lookup(ctx, subject, s.codec.schemaType(), schema)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve schema id for %s: %w", subject, err)
+	}
+
+	payload, err := s.codec.encode(schema, event)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode %s payload: %w", env.Type, err)
+	}
+
+	value := make([]byte, wireHeaderSize, wireHeaderSize+len(payload))
+	value[0] = wireMagicByte
+	binary.BigEndian.PutUint32(value[1:wireHeaderSize], uint32(schemaID))
+	value = append(value, payload...)
+
+	headers := []kafka.Header{
+		{Key: headerContentType, Value: []byte(s.codec.contentType())},
+		{Key: headerEventID, Value: []byte(env.EventID)},
+		{Key: headerEventType, Value: []byte(env.Type)},
+		{Key: headerSchemaVersion, Value: []byte(strconv.Itoa(env.SchemaVersion))},
+		{Key: headerOccurredAt, Value: []byte(env.OccurredAt.Format(time.RFC3339Nano))},
+		{Key: headerProducer, Value: []byte(env.Producer)},
+	}
+
+	return value, headers, nil
+}
+
+func (s *registrySerializer) Deserialize(ctx context.Context, msg kafka.Message) (*Envelope, Event, error) {
+	// Messages published before switching formats are still JSON envelopes
+	if len(msg.Value) > 0 && msg.Value[0] == '{' {
+		return s.json.Deserialize(ctx, msg)
+	}
+
+	if len(msg.Value) < wireHeaderSize || msg.Value[0] != wireMagicByte {
+		return nil, nil, ErrInvalidWireFormat
+	}
+	schemaID := int(binary.BigEndian.Uint32(msg.Value[1:wireHeaderSize]))
+
+	env, err := envelopeFromHeaders(msg.Headers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	event, err := s.events.NewEvent(env.Type, env.SchemaVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	writer, err := s.registry.SchemaByID(ctx, schemaID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch writer schema %d: %w", schemaID, err)
+	}
+	if writer.SchemaType != s.codec.schemaType() {
+		return nil, nil, fmt.Errorf("%w: schema %d is %s, expected %s", ErrMalformedEvent, schemaID, writer.SchemaType, s.codec.schemaType())
+	}
+
+	reader, err := loadSchema(s.codec.format(), env.Type, env.SchemaVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.codec.decode(writer.Schema, reader, msg.Value[wireHeaderSize:], event); err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to decode %s payload: %w", ErrMalformedEvent, env.Type, err)
+	}
+
+	return env, event, nil
+}
+
+func envelopeFromHeaders(headers []kafka.Header) (*Envelope, error) {
+	values := make(map[string]string, len(headers))
+	for _, h := range headers {
+		values[h.Key] = string(h.Value)
+	}
+
+	if values[headerEventType] == "" {
+		return nil, ErrMissingEventType
+	}
+
+	version, err := strconv.Atoi(values[headerSchemaVersion])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s header: %w", ErrMalformedEvent, headerSchemaVersion, err)
+	}
+
+	occurredAt, err := time.Parse(time.RFC3339Nano, values[headerOccurredAt])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s header: %w", ErrMalformedEvent, headerOccurredAt, err)
+	}
+
+	env := &Envelope{
+		EventID:       values[headerEventID],
+		Type:          values[headerEventType],
+		SchemaVersion: version,
+		OccurredAt:    occurredAt,
+		Producer:      values[headerProducer],
+	}
+
+	// The trace context travels in the propagator's own headers
+	for _, field := range otel.GetTextMapPropagator().Fields() {
+		if v, ok := values[field]; ok {
+			if env.TraceContext == nil {
+				env.TraceContext = make(map[string]string)
+			}
+			env.TraceContext[field] = v
+		}
+	}
+
+	return env, nil
+}
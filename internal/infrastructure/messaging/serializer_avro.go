@@ -0,0 +1,85 @@
+package messaging
+
+import (
+	"sync"
+
+	"github.com/hamba/avro/v2"
+)
+
+func NewAvroSerializer(events *EventRegistry, registry SchemaRegistry, autoRegister bool) Serializer {
+	return &registrySerializer{
+		events:       events,
+		registry:     registry,
+		autoRegister: autoRegister,
+		codec:        &avroCodec{},
+		json:         NewJSONSerializer(events),
+	}
+}
+
+type avroCodec struct {
+	parsed   sync.Map // schema text -> avro.Schema
+	resolved sync.Map // [2]string{reader, writer} -> avro.Schema
+}
+
+func (c *avroCodec) format() string      { return FormatAvro }
+func (c *avroCodec) schemaType() string  { return SchemaTypeAvro }
+func (c *avroCodec) contentType() string { return "application/avro" }
+
+func (c *avroCodec) encode(schema string, event Event) ([]byte, error) {
+	s, err := c.parse(schema)
+	if err != nil {
+		return nil, err
+	}
+	return avro.Marshal(s, event)
+}
+
+// decode reads data written with writerSchema into event, resolving schema
+// differences against the local readerSchema
+func (c *avroCodec) decode(writerSchema, readerSchema string, data []byte, event Event) error {
+	reader, err := c.parse(readerSchema)
+	if err != nil {
+		return err
+	}
+
+	if writerSchema == readerSchema {
+		return avro.Unmarshal(reader, data, event)
+	}
+
+	key := [2]string{readerSchema, writerSchema}
+	if s, ok := c.resolved.Load(key); ok {
+		return avro.Unmarshal(s.(avro.Schema), data, event)
+	}
+
+	writer, err := c.parse(writerSchema)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := avro.NewSchemaCompatibility().Resolve(reader, writer)
+	if err != nil {
+		return err
+	}
+	c.resolved.Store(key, resolved)
+
+	return avro.Unmarshal(resolved, data, event)
+}
+
+func (c *avroCodec) parse(schema string) (avro.Schema, error) {
+	if s, ok := c.parsed.Load(schema); ok {
+		return s.(avro.Schema), nil
+	}
+
+	s, err := parseAvroSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	c.parsed.Store(schema, s)
+
+	return s, nil
+}
+
+// parseAvroSchema parses schema with its own name cache, so different
+// versions of the same record do not clash
+func parseAvroSchema(schema string) (avro.Schema, error) {
+	return avro.ParseWithCache(schema, "", &avro.SchemaCache{})
+}
@@ -0,0 +1,210 @@
+package messaging
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const protoSchemaFile = "event.proto"
+
+func NewProtobufSerializer(events *EventRegistry, registry SchemaRegistry, autoRegister bool) Serializer {
+	return &registrySerializer{
+		events:       events,
+		registry:     registry,
+		autoRegister: autoRegister,
+		codec:        &protobufCodec{},
+		json:         NewJSONSerializer(events),
+	}
+}
+
+// protobufCodec compiles the .proto schemas at runtime and bridges between
+// the event structs and dynamic messages through their JSON field names, so
+// no generated code has to be kept in sync with the schemas
+type protobufCodec struct {
+	compiled sync.Map // schema text -> protoreflect.FileDescriptor
+}
+
+func (c *protobufCodec) format() string      { return FormatProtobuf }
+func (c *protobufCodec) schemaType() string  { return SchemaTypeProtobuf }
+func (c *protobufCodec) contentType() string { return "application/x-protobuf" }
+
+func (c *protobufCodec) encode(schema string, event Event) ([]byte, error) {
+	file, err := c.compile(schema)
+	if err != nil {
+		return nil, err
+	}
+	if file.Messages().Len() == 0 {
+		return nil, fmt.Errorf("schema has no messages")
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown fields are rejected, so a struct that drifted from its schema
+	// fails loudly instead of silently dropping data
+	msg := dynamicpb.NewMessage(file.Messages().Get(0))
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Confluent message indexes: a single zero byte selects the first message
+	return append([]byte{0}, payload...), nil
+}
+
+func (c *protobufCodec) decode(writerSchema, readerSchema string, data []byte, event Event) error {
+	file, err := c.compile(writerSchema)
+	if err != nil {
+		return err
+	}
+
+	desc, data, err := protoMessageByIndexes(file, data)
+	if err != nil {
+		return err
+	}
+
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return err
+	}
+
+	// protojson renders 64-bit integers as strings, which encoding/json cannot
+	// read back into int64 fields, so convert the message by hand
+	values, err := json.Marshal(protoMessageToMap(msg))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(values, event)
+}
+
+func (c *protobufCodec) compile(schema string) (protoreflect.FileDescriptor, error) {
+	if file, ok := c.compiled.Load(schema); ok {
+		return file.(protoreflect.FileDescriptor), nil
+	}
+
+	file, err := compileProtoSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	c.compiled.Store(schema, file)
+
+	return file, nil
+}
+
+func compileProtoSchema(schema string) (protoreflect.FileDescriptor, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{protoSchemaFile: schema}),
+		}),
+	}
+
+	files, err := compiler.Compile(context.Background(), protoSchemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile protobuf schema: %w", err)
+	}
+
+	return files[0], nil
+}
+
+// protoMessageByIndexes reads the Confluent message-index prefix and returns
+// the message descriptor it points at along with the remaining payload
+func protoMessageByIndexes(file protoreflect.FileDescriptor, data []byte) (protoreflect.MessageDescriptor, []byte, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, nil, ErrInvalidWireFormat
+	}
+	data = data[n:]
+
+	// A zero count is shorthand for the first message in the file
+	indexes := []int64{0}
+	if count > 0 {
+		indexes = make([]int64, count)
+		for i := range indexes {
+			indexes[i], n = binary.Varint(data)
+			if n <= 0 {
+				return nil, nil, ErrInvalidWireFormat
+			}
+			data = data[n:]
+		}
+	}
+
+	messages := file.Messages()
+	var desc protoreflect.MessageDescriptor
+	for _, index := range indexes {
+		if index < 0 || int(index) >= messages.Len() {
+			return nil, nil, fmt.Errorf("%w: message index %d out of range", ErrInvalidWireFormat, index)
+		}
+		desc = messages.Get(int(index))
+		messages = desc.Messages()
+	}
+
+	return desc, data, nil
+}
+
+func protoMessageToMap(msg protoreflect.Message) map[string]any {
+	values := make(map[string]any)
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		values[string(fd.Name())] = protoFieldValue(fd, v)
+		return true
+	})
+	return values
+}
+
+func protoFieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch {
+	case fd.IsMap():
+		values := make(map[string]any)
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			values[k.String()] = protoSingularValue(fd.MapValue(), mv)
+			return true
+		})
+		return values
+	case fd.IsList():
+		list := v.List()
+		values := make([]any, list.Len())
+		for i := range values {
+			values[i] = protoSingularValue(fd, list.Get(i))
+		}
+		return values
+	default:
+		return protoSingularValue(fd, v)
+	}
+}
+
+func protoSingularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		msg := v.Message()
+		if msg.Descriptor().FullName() == "google.protobuf.Timestamp" {
+			fields := msg.Descriptor().Fields()
+			seconds := msg.Get(fields.ByName("seconds")).Int()
+			nanos := msg.Get(fields.ByName("nanos")).Int()
+			return time.Unix(seconds, nanos).UTC()
+		}
+		return protoMessageToMap(msg)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int32(v.Enum())
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,221 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const wireTestTopic = "test.events"
+
+// TestWireFormats serializes a sample of every registered event with the
+// Avro and Protobuf serializers and reads it back. Schemas are registered in
+// a FileSchemaRegistry and looked up again, without auto-registration, from
+// a registry reloaded from its file.
+func TestWireFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+
+	ctx := context.Background()
+	registry, err := NewFileSchemaRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Taken first so that no event schema gets id 1 and an id read from the
+	// wrong bytes cannot resolve by chance
+	if _, err := registry.Register(ctx, "test.other-value", SchemaTypeAvro, `"string"`); err != nil {
+		t.Fatal(err)
+	}
+
+	events := DefaultEventRegistry()
+	writers := map[string]Serializer{
+		FormatAvro:     NewAvroSerializer(events, registry, true),
+		FormatProtobuf: NewProtobufSerializer(events, registry, true),
+	}
+
+	type written struct {
+		format string
+		key    eventKey
+		env    *Envelope
+		event  Event
+		msg    kafka.Message
+	}
+	var messages []written
+	for _, format := range []string{FormatAvro, FormatProtobuf} {
+		for _, key := range events.keys() {
+			name := fmt.Sprintf("%s v%d %s", key.eventType, key.version, format)
+			event, err := sampleEvent(events, key)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			env := NewEnvelope("test", event)
+			value, headers, err := writers[format].Serialize(ctx, wireTestTopic, env, event)
+			if err != nil {
+				t.Errorf("%s: serialize: %v", name, err)
+				continue
+			}
+			msg := kafka.Message{Topic: wireTestTopic, Value: value, Headers: headers}
+			checkWireFraming(t, ctx, registry, name, format, key, msg)
+			messages = append(messages, written{format, key, env, event, msg})
+		}
+	}
+
+	reloaded, err := NewFileSchemaRegistry(path)
+	if err != nil {
+		t.Fatalf("reload registry file: %v", err)
+	}
+	readers := map[string]Serializer{
+		FormatAvro:     NewAvroSerializer(events, reloaded, false),
+		FormatProtobuf: NewProtobufSerializer(events, reloaded, false),
+	}
+
+	for _, m := range messages {
+		name := fmt.Sprintf("%s v%d %s", m.key.eventType, m.key.version, m.format)
+
+		// Publishing again must find the same id without registering it
+		value, _, err := readers[m.format].Serialize(ctx, wireTestTopic, m.env, m.event)
+		if err != nil {
+			t.Errorf("%s: serialize with the reloaded registry: %v", name, err)
+		} else if !bytes.Equal(value[:wireHeaderSize], m.msg.Value[:wireHeaderSize]) {
+			// Only the framing: protobuf map fields are encoded in random order
+			t.Errorf("%s: the reloaded registry framed the event with another schema id", name)
+		}
+
+		env, event, err := readers[m.format].Deserialize(ctx, m.msg)
+		if err != nil {
+			t.Errorf("%s: deserialize: %v", name, err)
+			continue
+		}
+		if env.EventID != m.env.EventID || env.Type != m.env.Type || env.SchemaVersion != m.env.SchemaVersion ||
+			!env.OccurredAt.Equal(m.env.OccurredAt) || env.Producer != m.env.Producer {
+			t.Errorf("%s: envelope %+v read back as %+v", name, m.env, env)
+		}
+		want, _ := json.Marshal(m.event)
+		got, _ := json.Marshal(event)
+		if string(got) != string(want) {
+			t.Errorf("%s: event %s read back as %s", name, want, got)
+		}
+
+		other := FormatProtobuf
+		if m.format == FormatProtobuf {
+			other = FormatAvro
+		}
+		if _, _, err := readers[other].Deserialize(ctx, m.msg); err == nil {
+			t.Errorf("%s: read by the %s serializer", name, other)
+		}
+	}
+
+	if len(messages) > 0 {
+		checkBadFraming(t, ctx, readers[FormatAvro], messages[0].msg)
+	}
+}
+
+// TestWireFraming expects the magic byte and a schema id that the registry
+// resolves to the checked-in schema and finds again under the topic subject
+func checkWireFraming(t *testing.T, ctx context.Context, registry SchemaRegistry, name, format string, key eventKey, msg kafka.Message) {
+	t.Helper()
+	if len(msg.Value) < wireHeaderSize || msg.Value[0] != wireMagicByte {
+		t.Errorf("%s: value does not start with the magic byte and a schema id", name)
+		return
+	}
+	id := int(binary.BigEndian.Uint32(msg.Value[1:wireHeaderSize]))
+
+	want, err := loadSchema(format, key.eventType, key.version)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	schema, err := registry.SchemaByID(ctx, id)
+	if err != nil {
+		t.Errorf("%s: schema id %d: %v", name, id, err)
+		return
+	}
+	if schema.Schema != want {
+		t.Errorf("%s: schema id %d is not the checked-in schema", name, id)
+		return
+	}
+
+	schemaType := SchemaTypeAvro
+	if format == FormatProtobuf {
+		schemaType = SchemaTypeProtobuf
+	}
+	if found, err := registry.Lookup(ctx, wireTestTopic+"-value", schemaType, want); err != nil || found != id {
+		t.Errorf("%s: lookup under %s-value returned %d (%v), want %d", name, wireTestTopic, found, err, id)
+	}
+}
+
+// TestBadFraming expects a missing magic byte and an unknown schema id to
+// be rejected
+func checkBadFraming(t *testing.T, ctx context.Context, s Serializer, msg kafka.Message) {
+	t.Helper()
+	noMagic := msg
+	noMagic.Value = append([]byte{1}, msg.Value[1:]...)
+	if _, _, err := s.Deserialize(ctx, noMagic); !errors.Is(err, ErrInvalidWireFormat) {
+		t.Errorf("wrong magic byte: got %v, want ErrInvalidWireFormat", err)
+	}
+
+	unknownID := msg
+	unknownID.Value = append([]byte(nil), msg.Value...)
+	binary.BigEndian.PutUint32(unknownID.Value[1:wireHeaderSize], 9999)
+	if _, _, err := s.Deserialize(ctx, unknownID); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("unknown schema id: got %v, want ErrSchemaNotFound", err)
+	}
+}
+
+// sampleEvent returns the event for key with every field set, so that a
+// field dropped on the way shows up in the round trip
+func sampleEvent(events *EventRegistry, key eventKey) (Event, error) {
+	event, err := events.NewEvent(key.eventType, key.version)
+	if err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(event).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field, typ := v.Field(i), v.Type().Field(i)
+		switch {
+		case typ.Type == reflect.TypeOf(time.Time{}):
+			field.Set(reflect.ValueOf(time.Date(2024, 5, 17, 9, 30, 15, 123456000, time.UTC)))
+		case field.Kind() == reflect.String:
+			field.SetString(typ.Name + "-1")
+		case field.CanInt():
+			field.SetInt(int64(i + 1))
+		case field.CanFloat():
+			field.SetFloat(float64(i) + 0.25)
+		case typ.Type == reflect.TypeOf(map[string]string{}):
+			field.Set(reflect.ValueOf(map[string]string{"source": "test"}))
+		default:
+			return nil, fmt.Errorf("no sample value for field %s of type %s", typ.Name, typ.Type)
+		}
+	}
+	return event, nil
+}
+
+// TestFileSchemaRegistrySaveFailure registers a schema whose file cannot be
+// written and expects the registry to forget it
+func TestFileSchemaRegistrySaveFailure(t *testing.T) {
+	registry, err := NewFileSchemaRegistry(filepath.Join(t.TempDir(), "missing", "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := registry.Register(ctx, "test-value", "AVRO", `"string"`); err == nil {
+		t.Fatal("registered a schema that was not saved")
+	}
+	if _, err := registry.Lookup(ctx, "test-value", "AVRO", `"string"`); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("lookup after a failed save: %v, want ErrSchemaNotFound", err)
+	}
+	if _, err := registry.SchemaByID(ctx, 1); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("schema 1 after a failed save: %v, want ErrSchemaNotFound", err)
+	}
+}
@@ -0,0 +1,311 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TopicSpec is the desired state of one Kafka topic
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Configs           map[string]string
+}
+
+// TopicDrift is a difference between a topic's spec and the cluster
+type TopicDrift struct {
+	Topic   string
+	Setting string
+	Want    string
+	Got     string
+}
+
+func (s TopicSpec) validate() error {
+	if s.Partitions < 1 {
+		return fmt.Errorf("topic %s: partitions must be at least 1, got %d", s.Name, s.Partitions)
+	}
+	if s.ReplicationFactor < 1 {
+		return fmt.Errorf("topic %s: replication factor must be at least 1, got %d", s.Name, s.ReplicationFactor)
+	}
+	return nil
+}
+
+func (d TopicDrift) String() string {
+	return fmt.Sprintf("%s: %s is %s, want %s", d.Topic, d.Setting, d.Got, d.Want)
+}
+
+// TopicReport is the outcome of ProvisionTopics. Missing lists the topics
+// that do not exist and were not created.
+type TopicReport struct {
+	Created []string
+	Missing []string
+	Drift   []TopicDrift
+}
+
+// OK reports whether every topic exists and matches its spec
+func (r *TopicReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Drift) == 0
+}
+
+// TopicSpecs expands the provisioning config into the full list of topics:
+// the domain topics plus their dead-letter topics
+func TopicSpecs(cfg *config.KafkaConfig) []TopicSpec {
+	prov := &cfg.Provisioning
+	names := topicNames(cfg)
+
+	var specs []TopicSpec
+	for _, key := range sortedKeys(names) {
+		topic := prov.Topics[key]
+		base := TopicSpec{
+			Name:              names[key],
+			Partitions:        topic.Partitions,
+			ReplicationFactor: topic.ReplicationFactor,
+			Configs:           topicConfigs(topic.Configs, topic.Retention.Milliseconds()),
+		}
+		specs = append(specs, base)
+
+		if topic.DLQ {
+			dlq := base
+			dlq.Name = base.Name + prov.DLQSuffix
+			dlq.Configs = topicConfigs(topic.Configs, topic.DLQRetention.Milliseconds())
+			specs = append(specs, dlq)
+		}
+	}
+
+	return specs
+}
+
+// DeadLetterTopic returns the dead-letter topic provisioned for topic, or ""
+// when it has none
+func DeadLetterTopic(cfg *config.KafkaConfig, topic string) string {
+	for key, name := range topicNames(cfg) {
+		if name == topic && cfg.Provisioning.Topics[key].DLQ {
+			return name + cfg.Provisioning.DLQSuffix
+		}
+	}
+	return ""
+}
+
+// topicNames maps the provisioning key of each domain topic to its name
+func topicNames(cfg *config.KafkaConfig) map[string]string {
+	return map[string]string{
+		"orders":   cfg.Topics.Orders,
+		"payments": cfg.Topics.Payments,
+		"rewards":  cfg.Topics.Rewards,
+		"users":    cfg.Topics.Users,
+	}
+}
+
+func topicConfigs(configs map[string]string, retentionMs int64) map[string]string {
+	merged := make(map[string]string, len(configs)+1)
+	for k, v := range configs {
+		merged[k] = v
+	}
+	if retentionMs != 0 {
+		merged["retention.ms"] = strconv.FormatInt(retentionMs, 10)
+	}
+	return merged
+}
+
+// ProvisionTopics compares every topic in TopicSpecs with the cluster and,
+// when create is set, creates the missing ones. Existing topics are never
+// altered: partition counts cannot shrink and growing them reshuffles keys,
+// so differences are reported as drift for an operator to resolve. Specs
+// without partitions or replicas are rejected before the cluster is asked.
+func (km *KafkaManager) ProvisionTopics(ctx context.Context, create bool) (*TopicReport, error) {
+	specs := TopicSpecs(km.config)
+	if err := validateTopicSpecs(specs); err != nil {
+		return nil, err
+	}
+
+	client := &kafka.Client{
+		Addr:      kafka.TCP(km.config.Brokers...),
+		Transport: km.conn.transport,
+	}
+
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.Name
+	}
+
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch topic metadata: %w", err)
+	}
+
+	existing := make(map[string]kafka.Topic, len(metadata.Topics))
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			if errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to fetch metadata for %s: %w", topic.Name, topic.Error)
+		}
+		existing[topic.Name] = topic
+	}
+
+	report := &TopicReport{}
+	var missing []TopicSpec
+	for _, spec := range specs {
+		topic, ok := existing[spec.Name]
+		if !ok {
+			missing = append(missing, spec)
+			continue
+		}
+		report.Drift = append(report.Drift, partitionDrift(spec, topic)...)
+	}
+
+	configDrift, err := configDrift(ctx, client, specs, existing)
+	if err != nil {
+		return nil, err
+	}
+	report.Drift = append(report.Drift, configDrift...)
+
+	if len(missing) == 0 {
+		return report, nil
+	}
+
+	if !create {
+		for _, spec := range missing {
+			report.Missing = append(report.Missing, spec.Name)
+		}
+		return report, nil
+	}
+
+	topics := make([]kafka.TopicConfig, len(missing))
+	for i, spec := range missing {
+		topics[i] = kafka.TopicConfig{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+		}
+		for name, value := range spec.Configs {
+			topics[i].ConfigEntries = append(topics[i].ConfigEntries, kafka.ConfigEntry{ConfigName: name, ConfigValue: value})
+		}
+	}
+
+	resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: topics})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create topics: %w", err)
+	}
+
+	var errs []error
+	for _, spec := range missing {
+		switch err := resp.Errors[spec.Name]; {
+		case err == nil:
+			report.Created = append(report.Created, spec.Name)
+		case errors.Is(err, kafka.TopicAlreadyExists):
+			// Created concurrently by another instance
+		default:
+			errs = append(errs, fmt.Errorf("failed to create %s: %w", spec.Name, err))
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+func validateTopicSpecs(specs []TopicSpec) error {
+	var errs []error
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func partitionDrift(spec TopicSpec, topic kafka.Topic) []TopicDrift {
+	var drift []TopicDrift
+
+	if len(topic.Partitions) != spec.Partitions {
+		drift = append(drift, TopicDrift{
+			Topic:   spec.Name,
+			Setting: "partitions",
+			Want:    strconv.Itoa(spec.Partitions),
+			Got:     strconv.Itoa(len(topic.Partitions)),
+		})
+	}
+
+	for _, partition := range topic.Partitions {
+		if len(partition.Replicas) != spec.ReplicationFactor {
+			drift = append(drift, TopicDrift{
+				Topic:   spec.Name,
+				Setting: "replication factor",
+				Want:    strconv.Itoa(spec.ReplicationFactor),
+				Got:     strconv.Itoa(len(partition.Replicas)),
+			})
+			break
+		}
+	}
+
+	return drift
+}
+
+func configDrift(ctx context.Context, client *kafka.Client, specs []TopicSpec, existing map[string]kafka.Topic) ([]TopicDrift, error) {
+	bySpec := make(map[string]TopicSpec)
+	var resources []kafka.DescribeConfigRequestResource
+	for _, spec := range specs {
+		if _, ok := existing[spec.Name]; !ok || len(spec.Configs) == 0 {
+			continue
+		}
+
+		bySpec[spec.Name] = spec
+		resources = append(resources, kafka.DescribeConfigRequestResource{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: spec.Name,
+			ConfigNames:  sortedKeys(spec.Configs),
+		})
+	}
+
+	if len(resources) == 0 {
+		return nil, nil
+	}
+
+	resp, err := client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe topic configs: %w", err)
+	}
+
+	var drift []TopicDrift
+	for _, resource := range resp.Resources {
+		if resource.Error != nil {
+			return nil, fmt.Errorf("failed to describe %s: %w", resource.ResourceName, resource.Error)
+		}
+
+		spec := bySpec[resource.ResourceName]
+		actual := make(map[string]string, len(resource.ConfigEntries))
+		for _, entry := range resource.ConfigEntries {
+			actual[entry.ConfigName] = entry.ConfigValue
+		}
+
+		for _, name := range sortedKeys(spec.Configs) {
+			if got := actual[name]; got != spec.Configs[name] {
+				drift = append(drift, TopicDrift{
+					Topic:   spec.Name,
+					Setting: name,
+					Want:    spec.Configs[name],
+					Got:     got,
+				})
+			}
+		}
+	}
+
+	return drift, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package messaging
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TestProvisionTopicsInvalidSpec expects a topic without partitions or
+// replicas, and its dead-letter topic, to be rejected before the cluster is
+// contacted
+func TestProvisionTopicsInvalidSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		spec config.KafkaTopicSpec
+		want string
+	}{
+		{"no partitions", config.KafkaTopicSpec{ReplicationFactor: 1, DLQ: true}, "partitions"},
+		{"no replicas", config.KafkaTopicSpec{Partitions: 6, DLQ: true}, "replication factor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid := config.KafkaTopicSpec{Partitions: 6, ReplicationFactor: 1}
+			cfg := &config.KafkaConfig{
+				Topics: config.Topics{Orders: "orders", Payments: "payments", Rewards: "rewards", Users: "users"},
+				Provisioning: config.KafkaProvisioningConfig{
+					DLQSuffix: ".dlq",
+					Topics: map[string]config.KafkaTopicSpec{
+						"orders":   tt.spec,
+						"payments": valid,
+						"rewards":  valid,
+						"users":    valid,
+					},
+				},
+			}
+
+			// No connection: validation must fail before it is needed
+			km := &KafkaManager{config: cfg}
+			report, err := km.ProvisionTopics(context.Background(), true)
+			if err == nil {
+				t.Fatal("provisioned an invalid topic spec")
+			}
+			if report != nil {
+				t.Errorf("report = %+v, want nil", report)
+			}
+			for _, topic := range []string{"topic orders:", "topic orders.dlq:"} {
+				if !strings.Contains(err.Error(), topic+" "+tt.want) {
+					t.Errorf("error %q does not reject %s %s", err, topic, tt.want)
+				}
+			}
+			if strings.Contains(err.Error(), "payments") {
+				t.Errorf("error %q rejects a valid topic", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package messaging
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How a consumer span relates to the producer span propagated in the
+// message headers
+const (
+	// TraceAsChild continues the producer's trace
+	TraceAsChild = "parent"
+	// TraceAsLink starts a new trace linked to the producer's span, which
+	// keeps long-lived or fan-in consumers from growing the producer's trace
+	TraceAsLink = "link"
+)
+
+// Messaging operation names, see the OTel messaging semantic conventions
+const (
+	operationPublish = "publish"
+	operationProcess = "process"
+)
+
+func (p *Publisher) startSpan(ctx context.Context, key string) (context.Context, trace.Span) {
+	return p.tracer.Start(ctx, p.writer.Topic+" "+operationPublish,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingOperationName(operationPublish),
+			semconv.MessagingDestinationName(p.writer.Topic),
+			semconv.MessagingKafkaMessageKey(key),
+			semconv.MessagingClientID(p.clientID),
+		),
+	)
+}
+
+// startProcessSpan starts the consumer span for msg. Depending on the trace
+// mode the producer's span becomes its parent or a link.
+func (c *Consumer) startProcessSpan(ctx context.Context, msg kafka.Message) (context.Context, trace.Span) {
+	carrier := &headerCarrier{headers: &msg.Headers}
+	producerCtx := otel.GetTextMapPropagator().Extract(ctx, carrier)
+
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(c.spanAttributes(msg.Topic)...),
+		trace.WithAttributes(
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(msg.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			semconv.MessagingKafkaMessageKey(string(msg.Key)),
+			semconv.MessagingMessageBodySize(len(msg.Value)),
+		),
+	}
+
+	var linked trace.SpanContext
+	if c.poolConfig.TraceContext == TraceAsLink {
+		if sc := trace.SpanContextFromContext(producerCtx); sc.IsValid() {
+			linked = sc
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+		}
+		opts = append(opts, trace.WithNewRoot())
+	}
+
+	// producerCtx also carries the propagated baggage
+	ctx, span := c.tracer.Start(producerCtx, msg.Topic+" "+operationProcess, opts...)
+	if linked.IsValid() {
+		ctx = context.WithValue(ctx, linkedTraceKey{}, linked.TraceID())
+	}
+	return ctx, span
+}
+
+// linkedTraceKey holds the trace the consumer span in the context already
+// links to
+type linkedTraceKey struct{}
+
+// linksTrace reports whether the consumer span in ctx links to traceID
+func linksTrace(ctx context.Context, traceID trace.TraceID) bool {
+	linked, ok := ctx.Value(linkedTraceKey{}).(trace.TraceID)
+	return ok && linked == traceID
+}
+
+func (c *Consumer) spanAttributes(topic string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKafka,
+		semconv.MessagingOperationTypeDeliver,
+		semconv.MessagingOperationName(operationProcess),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingClientID(c.clientID),
+	}
+	if groupID := c.reader.Config().GroupID; groupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(groupID))
+	}
+	return attrs
+}
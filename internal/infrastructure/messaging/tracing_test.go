@@ -0,0 +1,68 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TestConsumerSpanLinks checks that a consumer span links to the producer
+// span once, whether the link comes from the headers, the envelope or both
+func TestConsumerSpanLinks(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	producerCtx, producer := provider.Tracer("test").Start(context.Background(), "test publish")
+	producer.End()
+
+	reader := kafka.NewReader(kafka.ReaderConfig{Brokers: []string{"localhost:9092"}, Topic: "test"})
+	defer reader.Close()
+
+	tests := []struct {
+		name         string
+		traceContext string
+		headers      bool
+		links        int
+	}{
+		{"link mode with headers", TraceAsLink, true, 1},
+		{"link mode without headers", TraceAsLink, false, 1},
+		{"parent mode with headers", TraceAsChild, true, 0},
+		{"parent mode without headers", TraceAsChild, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Consumer{
+				reader:     reader,
+				tracer:     provider.Tracer("test"),
+				poolConfig: config.KafkaConsumerConfig{TraceContext: tt.traceContext},
+			}
+
+			msg := kafka.Message{Topic: "test"}
+			if tt.headers {
+				otel.GetTextMapPropagator().Inject(producerCtx, &headerCarrier{headers: &msg.Headers})
+			}
+			event := &UserCreatedEvent{}
+			env := NewEnvelope("test", event)
+			env.injectTraceContext(producerCtx)
+
+			ctx, span := c.startProcessSpan(context.Background(), msg)
+			if err := NewEventDispatcher(nil).dispatch(ctx, env, event); err != nil {
+				t.Fatal(err)
+			}
+			span.End()
+
+			spans := recorder.Ended()
+			if got := len(spans[len(spans)-1].Links()); got != tt.links {
+				t.Errorf("consumer span has %d links, want %d", got, tt.links)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+const (
+	OrderingByKey       = "key"
+	OrderingByPartition = "partition"
+
+	commitTimeout = 5 * time.Second
+)
+
+// StartConsumingConcurrently processes messages on a pool of workers.
+// Messages that share an ordering key (the message key, or the partition
+// when the pool is configured with OrderingByPartition) are always routed to
+// the same worker and handled in the order they were read, while different
+// keys are handled in parallel.
+//
+// Every worker has a bounded queue and the number of fetched-but-unfinished
+// messages is capped by MaxInFlight, so a slow handler blocks fetching
+// instead of buffering the topic in memory. Offsets are committed only once
+// every earlier message of the same partition has been handled.
+//
+// A failed message is retried with backoff, holding up its worker, and
+// after MaxAttempts moved to the topic's dead-letter topic, or skipped when
+// the topic has none. It is never committed before one of these happens.
+// The pool does not start while the dead-letter topic is missing.
+//
+// When ctx is cancelled the pool stops fetching, drains the queued messages
+// and returns ctx.Err(). Messages that fail while draining are left
+// uncommitted so that they are delivered again.
+func (c *Consumer) StartConsumingConcurrently(ctx context.Context, handler MessageHandler) error {
+	if err := c.checkDeadLetter(ctx); err != nil {
+		return err
+	}
+	cfg := normalizePoolConfig(c.poolConfig)
+
+	tracker := newOffsetTracker()
+	inFlight := make(chan struct{}, cfg.MaxInFlight)
+	queues := make([]chan kafka.Message, cfg.Workers)
+
+	// Queued messages are still handled after ctx is cancelled so that the
+	// pool can drain cleanly
+	workCtx := context.WithoutCancel(ctx)
+
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, cfg.QueueSize)
+
+		wg.Add(1)
+		go func(queue <-chan kafka.Message) {
+			defer wg.Done()
+			for msg := range queue {
+				c.metrics.queueDepth.Add(workCtx, -1, metric.WithAttributes(attribute.String("topic", msg.Topic)))
+				c.handleMessage(ctx, workCtx, cfg, msg, handler, tracker)
+				<-inFlight
+			}
+		}(queues[i])
+	}
+
+	err := c.dispatch(ctx, cfg, queues, inFlight, tracker)
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	return err
+}
+
+func (c *Consumer) dispatch(ctx context.Context, cfg config.KafkaConsumerConfig, queues []chan kafka.Message, inFlight chan struct{}, tracker *offsetTracker) error {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to fetch message: %w", err)
+		}
+
+		c.metrics.lag.Record(ctx, msg.HighWaterMark-msg.Offset-1, metric.WithAttributes(
+			attribute.String("topic", msg.Topic),
+			attribute.String("partition", strconv.Itoa(msg.Partition)),
+		))
+
+		// Backpressure: wait for a free in-flight slot before queueing
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// A hot key can fill its worker's queue before MaxInFlight is
+		// reached; fetching then waits for that worker to catch up
+		tracker.track(msg)
+		topic := metric.WithAttributes(attribute.String("topic", msg.Topic))
+		c.metrics.queueDepth.Add(ctx, 1, topic)
+		select {
+		case queues[workerIndex(msg, cfg.OrderingKey, len(queues))] <- msg:
+		case <-ctx.Done():
+			c.metrics.queueDepth.Add(ctx, -1, topic)
+			<-inFlight
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Consumer) handleMessage(ctx, workCtx context.Context, cfg config.KafkaConsumerConfig, msg kafka.Message, handler MessageHandler, tracker *offsetTracker) {
+	if !c.handleWithRetry(ctx, workCtx, cfg, msg, handler) {
+		return
+	}
+
+	commit, ok := tracker.complete(msg)
+	if !ok || c.reader.Config().GroupID == "" {
+		return
+	}
+
+	commitCtx, cancel := context.WithTimeout(workCtx, commitTimeout)
+	defer cancel()
+
+	if err := c.reader.CommitMessages(commitCtx, commit); err != nil {
+		c.metrics.commitErrors.Add(workCtx, 1, metric.WithAttributes(attribute.String("topic", msg.Topic)))
+	}
+}
+
+// workerIndex picks the worker that owns the ordering key of msg. Messages
+// without a key fall back to partition ordering.
+func workerIndex(msg kafka.Message, orderingKey string, workers int) int {
+	h := fnv.New32a()
+	if orderingKey == OrderingByKey && len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		h.Write([]byte(strconv.Itoa(msg.Partition)))
+	}
+	return int(h.Sum32() % uint32(workers))
+}
+
+func normalizePoolConfig(cfg config.KafkaConsumerConfig) config.KafkaConsumerConfig {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = cfg.Workers * cfg.QueueSize
+	}
+	if cfg.OrderingKey != OrderingByPartition {
+		cfg.OrderingKey = OrderingByKey
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.RetryMaxBackoff < cfg.RetryBackoff {
+		cfg.RetryMaxBackoff = cfg.RetryBackoff
+	}
+	return cfg
+}
+
+// offsetTracker keeps per-partition offsets in fetch order so that an offset
+// is only committed after every message before it has been handled.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	pending []kafka.Message
+	done    map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[int]*partitionOffsets),
+	}
+}
+
+func (t *offsetTracker) track(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[msg.Partition] = p
+	}
+	p.pending = append(p.pending, msg)
+}
+
+// complete marks msg as handled and returns the newest message of its
+// partition that can now be committed, if any.
+func (t *offsetTracker) complete(msg kafka.Message) (kafka.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		return kafka.Message{}, false
+	}
+	p.done[msg.Offset] = true
+
+	var commit kafka.Message
+	committable := false
+	for len(p.pending) > 0 && p.done[p.pending[0].Offset] {
+		commit = p.pending[0]
+		committable = true
+		delete(p.done, commit.Offset)
+		p.pending = p.pending[1:]
+	}
+
+	return commit, committable
+}
+
+// Consumer metrics
+type consumerMetrics struct {
+	queueDepth      metric.Int64UpDownCounter
+	handlerDuration metric.Float64Histogram
+	lag             metric.Int64Gauge
+	commitErrors    metric.Int64Counter
+	retries         metric.Int64Counter
+	deadLettered    metric.Int64Counter
+	skipped         metric.Int64Counter
+}
+
+func newConsumerMetrics(meter metric.Meter) (*consumerMetrics, error) {
+	queueDepth, err := meter.Int64UpDownCounter(
+		"kafka_consumer_queue_depth",
+		metric.WithDescription("Messages fetched and waiting for a consumer worker"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerDuration, err := meter.Float64Histogram(
+		"kafka_consumer_handler_duration_seconds",
+		metric.WithDescription("Kafka message handler duration in seconds"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lag, err := meter.Int64Gauge(
+		"kafka_consumer_lag",
+		metric.WithDescription("Messages between the last fetched offset and the partition high water mark"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	commitErrors, err := meter.Int64Counter(
+		"kafka_consumer_commit_errors_total",
+		metric.WithDescription("Total failed Kafka offset commits"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := meter.Int64Counter(
+		"kafka_consumer_retries_total",
+		metric.WithDescription("Total failed message handler attempts that were retried"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLettered, err := meter.Int64Counter(
+		"kafka_consumer_dead_lettered_total",
+		metric.WithDescription("Total messages moved to a dead-letter topic after their last attempt"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped, err := meter.Int64Counter(
+		"kafka_consumer_skipped_total",
+		metric.WithDescription("Total messages committed unhandled after a non-retryable failure, for want of a dead-letter topic"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consumerMetrics{
+		queueDepth:      queueDepth,
+		handlerDuration: handlerDuration,
+		lag:             lag,
+		commitErrors:    commitErrors,
+		retries:         retries,
+		deadLettered:    deadLettered,
+		skipped:         skipped,
+	}, nil
+}
+
+// checkDeadLetter fails when the consumer has a dead-letter topic that does
+// not exist, so that failed messages are not written to nowhere
+func (c *Consumer) checkDeadLetter(ctx context.Context) error {
+	if c.deadLetter == nil {
+		return nil
+	}
+
+	client := &kafka.Client{Addr: c.deadLetter.Addr, Transport: c.deadLetter.Transport}
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{c.deadLetter.Topic}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch dead-letter topic metadata: %w", err)
+	}
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			return fmt.Errorf("dead-letter topic %s: %w", topic.Name, topic.Error)
+		}
+	}
+	return nil
+}
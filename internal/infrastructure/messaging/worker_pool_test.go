@@ -0,0 +1,147 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	noopmetric "go.opentelemetry.io/otel/metric/noop"
+	nooptrace "go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TestOffsetTracker fetches offsets 10 to 14 of partition 0 and 20 and 21
+// of partition 1, and completes them out of order
+func TestOffsetTracker(t *testing.T) {
+	tracker := newOffsetTracker()
+	msg := func(partition int, offset int64) kafka.Message {
+		return kafka.Message{Topic: "check", Partition: partition, Offset: offset}
+	}
+	for offset := int64(10); offset <= 14; offset++ {
+		tracker.track(msg(0, offset))
+	}
+	tracker.track(msg(1, 20))
+	tracker.track(msg(1, 21))
+
+	steps := []struct {
+		partition int
+		offset    int64
+		// commit is the offset committed after completing this one, or -1
+		commit int64
+	}{
+		{0, 12, -1},
+		{1, 21, -1},
+		{0, 10, 10},
+		{1, 20, 21},
+		{0, 11, 12},
+		{0, 14, -1},
+		{0, 13, 14},
+		{2, 30, -1},
+	}
+	for _, step := range steps {
+		commit, ok := tracker.complete(msg(step.partition, step.offset))
+		got := int64(-1)
+		if ok {
+			got = commit.Offset
+			if commit.Partition != step.partition {
+				t.Errorf("offsets: completing %d/%d committed partition %d", step.partition, step.offset, commit.Partition)
+			}
+		}
+		if got != step.commit {
+			t.Errorf("offsets: completing %d/%d committed offset %d, want %d", step.partition, step.offset, got, step.commit)
+		}
+	}
+
+	for partition, p := range tracker.partitions {
+		if len(p.pending) > 0 || len(p.done) > 0 {
+			t.Errorf("offsets: partition %d still tracks %d pending and %d done messages", partition, len(p.pending), len(p.done))
+		}
+	}
+}
+
+// TestRetryBackoff expects the backoff to double from RetryBackoff up to
+// RetryMaxBackoff
+func TestRetryBackoff(t *testing.T) {
+	cfg := normalizePoolConfig(config.KafkaConsumerConfig{RetryBackoff: time.Second, RetryMaxBackoff: 5 * time.Second})
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := retryBackoff(cfg, attempt+1); got != want {
+			t.Errorf("retry backoff: attempt %d waits %s, want %s", attempt+1, got, want)
+		}
+	}
+	if got := retryBackoff(cfg, 100); got != 5*time.Second {
+		t.Errorf("retry backoff: attempt 100 waits %s, want the 5s cap", got)
+	}
+}
+
+// TestNonRetryableSkipped feeds a message that does not decode to a
+// consumer without a dead-letter topic and expects it to be skipped on the
+// first attempt instead of being retried
+func TestNonRetryableSkipped(t *testing.T) {
+	metrics, err := newConsumerMetrics(noopmetric.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Never read from: the message is handed to handleWithRetry directly
+	reader := kafka.NewReader(kafka.ReaderConfig{Brokers: []string{"localhost:9092"}, Topic: "test"})
+	defer reader.Close()
+
+	var failures []MessageFailure
+	c := &Consumer{
+		reader:    reader,
+		tracer:    nooptrace.NewTracerProvider().Tracer("test"),
+		metrics:   metrics,
+		onFailure: func(f MessageFailure) { failures = append(failures, f) },
+	}
+	handler := NewEventDispatcher(NewJSONSerializer(DefaultEventRegistry())).MessageHandler()
+	cfg := normalizePoolConfig(config.KafkaConsumerConfig{MaxAttempts: 5, RetryBackoff: time.Minute, RetryMaxBackoff: time.Minute})
+
+	ctx := context.Background()
+	msg := kafka.Message{Topic: "test", Offset: 7, Value: []byte(`{"type":`)}
+	if !c.handleWithRetry(ctx, ctx, cfg, msg, handler) {
+		t.Fatal("undecodable message left uncommitted")
+	}
+	if len(failures) != 1 {
+		t.Fatalf("%d failed attempts, want 1", len(failures))
+	}
+	if got := failures[0]; got.Outcome != FailureSkipped || !errors.Is(got.Err, ErrNonRetryable) || !errors.Is(got.Err, ErrMalformedEvent) {
+		t.Errorf("failure %s: %v, want a skipped, non-retryable ErrMalformedEvent", got.Outcome, got.Err)
+	}
+}
+
+// TestRetriesExhaustedSkipped fails a message on every attempt on a consumer
+// without a dead-letter topic and expects it to be skipped after MaxAttempts
+func TestRetriesExhaustedSkipped(t *testing.T) {
+	metrics, err := newConsumerMetrics(noopmetric.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := kafka.NewReader(kafka.ReaderConfig{Brokers: []string{"localhost:9092"}, Topic: "test"})
+	defer reader.Close()
+
+	var failures []MessageFailure
+	c := &Consumer{
+		reader:    reader,
+		tracer:    nooptrace.NewTracerProvider().Tracer("test"),
+		metrics:   metrics,
+		onFailure: func(f MessageFailure) { failures = append(failures, f) },
+	}
+	handler := func(context.Context, string, []byte) error { return errors.New("unavailable") }
+	cfg := normalizePoolConfig(config.KafkaConsumerConfig{MaxAttempts: 3, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond})
+
+	ctx := context.Background()
+	if !c.handleWithRetry(ctx, ctx, cfg, kafka.Message{Topic: "test", Offset: 7}, handler) {
+		t.Fatal("exhausted message left uncommitted")
+	}
+	want := []string{FailureRetrying, FailureRetrying, FailureSkipped}
+	if len(failures) != len(want) {
+		t.Fatalf("%d failed attempts, want %d", len(failures), len(want))
+	}
+	for i, f := range failures {
+		if f.Outcome != want[i] {
+			t.Errorf("attempt %d: %s, want %s", f.Attempt, f.Outcome, want[i])
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package observability
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultAzureIngestionEndpoint = "https://dc.services.visualstudio.com"
+	azureTrackPath                = "/v2.1/track"
+	azureExportTimeout            = 10 * time.Second
+)
+
+// AzureConnectionString holds the parts of an Application Insights
+// connection string the exporters need
+type AzureConnectionString struct {
+	InstrumentationKey string
+	IngestionEndpoint  string
+}
+
+// ParseAzureConnectionString parses the "Key1=Value1;Key2=Value2" format.
+// Without an IngestionEndpoint the endpoint is derived from EndpointSuffix,
+// or the global endpoint is used.
+func ParseAzureConnectionString(raw string) (*AzureConnectionString, error) {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(raw, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid connection string segment %q", pair)
+		}
+		values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+
+	cs := &AzureConnectionString{
+		InstrumentationKey: values["instrumentationkey"],
+		IngestionEndpoint:  values["ingestionendpoint"],
+	}
+	if cs.InstrumentationKey == "" {
+		return nil, fmt.Errorf("connection string has no InstrumentationKey")
+	}
+	if cs.IngestionEndpoint == "" {
+		cs.IngestionEndpoint = defaultAzureIngestionEndpoint
+		if suffix := values["endpointsuffix"]; suffix != "" {
+			cs.IngestionEndpoint = "https://dc." + strings.TrimPrefix(suffix, ".")
+		}
+	}
+	if !strings.HasPrefix(cs.IngestionEndpoint, "http://") && !strings.HasPrefix(cs.IngestionEndpoint, "https://") {
+		return nil, fmt.Errorf("invalid IngestionEndpoint %q", cs.IngestionEndpoint)
+	}
+	cs.IngestionEndpoint = strings.TrimSuffix(cs.IngestionEndpoint, "/")
+
+	return cs, nil
+}
+
+// azureClient posts telemetry items to the Application Insights track API
+type azureClient struct {
+	url        string
+	iKey       string
+	httpClient *http.Client
+}
+
+func newAzureClient(connectionString string) (*azureClient, error) {
+	cs, err := ParseAzureConnectionString(connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure Monitor connection string: %w", err)
+	}
+	return &azureClient{
+		url:        cs.IngestionEndpoint + azureTrackPath,
+		iKey:       cs.InstrumentationKey,
+		httpClient: &http.Client{Timeout: azureExportTimeout},
+	}, nil
+}
+
+type azureEnvelope struct {
+	Name string            `json:"name"`
+	Time string            `json:"time"`
+	IKey string            `json:"iKey"`
+	Tags map[string]string `json:"tags"`
+	Data azureData         `json:"data"`
+}
+
+type azureData struct {
+	BaseType string      `json:"baseType"`
+	BaseData interface{} `json:"baseData"`
+}
+
+type azureRequestData struct {
+	Ver          int               `json:"ver"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Duration     string            `json:"duration"`
+	ResponseCode string            `json:"responseCode"`
+	Success      bool              `json:"success"`
+	URL          string            `json:"url,omitempty"`
+	Properties   map[string]string `json:"properties,omitempty"`
+}
+
+type azureDependencyData struct {
+	Ver        int               `json:"ver"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Duration   string            `json:"duration"`
+	ResultCode string            `json:"resultCode"`
+	Success    bool              `json:"success"`
+	Type       string            `json:"type"`
+	Target     string            `json:"target,omitempty"`
+	Data       string            `json:"data,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type azureExceptionData struct {
+	Ver        int                     `json:"ver"`
+	Exceptions []azureExceptionDetails `json:"exceptions"`
+	Properties map[string]string       `json:"properties,omitempty"`
+}
+
+type azureExceptionDetails struct {
+	TypeName     string `json:"typeName"`
+	Message      string `json:"message"`
+	HasFullStack bool   `json:"hasFullStack"`
+	Stack        string `json:"stack,omitempty"`
+}
+
+type azureMetricData struct {
+	Ver        int                `json:"ver"`
+	Metrics    []azureMetricPoint `json:"metrics"`
+	Properties map[string]string  `json:"properties,omitempty"`
+}
+
+type azureMetricPoint struct {
+	Name  string   `json:"name"`
+	Value float64  `json:"value"`
+	Count *int     `json:"count,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+type azureTrackResponse struct {
+	ItemsReceived int `json:"itemsReceived"`
+	ItemsAccepted int `json:"itemsAccepted"`
+	Errors        []struct {
+		Index      int    `json:"index"`
+		StatusCode int    `json:"statusCode"`
+		Message    string `json:"message"`
+	} `json:"errors"`
+}
+
+func (c *azureClient) envelope(name string, t time.Time, tags map[string]string, baseType string, baseData interface{}) azureEnvelope {
+	return azureEnvelope{
+		Name: "Microsoft.ApplicationInsights." + name,
+		Time: t.UTC().Format(time.RFC3339Nano),
+		IKey: c.iKey,
+		Tags: tags,
+		Data: azureData{BaseType: baseType, BaseData: baseData},
+	}
+}
+
+// track sends envelopes as a gzipped JSON array. Items the service rejects
+// are reported as an error; they are not retried.
+func (c *azureClient) track(ctx context.Context, envelopes []azureEnvelope) error {
+	if len(envelopes) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(envelopes); err != nil {
+		return fmt.Errorf("failed to encode Azure Monitor items: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress Azure Monitor items: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create Azure Monitor request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Azure Monitor items: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result azureTrackResponse
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	_ = json.Unmarshal(raw, &result)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusPartialContent && len(result.Errors) > 0:
+		return fmt.Errorf("Azure Monitor accepted %d of %d items, first error: %s",
+			result.ItemsAccepted, result.ItemsReceived, result.Errors[0].Message)
+	default:
+		return fmt.Errorf("Azure Monitor returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+}
+
+// azureTags are the context tags shared by every item of a resource
+func azureTags(res *resource.Resource) map[string]string {
+	tags := map[string]string{
+		"ai.internal.sdkVersion": "otel-go:" + otel.Version(),
+	}
+	if v, ok := res.Set().Value(semconv.ServiceNameKey); ok {
+		tags["ai.cloud.role"] = v.Emit()
+	}
+	if v, ok := res.Set().Value(semconv.ServiceInstanceIDKey); ok {
+		tags["ai.cloud.roleInstance"] = v.Emit()
+	} else if v, ok := res.Set().Value(semconv.HostNameKey); ok {
+		tags["ai.cloud.roleInstance"] = v.Emit()
+	}
+	return tags
+}
+
+// azureTraceExporter exports server and consumer spans as requests, other
+// spans as dependencies and exception events as exceptions
+type azureTraceExporter struct {
+	client *azureClient
+}
+
+func newAzureTraceExporter(connectionString string) (sdktrace.SpanExporter, error) {
+	client, err := newAzureClient(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	return &azureTraceExporter{client: client}, nil
+}
+
+func (e *azureTraceExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	envelopes := make([]azureEnvelope, 0, len(spans))
+	for _, span := range spans {
+		envelopes = append(envelopes, e.spanEnvelopes(span)...)
+	}
+	return e.client.track(ctx, envelopes)
+}
+
+func (e *azureTraceExporter) spanEnvelopes(span sdktrace.ReadOnlySpan) []azureEnvelope {
+	sc := span.SpanContext()
+	tags := azureTags(span.Resource())
+	tags["ai.operation.id"] = sc.TraceID().String()
+	if parent := span.Parent(); parent.IsValid() {
+		tags["ai.operation.parentId"] = parent.SpanID().String()
+	}
+
+	attrs := span.Attributes()
+	properties := azureProperties(attrs)
+	duration := azureDuration(span.EndTime().Sub(span.StartTime()))
+	success := span.Status().Code != codes.Error
+	statusCode := attrValue(attrs, semconv.HTTPResponseStatusCodeKey, "http.status_code")
+
+	var envelopes []azureEnvelope
+	switch span.SpanKind() {
+	case trace.SpanKindServer, trace.SpanKindConsumer:
+		tags["ai.operation.name"] = span.Name()
+		if statusCode == "" {
+			statusCode = "0"
+		}
+		envelopes = append(envelopes, e.client.envelope("Request", span.StartTime(), tags, "RequestData", azureRequestData{
+			Ver:          2,
+			ID:           sc.SpanID().String(),
+			Name:         span.Name(),
+			Duration:     duration,
+			ResponseCode: statusCode,
+			Success:      success,
+			URL:          attrValue(attrs, semconv.URLFullKey, "http.url"),
+			Properties:   properties,
+		}))
+	default:
+		if statusCode == "" {
+			statusCode = "0"
+		}
+		envelopes = append(envelopes, e.client.envelope("RemoteDependency", span.StartTime(), tags, "RemoteDependencyData", azureDependencyData{
+			Ver:        2,
+			ID:         sc.SpanID().String(),
+			Name:       span.Name(),
+			Duration:   duration,
+			ResultCode: statusCode,
+			Success:    success,
+			Type:       dependencyType(span.SpanKind(), attrs),
+			Target:     attrValue(attrs, semconv.ServerAddressKey, "peer.service", "http.host"),
+			Data:       attrValue(attrs, semconv.URLFullKey, "http.url", semconv.DBQueryTextKey, "db.statement"),
+			Properties: properties,
+		}))
+	}
+
+	for _, event := range span.Events() {
+		if event.Name != semconv.ExceptionEventName {
+			continue
+		}
+		exceptionTags := make(map[string]string, len(tags))
+		for k, v := range tags {
+			exceptionTags[k] = v
+		}
+		exceptionTags["ai.operation.parentId"] = sc.SpanID().String()
+
+		stack := attrValue(event.Attributes, semconv.ExceptionStacktraceKey)
+		envelopes = append(envelopes, e.client.envelope("Exception", event.Time, exceptionTags, "ExceptionData", azureExceptionData{
+			Ver: 2,
+			Exceptions: []azureExceptionDetails{{
+				TypeName:     attrValue(event.Attributes, semconv.ExceptionTypeKey),
+				Message:      attrValue(event.Attributes, semconv.ExceptionMessageKey),
+				HasFullStack: stack != "",
+				Stack:        stack,
+			}},
+		}))
+	}
+
+	return envelopes
+}
+
+func (e *azureTraceExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// dependencyType names the dependency kind shown in Application Insights
+func dependencyType(kind trace.SpanKind, attrs []attribute.KeyValue) string {
+	switch {
+	case attrValue(attrs, semconv.HTTPRequestMethodKey, "http.method") != "":
+		return "HTTP"
+	case attrValue(attrs, semconv.DBSystemKey) != "":
+		return attrValue(attrs, semconv.DBSystemKey)
+	case attrValue(attrs, semconv.MessagingSystemKey) != "":
+		return attrValue(attrs, semconv.MessagingSystemKey)
+	case attrValue(attrs, semconv.RPCSystemKey) != "":
+		return attrValue(attrs, semconv.RPCSystemKey)
+	case kind == trace.SpanKindInternal:
+		return "InProc"
+	default:
+		return "Other"
+	}
+}
+
+// azureMetricExporter exports each data point as a metric item. Counters
+// and histograms use delta temporality, as Application Insights aggregates
+// per interval.
+type azureMetricExporter struct {
+	client      *azureClient
+	temporality sdkmetric.TemporalitySelector
+}
+
+func newAzureMetricExporter(connectionString string) (sdkmetric.Exporter, error) {
+	client, err := newAzureClient(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	temporality, err := temporalitySelector(TemporalityDelta)
+	if err != nil {
+		return nil, err
+	}
+	return &azureMetricExporter{client: client, temporality: temporality}, nil
+}
+
+func (e *azureMetricExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return e.temporality(kind)
+}
+
+func (e *azureMetricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *azureMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	tags := azureTags(rm.Resource)
+
+	var envelopes []azureEnvelope
+	add := func(t time.Time, attrs attribute.Set, point azureMetricPoint) {
+		envelopes = append(envelopes, e.client.envelope("Metric", t, tags, "MetricData", azureMetricData{
+			Ver:        2,
+			Metrics:    []azureMetricPoint{point},
+			Properties: azureProperties(attrs.ToSlice()),
+		}))
+	}
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Time, dp.Attributes, azureMetricPoint{Name: m.Name, Value: float64(dp.Value)})
+				}
+			case metricdata.Sum[float64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Time, dp.Attributes, azureMetricPoint{Name: m.Name, Value: dp.Value})
+				}
+			case metricdata.Gauge[int64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Time, dp.Attributes, azureMetricPoint{Name: m.Name, Value: float64(dp.Value)})
+				}
+			case metricdata.Gauge[float64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Time, dp.Attributes, azureMetricPoint{Name: m.Name, Value: dp.Value})
+				}
+			case metricdata.Histogram[int64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Time, dp.Attributes, histogramPoint(m.Name, float64(dp.Sum), dp.Count, dp.Min, dp.Max))
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Time, dp.Attributes, histogramPoint(m.Name, dp.Sum, dp.Count, dp.Min, dp.Max))
+				}
+			}
+		}
+	}
+
+	return e.client.track(ctx, envelopes)
+}
+
+func histogramPoint[N int64 | float64](name string, sum float64, count uint64, min, max metricdata.Extrema[N]) azureMetricPoint {
+	n := int(count)
+	point := azureMetricPoint{Name: name, Value: sum, Count: &n}
+	if v, ok := min.Value(); ok {
+		f := float64(v)
+		point.Min = &f
+	}
+	if v, ok := max.Value(); ok {
+		f := float64(v)
+		point.Max = &f
+	}
+	return point
+}
+
+func (e *azureMetricExporter) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+func (e *azureMetricExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// azureDuration formats d as the d.hh:mm:ss.ffffff timespan Application
+// Insights expects
+func azureDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	micros := d.Microseconds()
+	return fmt.Sprintf("%d.%02d:%02d:%02d.%06d",
+		micros/(24*3600*1e6),
+		micros/(3600*1e6)%24,
+		micros/(60*1e6)%60,
+		micros/1e6%60,
+		micros%1e6)
+}
+
+func azureProperties(attrs []attribute.KeyValue) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	properties := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		properties[string(attr.Key)] = attr.Value.Emit()
+	}
+	return properties
+}
+
+// attrValue returns the first of keys present in attrs
+func attrValue[K ~string](attrs []attribute.KeyValue, keys ...K) string {
+	for _, key := range keys {
+		for _, attr := range attrs {
+			if string(attr.Key) == string(key) {
+				return attr.Value.Emit()
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,318 @@
+package observability
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testInstrumentationKey = "00000000-0000-0000-0000-000000000001"
+
+var azureDurationPattern = regexp.MustCompile(`^\d+\.\d{2}:\d{2}:\d{2}\.\d{6}$`)
+
+// azureStandIn records the items posted to a local stand-in for the
+// Application Insights track API
+type azureStandIn struct {
+	t      *testing.T
+	mu     sync.Mutex
+	items  []map[string]interface{}
+	status int
+}
+
+// newAzureStandIn starts a stand-in for the duration of the test and
+// returns a connection string pointing at it
+func newAzureStandIn(t *testing.T) (*azureStandIn, string) {
+	standIn := &azureStandIn{t: t}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return standIn, "InstrumentationKey=" + testInstrumentationKey + ";IngestionEndpoint=" + server.URL + "/"
+}
+
+func azureTestResource() *resource.Resource {
+	return resource.NewSchemaless(
+		semconv.ServiceName("verify"),
+		semconv.ServiceInstanceID("verify-1"),
+	)
+}
+
+func (s *azureStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != http.MethodPost || r.URL.Path != azureTrackPath {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		s.t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		s.t.Error("request body is not gzipped")
+	}
+
+	var items []map[string]interface{}
+	gz, err := gzip.NewReader(r.Body)
+	if err == nil {
+		err = json.NewDecoder(gz).Decode(&items)
+	}
+	if err != nil {
+		s.t.Errorf("request body is not a gzipped JSON array: %v", err)
+	}
+	s.items = append(s.items, items...)
+
+	status := s.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"itemsReceived": len(items),
+		"itemsAccepted": len(items),
+		"errors":        []interface{}{},
+	})
+}
+
+func (s *azureStandIn) take() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.items
+	s.items = nil
+	return items
+}
+
+// TestAzureRejectedBatch expects a batch the track API rejects to surface
+// as an export error
+func TestAzureRejectedBatch(t *testing.T) {
+	standIn, connectionString := newAzureStandIn(t)
+	standIn.status = http.StatusBadRequest
+
+	exporter, err := newAzureTraceExporter(connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithResource(azureTestResource()))
+	_, span := tp.Tracer("verify").Start(context.Background(), "rejected")
+	span.End()
+	if err := exporter.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{span.(sdktrace.ReadOnlySpan)}); err == nil {
+		t.Error("a 400 from the track API was not reported")
+	}
+}
+
+// TestAzureConnectionStrings parses connection strings with an ingestion
+// endpoint, with an endpoint suffix and without an instrumentation key
+func TestAzureConnectionStrings(t *testing.T) {
+	cs, err := ParseAzureConnectionString("InstrumentationKey=abc;IngestionEndpoint=https://westeurope-5.in.applicationinsights.azure.com/;LiveEndpoint=https://westeurope.livediagnostics.monitor.azure.com/")
+	if err != nil {
+		t.Error(err)
+	} else if cs.InstrumentationKey != "abc" || cs.IngestionEndpoint != "https://westeurope-5.in.applicationinsights.azure.com" {
+		t.Errorf("connection string parsed as %+v", *cs)
+	}
+
+	cs, err = ParseAzureConnectionString("InstrumentationKey=abc;EndpointSuffix=ai.contoso.com")
+	if err != nil {
+		t.Error(err)
+	} else if cs.IngestionEndpoint != "https://dc.ai.contoso.com" {
+		t.Errorf("EndpointSuffix gave ingestion endpoint %s", cs.IngestionEndpoint)
+	}
+
+	if _, err := ParseAzureConnectionString("IngestionEndpoint=https://example.com"); err == nil {
+		t.Error("connection string without InstrumentationKey was accepted")
+	}
+}
+
+// TestAzureSpans exports a server span and a failed client span and checks
+// the request, dependency and exception items sent for them
+func TestAzureSpans(t *testing.T) {
+	standIn, connectionString := newAzureStandIn(t)
+	exporter, err := newAzureTraceExporter(connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter), sdktrace.WithResource(azureTestResource()))
+	tracer := tp.Tracer("verify")
+
+	ctx, server := tracer.Start(context.Background(), "POST /v1/payments",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", "POST"),
+			attribute.String("http.url", "/v1/payments"),
+			attribute.Int("http.status_code", 201),
+		),
+	)
+	_, client := tracer.Start(ctx, "POST mtnpay",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodPost,
+			semconv.URLFull("https://mtnpay.example.com/payments"),
+			semconv.ServerAddress("mtnpay.example.com"),
+			semconv.HTTPResponseStatusCode(503),
+		),
+	)
+	client.RecordError(errors.New("service unavailable"))
+	client.SetStatus(codes.Error, "service unavailable")
+	client.End()
+	server.End()
+
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("span export failed: %v", err)
+	}
+
+	traceID := server.SpanContext().TraceID().String()
+	serverID := server.SpanContext().SpanID().String()
+	clientID := client.SpanContext().SpanID().String()
+
+	items := standIn.take()
+	byType := make(map[string]map[string]interface{})
+	for _, item := range items {
+		byType[str(item, "data", "baseType")] = item
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected a request, a dependency and an exception, got %d items", len(items))
+	}
+
+	expect := func(item map[string]interface{}, want interface{}, path ...string) {
+		if got := lookup(item, path...); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s %v: got %v, want %v", str(item, "data", "baseType"), path, got, want)
+		}
+	}
+
+	for baseType, item := range byType {
+		expect(item, testInstrumentationKey, "iKey")
+		expect(item, "verify", "tags", "ai.cloud.role")
+		expect(item, "verify-1", "tags", "ai.cloud.roleInstance")
+		expect(item, traceID, "tags", "ai.operation.id")
+		if _, ok := lookup(item, "time").(string); !ok {
+			t.Errorf("%s has no time", baseType)
+		}
+	}
+
+	request := byType["RequestData"]
+	expect(request, "Microsoft.ApplicationInsights.Request", "name")
+	expect(request, 2, "data", "baseData", "ver")
+	expect(request, serverID, "data", "baseData", "id")
+	expect(request, "POST /v1/payments", "data", "baseData", "name")
+	expect(request, "201", "data", "baseData", "responseCode")
+	expect(request, true, "data", "baseData", "success")
+	expect(request, "/v1/payments", "data", "baseData", "url")
+	expect(request, "POST /v1/payments", "tags", "ai.operation.name")
+	if d := str(request, "data", "baseData", "duration"); !azureDurationPattern.MatchString(d) {
+		t.Errorf("request duration %q is not a timespan", d)
+	}
+
+	dependency := byType["RemoteDependencyData"]
+	expect(dependency, "Microsoft.ApplicationInsights.RemoteDependency", "name")
+	expect(dependency, clientID, "data", "baseData", "id")
+	expect(dependency, serverID, "tags", "ai.operation.parentId")
+	expect(dependency, "HTTP", "data", "baseData", "type")
+	expect(dependency, "mtnpay.example.com", "data", "baseData", "target")
+	expect(dependency, "https://mtnpay.example.com/payments", "data", "baseData", "data")
+	expect(dependency, "503", "data", "baseData", "resultCode")
+	expect(dependency, false, "data", "baseData", "success")
+	expect(dependency, "POST", "data", "baseData", "properties", "http.request.method")
+
+	exception := byType["ExceptionData"]
+	expect(exception, "Microsoft.ApplicationInsights.Exception", "name")
+	expect(exception, clientID, "tags", "ai.operation.parentId")
+	exceptions, _ := lookup(exception, "data", "baseData", "exceptions").([]interface{})
+	if len(exceptions) != 1 {
+		t.Errorf("expected one exception detail, got %d", len(exceptions))
+	} else if details, _ := exceptions[0].(map[string]interface{}); details["message"] != "service unavailable" || details["typeName"] == "" {
+		t.Errorf("unexpected exception details %v", details)
+	}
+}
+
+// TestAzureMetrics exports a counter and a histogram and checks the metric
+// items sent for them
+func TestAzureMetrics(t *testing.T) {
+	standIn, connectionString := newAzureStandIn(t)
+	exporter, err := newAzureMetricExporter(connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(azureTestResource()),
+	)
+	meter := mp.Meter("verify")
+
+	counter, _ := meter.Int64Counter("payments_total")
+	histogram, _ := meter.Float64Histogram("payment_duration_seconds")
+	route := metric.WithAttributes(attribute.String("provider", "mtnpay"))
+	counter.Add(context.Background(), 3, route)
+	histogram.Record(context.Background(), 1, route)
+	histogram.Record(context.Background(), 3, route)
+
+	if err := mp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("metric export failed: %v", err)
+	}
+
+	points := make(map[string]map[string]interface{})
+	for _, item := range standIn.take() {
+		if item["name"] != "Microsoft.ApplicationInsights.Metric" || str(item, "data", "baseType") != "MetricData" {
+			t.Errorf("unexpected metric item %v", item["name"])
+			continue
+		}
+		if item["iKey"] != testInstrumentationKey || str(item, "tags", "ai.cloud.role") != "verify" {
+			t.Errorf("metric item has iKey %v and role %v", item["iKey"], str(item, "tags", "ai.cloud.role"))
+		}
+		if str(item, "data", "baseData", "properties", "provider") != "mtnpay" {
+			t.Error("metric item lost its attributes")
+		}
+		metrics, _ := lookup(item, "data", "baseData", "metrics").([]interface{})
+		for _, m := range metrics {
+			if point, ok := m.(map[string]interface{}); ok {
+				points[fmt.Sprint(point["name"])] = point
+			}
+		}
+	}
+
+	want := map[string]map[string]interface{}{
+		"payments_total":           {"value": 3.0},
+		"payment_duration_seconds": {"value": 4.0, "count": 2.0, "min": 1.0, "max": 3.0},
+	}
+	for name, fields := range want {
+		point, ok := points[name]
+		if !ok {
+			t.Errorf("metric %s was not exported", name)
+			continue
+		}
+		for field, value := range fields {
+			if point[field] != value {
+				t.Errorf("metric %s %s: got %v, want %v", name, field, point[field], value)
+			}
+		}
+	}
+}
+
+func lookup(item map[string]interface{}, path ...string) interface{} {
+	var current interface{} = item
+	for _, key := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[key]
+	}
+	return current
+}
+
+func str(item map[string]interface{}, path ...string) string {
+	s, _ := lookup(item, path...).(string)
+	return s
+}
@@ -27,7 +27,9 @@ func NewLogger(level string) (*Logger, error) {
 	config.EncoderConfig.TimeKey = "timestamp"
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
-	logger, err := config.Build()
+	logger, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return contextlessCore{core}
+	}))
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +37,37 @@ func NewLogger(level string) (*Logger, error) {
 	return &Logger{Logger: logger}, nil
 }
 
+// contextlessCore leaves the "ctx" field, which carries the span context
+// to the OTel bridge, out of the JSON output
+type contextlessCore struct {
+	zapcore.Core
+}
+
+func (c contextlessCore) With(fields []zapcore.Field) zapcore.Core {
+	return contextlessCore{c.Core.With(withoutContext(fields))}
+}
+
+func (c contextlessCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c contextlessCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, withoutContext(fields))
+}
+
+func withoutContext(fields []zapcore.Field) []zapcore.Field {
+	kept := fields[:0:0]
+	for _, f := range fields {
+		if _, ok := f.Interface.(context.Context); !ok {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
 // WithTrace adds trace and span IDs to the logger
 func (l *Logger) WithTrace(ctx context.Context) *zap.Logger {
 	span := trace.SpanFromContext(ctx)
@@ -46,6 +79,7 @@ func (l *Logger) WithTrace(ctx context.Context) *zap.Logger {
 	return l.Logger.With(
 		zap.String("trace_id", spanCtx.TraceID().String()),
 		zap.String("span_id", spanCtx.SpanID().String()),
+		zap.Any("ctx", ctx),
 	)
 }
 
@@ -60,5 +94,6 @@ func WithTraceFields(ctx context.Context) []zap.Field {
 	return []zap.Field{
 		zap.String("trace_id", spanCtx.TraceID().String()),
 		zap.String("span_id", spanCtx.SpanID().String()),
+		zap.Any("ctx", ctx),
 	}
 }
@@ -0,0 +1,123 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/bridges/otelzap"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// Log exporters, as in OTEL_LOGS_EXPORTER
+const (
+	LogsExporterOTLP = "otlp"
+	LogsExporterNone = "none"
+)
+
+const defaultLogsInterval = time.Second
+
+// newLogBatchProcessor batches records in a bounded queue in front of the
+// OTLP exporter. Emitting never blocks: the oldest records are dropped
+// when the queue is full.
+func newLogBatchProcessor(ctx context.Context, otlp *config.OTLPConfig, cfg *config.LogsConfig) (*sdklog.BatchProcessor, error) {
+	s, err := resolveOTLP(otlp, otlp.LogsEndpoint, "/v1/logs")
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := parseMillisOrDuration(cfg.Interval, defaultLogsInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logs export interval: %w", err)
+	}
+
+	var exporter sdklog.Exporter
+	if s.protocol == OTLPProtocolGRPC {
+		opts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(s.host),
+			otlploggrpc.WithHeaders(s.headers),
+			otlploggrpc.WithTimeout(s.timeout),
+			otlploggrpc.WithRetry(otlploggrpc.RetryConfig(s.retryConfig())),
+		}
+		if s.insecure {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		} else {
+			opts = append(opts, otlploggrpc.WithTLSCredentials(credentials.NewTLS(s.tlsConfig)))
+		}
+		if s.compression {
+			opts = append(opts, otlploggrpc.WithCompressor("gzip"))
+		}
+		exporter, err = otlploggrpc.New(ctx, opts...)
+	} else {
+		opts := []otlploghttp.Option{
+			otlploghttp.WithEndpoint(s.host),
+			otlploghttp.WithURLPath(s.path),
+			otlploghttp.WithHeaders(s.headers),
+			otlploghttp.WithTimeout(s.timeout),
+			otlploghttp.WithRetry(otlploghttp.RetryConfig(s.retryConfig())),
+		}
+		if s.insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		} else {
+			opts = append(opts, otlploghttp.WithTLSClientConfig(s.tlsConfig))
+		}
+		if s.compression {
+			opts = append(opts, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+		} else {
+			opts = append(opts, otlploghttp.WithCompression(otlploghttp.NoCompression))
+		}
+		exporter, err = otlploghttp.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP logs exporter: %w", err)
+	}
+
+	opts := []sdklog.BatchProcessorOption{
+		sdklog.WithExportInterval(interval),
+		sdklog.WithExportTimeout(s.timeout),
+	}
+	if cfg.QueueSize > 0 {
+		opts = append(opts, sdklog.WithMaxQueueSize(cfg.QueueSize))
+	}
+	if cfg.BatchSize > 0 {
+		opts = append(opts, sdklog.WithExportMaxBatchSize(cfg.BatchSize))
+	}
+	return sdklog.NewBatchProcessor(exporter, opts...), nil
+}
+
+// BridgeLogger tees l into the logs pipeline, keeping its JSON output. It
+// does nothing when no log exporter is configured.
+func (tm *TelemetryManager) BridgeLogger(l *Logger) {
+	if tm.loggerProvider == nil {
+		return
+	}
+	bridge := otelzap.NewCore("github.com/webbies/otel-fiber-demo", otelzap.WithLoggerProvider(tm.loggerProvider))
+	l.Logger = l.Logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		// The bridge emits every level the SDK accepts; hold it to l's level
+		leveled, err := zapcore.NewIncreaseLevelCore(bridge, core)
+		if err != nil {
+			return core
+		}
+		return zapcore.NewTee(core, leveled)
+	}))
+}
+
+// logsExporter validates the OTEL_LOGS_EXPORTER value
+func logsExporter(raw string) (string, error) {
+	switch name := strings.ToLower(strings.TrimSpace(raw)); name {
+	case "", LogsExporterNone:
+		return LogsExporterNone, nil
+	case LogsExporterOTLP:
+		return name, nil
+	default:
+		return "", fmt.Errorf("unsupported logs exporter: %s", raw)
+	}
+}
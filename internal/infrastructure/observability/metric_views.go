@@ -0,0 +1,84 @@
+package observability
+
+import (
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// Exemplar filters, as in OTEL_METRICS_EXEMPLAR_FILTER
+const (
+	ExemplarFilterTraceBased = "trace_based"
+	ExemplarFilterAlwaysOn   = "always_on"
+	ExemplarFilterAlwaysOff  = "always_off"
+)
+
+const (
+	defaultExponentialMaxSize  = 160
+	defaultExponentialMaxScale = 20
+)
+
+// exemplarFilter selects the measurements offered as exemplars. With
+// trace_based only measurements made inside a sampled span are, so every
+// exemplar links to a trace that was exported.
+func exemplarFilter(name string) (exemplar.Filter, error) {
+	switch strings.ToLower(name) {
+	case "", ExemplarFilterTraceBased:
+		return exemplar.TraceBasedFilter, nil
+	case ExemplarFilterAlwaysOn:
+		return exemplar.AlwaysOnFilter, nil
+	case ExemplarFilterAlwaysOff:
+		return exemplar.AlwaysOffFilter, nil
+	default:
+		return nil, fmt.Errorf("unsupported exemplar filter: %s", name)
+	}
+}
+
+// metricViews builds a view per configured instrument pattern
+func metricViews(cfgs []config.MetricViewConfig) ([]sdkmetric.View, error) {
+	views := make([]sdkmetric.View, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg.Instrument == "" {
+			return nil, fmt.Errorf("metric view without an instrument")
+		}
+
+		stream := sdkmetric.Stream{}
+
+		switch {
+		case cfg.Exponential && len(cfg.Buckets) > 0:
+			return nil, fmt.Errorf("metric view for %s: buckets and exponential are exclusive", cfg.Instrument)
+		case cfg.Exponential:
+			maxSize := cfg.MaxSize
+			if maxSize <= 0 {
+				maxSize = defaultExponentialMaxSize
+			}
+			stream.Aggregation = sdkmetric.AggregationBase2ExponentialHistogram{
+				MaxSize:  maxSize,
+				MaxScale: defaultExponentialMaxScale,
+			}
+		case len(cfg.Buckets) > 0:
+			for i := 1; i < len(cfg.Buckets); i++ {
+				if cfg.Buckets[i] <= cfg.Buckets[i-1] {
+					return nil, fmt.Errorf("metric view for %s: buckets must be increasing", cfg.Instrument)
+				}
+			}
+			stream.Aggregation = sdkmetric.AggregationExplicitBucketHistogram{Boundaries: cfg.Buckets}
+		}
+
+		if len(cfg.DropAttributes) > 0 {
+			keys := make([]attribute.Key, 0, len(cfg.DropAttributes))
+			for _, key := range cfg.DropAttributes {
+				keys = append(keys, attribute.Key(key))
+			}
+			stream.AttributeFilter = attribute.NewDenyKeysFilter(keys...)
+		}
+
+		views = append(views, sdkmetric.NewView(sdkmetric.Instrument{Name: cfg.Instrument}, stream))
+	}
+	return views, nil
+}
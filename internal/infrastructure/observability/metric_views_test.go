@@ -0,0 +1,59 @@
+package observability
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// TestMetricViewDropAttributes records a measurement with a listed attribute
+// and expects it to be gone from the exported stream, with the rest kept
+func TestMetricViewDropAttributes(t *testing.T) {
+	views, err := metricViews([]config.MetricViewConfig{{
+		Instrument:     "test_duration_seconds",
+		Buckets:        []float64{0.1, 1},
+		DropAttributes: []string{"user_id"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithView(views...))
+	histogram, err := provider.Meter("test").Float64Histogram("test_duration_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, user := range []string{"user-1", "user-2"} {
+		histogram.Record(ctx, 0.5, metric.WithAttributes(
+			attribute.String("route", "/v1/payments"),
+			attribute.String("user_id", user),
+		))
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	points := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64]).DataPoints
+	if len(points) != 1 {
+		t.Fatalf("%d streams, want the two users merged into 1", len(points))
+	}
+	if _, ok := points[0].Attributes.Value("user_id"); ok {
+		t.Error("user_id was exported")
+	}
+	if route, _ := points[0].Attributes.Value("route"); route.AsString() != "/v1/payments" {
+		t.Errorf("route = %q, want /v1/payments", route.AsString())
+	}
+	if points[0].Count != 2 {
+		t.Errorf("count = %d, want 2", points[0].Count)
+	}
+}
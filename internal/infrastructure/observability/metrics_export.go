@@ -0,0 +1,239 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// Metric exporters, as in OTEL_METRICS_EXPORTER
+const (
+	MetricsExporterPrometheus = "prometheus"
+	MetricsExporterOTLP       = "otlp"
+)
+
+// Temporality preferences, as in
+// OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE
+const (
+	TemporalityCumulative = "cumulative"
+	TemporalityDelta      = "delta"
+	TemporalityLowMemory  = "lowmemory"
+)
+
+// metricsExporters parses the comma-separated exporter list
+func metricsExporters(raw string) (map[string]bool, error) {
+	exporters := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", "none":
+		case MetricsExporterPrometheus, MetricsExporterOTLP:
+			exporters[name] = true
+		default:
+			return nil, fmt.Errorf("unsupported metrics exporter: %s", name)
+		}
+	}
+	return exporters, nil
+}
+
+// instrumentFilter matches instrument names against glob patterns. No
+// patterns match every instrument.
+type instrumentFilter []string
+
+func newInstrumentFilter(patterns []string) (instrumentFilter, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid instrument pattern %q: %w", pattern, err)
+		}
+	}
+	return instrumentFilter(patterns), nil
+}
+
+func (f instrumentFilter) match(name string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, pattern := range f {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newOTLPMetricReader builds a periodic reader pushing the instruments
+// selected by cfg.OTLPInstruments through the OTLP exporter
+func newOTLPMetricReader(ctx context.Context, otlp *config.OTLPConfig, cfg *config.MetricsConfig) (sdkmetric.Reader, error) {
+	s, err := resolveOTLP(otlp, otlp.MetricsEndpoint, "/v1/metrics")
+	if err != nil {
+		return nil, err
+	}
+
+	temporality, err := temporalitySelector(cfg.Temporality)
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := parseMillisOrDuration(cfg.Interval, defaultMetricsInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics export interval: %w", err)
+	}
+
+	filter, err := newInstrumentFilter(cfg.OTLPInstruments)
+	if err != nil {
+		return nil, err
+	}
+
+	var exporter sdkmetric.Exporter
+	if s.protocol == OTLPProtocolGRPC {
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(s.host),
+			otlpmetricgrpc.WithHeaders(s.headers),
+			otlpmetricgrpc.WithTimeout(s.timeout),
+			otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig(s.retryConfig())),
+			otlpmetricgrpc.WithTemporalitySelector(temporality),
+		}
+		if s.insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		} else {
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(s.tlsConfig)))
+		}
+		if s.compression {
+			opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, opts...)
+	} else {
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(s.host),
+			otlpmetrichttp.WithURLPath(s.path),
+			otlpmetrichttp.WithHeaders(s.headers),
+			otlpmetrichttp.WithTimeout(s.timeout),
+			otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig(s.retryConfig())),
+			otlpmetrichttp.WithTemporalitySelector(temporality),
+		}
+		if s.insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		} else {
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(s.tlsConfig))
+		}
+		if s.compression {
+			opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+		} else {
+			opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.NoCompression))
+		}
+		exporter, err = otlpmetrichttp.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP metrics exporter: %w", err)
+	}
+
+	if len(filter) > 0 {
+		exporter = &filteringExporter{Exporter: exporter, filter: filter}
+	}
+
+	return sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(interval),
+		sdkmetric.WithTimeout(s.timeout),
+	), nil
+}
+
+// temporalitySelector implements the OTLP temporality preferences: delta
+// uses delta for counters and histograms but keeps up-down counters
+// cumulative, and lowmemory only uses delta for synchronous instruments
+func temporalitySelector(preference string) (sdkmetric.TemporalitySelector, error) {
+	switch strings.ToLower(preference) {
+	case "", TemporalityCumulative:
+		return sdkmetric.DefaultTemporalitySelector, nil
+	case TemporalityDelta:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindUpDownCounter, sdkmetric.InstrumentKindObservableUpDownCounter:
+				return metricdata.CumulativeTemporality
+			default:
+				return metricdata.DeltaTemporality
+			}
+		}, nil
+	case TemporalityLowMemory:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			default:
+				return metricdata.CumulativeTemporality
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported metrics temporality: %s", preference)
+	}
+}
+
+// filteringExporter drops the instruments that are not routed to it
+type filteringExporter struct {
+	sdkmetric.Exporter
+	filter instrumentFilter
+}
+
+func (e *filteringExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	filtered := metricdata.ResourceMetrics{
+		Resource:     rm.Resource,
+		ScopeMetrics: make([]metricdata.ScopeMetrics, 0, len(rm.ScopeMetrics)),
+	}
+	for _, sm := range rm.ScopeMetrics {
+		metrics := make([]metricdata.Metrics, 0, len(sm.Metrics))
+		for _, m := range sm.Metrics {
+			if e.filter.match(m.Name) {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			filtered.ScopeMetrics = append(filtered.ScopeMetrics, metricdata.ScopeMetrics{Scope: sm.Scope, Metrics: metrics})
+		}
+	}
+	return e.Exporter.Export(ctx, &filtered)
+}
+
+// filteringGatherer serves the Prometheus families of the instruments routed
+// to the Prometheus reader. Families are matched with and without the
+// "_total" suffix the exporter adds to counters.
+type filteringGatherer struct {
+	gatherer promclient.Gatherer
+	filter   instrumentFilter
+}
+
+func (g *filteringGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	if len(g.filter) == 0 {
+		return families, err
+	}
+
+	filtered := families[:0]
+	for _, family := range families {
+		name := family.GetName()
+		if name == "target_info" || g.filter.match(name) || g.filter.match(strings.TrimSuffix(name, "_total")) {
+			filtered = append(filtered, family)
+		}
+	}
+	return filtered, err
+}
+
+// MetricsHandler serves the Prometheus reader's metrics. Exemplars are only
+// exposed to scrapers that negotiate the OpenMetrics format.
+func (tm *TelemetryManager) MetricsHandler() http.Handler {
+	if tm.promGatherer == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(tm.promGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
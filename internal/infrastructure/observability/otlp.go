@@ -0,0 +1,248 @@
+package observability
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// OTLP transport protocols, as in OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	OTLPProtocolGRPC         = "grpc"
+	OTLPProtocolHTTPProtobuf = "http/protobuf"
+)
+
+const (
+	defaultOTLPTimeout     = 10 * time.Second
+	defaultMetricsInterval = time.Minute
+)
+
+// otlpSettings is the resolved form of an OTLPConfig for one signal
+type otlpSettings struct {
+	protocol    string
+	host        string
+	path        string
+	insecure    bool
+	tlsConfig   *tls.Config
+	headers     map[string]string
+	compression bool
+	timeout     time.Duration
+	retry       config.OTLPRetryConfig
+}
+
+// resolveOTLP resolves cfg for one signal. signalEndpoint, when set, is used
+// as-is; otherwise signalPath ("/v1/traces") is appended to the base
+// endpoint for http/protobuf, as the OTLP exporter spec requires.
+func resolveOTLP(cfg *config.OTLPConfig, signalEndpoint, signalPath string) (*otlpSettings, error) {
+	s := &otlpSettings{
+		protocol:    strings.ToLower(cfg.Protocol),
+		insecure:    cfg.Insecure,
+		compression: strings.EqualFold(cfg.Compression, "gzip"),
+		retry:       cfg.Retry,
+	}
+	if s.protocol == "" {
+		s.protocol = OTLPProtocolHTTPProtobuf
+	}
+	if s.protocol != OTLPProtocolGRPC && s.protocol != OTLPProtocolHTTPProtobuf {
+		return nil, fmt.Errorf("unsupported OTLP protocol: %s", cfg.Protocol)
+	}
+	if c := strings.ToLower(cfg.Compression); c != "" && c != "gzip" && c != "none" {
+		return nil, fmt.Errorf("unsupported OTLP compression: %s", cfg.Compression)
+	}
+
+	endpoint, exact := cfg.Endpoint, false
+	if signalEndpoint != "" {
+		endpoint, exact = signalEndpoint, true
+	}
+	if err := s.parseEndpoint(endpoint, signalPath, exact); err != nil {
+		return nil, err
+	}
+
+	headers, err := parseOTLPHeaders(cfg.Headers)
+	if err != nil {
+		return nil, err
+	}
+	s.headers = headers
+
+	if s.timeout, err = parseMillisOrDuration(cfg.Timeout, defaultOTLPTimeout); err != nil {
+		return nil, fmt.Errorf("invalid OTLP timeout: %w", err)
+	}
+
+	if !s.insecure {
+		if s.tlsConfig, err = otlpTLSConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// parseEndpoint accepts a URL or, for gRPC, a bare host:port. The URL
+// scheme decides between plaintext (http) and TLS (https).
+func (s *otlpSettings) parseEndpoint(endpoint, signalPath string, exact bool) error {
+	if !strings.Contains(endpoint, "://") {
+		s.host = endpoint
+		s.path = signalPath
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP endpoint %q: %w", endpoint, err)
+	}
+
+	switch u.Scheme {
+	case "http":
+		s.insecure = true
+	case "https":
+		s.insecure = false
+	default:
+		return fmt.Errorf("invalid OTLP endpoint %q: scheme must be http or https", endpoint)
+	}
+
+	s.host = u.Host
+	if exact {
+		s.path = u.Path
+	} else {
+		s.path = strings.TrimSuffix(u.Path, "/") + signalPath
+	}
+	return nil
+}
+
+// parseOTLPHeaders parses the "key1=value1,key2=value2" format of
+// OTEL_EXPORTER_OTLP_HEADERS, with URL-encoded values
+func parseOTLPHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q", pair)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP header %q: %w", pair, err)
+		}
+		headers[strings.TrimSpace(key)] = decoded
+	}
+	return headers, nil
+}
+
+// parseMillisOrDuration accepts a Go duration or, as the OTEL_* timeout and
+// interval variables do, a number of milliseconds
+func parseMillisOrDuration(raw string, fallback time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+	if ms, err := strconv.Atoi(raw); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither a duration nor milliseconds", raw)
+	}
+	return d, nil
+}
+
+func otlpTLSConfig(cfg *config.OTLPConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.Certificate != "" {
+		pem, err := os.ReadFile(cfg.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OTLP certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.Certificate)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCertificate != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertificate, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OTLP client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func newOTLPTraceExporter(ctx context.Context, cfg *config.OTLPConfig) (sdktrace.SpanExporter, error) {
+	s, err := resolveOTLP(cfg, cfg.TracesEndpoint, "/v1/traces")
+	if err != nil {
+		return nil, err
+	}
+
+	if s.protocol == OTLPProtocolGRPC {
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(s.host),
+			otlptracegrpc.WithHeaders(s.headers),
+			otlptracegrpc.WithTimeout(s.timeout),
+			otlptracegrpc.WithRetry(otlptracegrpc.RetryConfig(s.retryConfig())),
+		}
+		if s.insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		} else {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(s.tlsConfig)))
+		}
+		if s.compression {
+			opts = append(opts, otlptracegrpc.WithCompressor("gzip"))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(s.host),
+		otlptracehttp.WithURLPath(s.path),
+		otlptracehttp.WithHeaders(s.headers),
+		otlptracehttp.WithTimeout(s.timeout),
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig(s.retryConfig())),
+	}
+	if s.insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	} else {
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(s.tlsConfig))
+	}
+	if s.compression {
+		opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+	} else {
+		opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.NoCompression))
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// otlpRetryConfig mirrors the RetryConfig struct shared by the OTLP
+// exporters, which each re-declare it
+type otlpRetryConfig struct {
+	Enabled         bool
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+}
+
+func (s *otlpSettings) retryConfig() otlpRetryConfig {
+	return otlpRetryConfig{
+		Enabled:         s.retry.Enabled,
+		InitialInterval: s.retry.InitialInterval,
+		MaxInterval:     s.retry.MaxInterval,
+		MaxElapsedTime:  s.retry.MaxElapsedTime,
+	}
+}
@@ -0,0 +1,87 @@
+package observability
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// kubernetesEnv maps the environment variables a pod spec sets from the
+// downward API to resource attributes
+var kubernetesEnv = []struct {
+	env string
+	key attribute.Key
+}{
+	{"K8S_CLUSTER_NAME", semconv.K8SClusterNameKey},
+	{"K8S_NODE_NAME", semconv.K8SNodeNameKey},
+	{"K8S_NAMESPACE_NAME", semconv.K8SNamespaceNameKey},
+	{"K8S_POD_NAME", semconv.K8SPodNameKey},
+	{"K8S_POD_UID", semconv.K8SPodUIDKey},
+	{"K8S_CONTAINER_NAME", semconv.K8SContainerNameKey},
+	{"K8S_DEPLOYMENT_NAME", semconv.K8SDeploymentNameKey},
+}
+
+// newResource builds the resource shared by traces, metrics and logs.
+// Attributes from OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME are
+// applied last and win. A detector that fails leaves its attributes out
+// rather than failing startup.
+func newResource(ctx context.Context, cfg *config.TelemetryConfig, environment string) (*resource.Resource, error) {
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithOS(),
+		// The command line is left out, it may carry credentials
+		resource.WithProcessPID(),
+		resource.WithProcessExecutableName(),
+		resource.WithProcessExecutablePath(),
+		resource.WithProcessOwner(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithProcessRuntimeDescription(),
+		resource.WithContainer(),
+		resource.WithDetectors(kubernetesDetector{}),
+		resource.WithAttributes(
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(cfg.ServiceVersion),
+			semconv.ServiceInstanceID(serviceInstanceID()),
+			semconv.DeploymentEnvironment(environment),
+		),
+		resource.WithFromEnv(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, err
+	}
+	return res, nil
+}
+
+// serviceInstanceID is the pod UID when running in Kubernetes and a random
+// id otherwise
+func serviceInstanceID() string {
+	if uid := os.Getenv("K8S_POD_UID"); uid != "" {
+		return uid
+	}
+	return uuid.NewString()
+}
+
+// kubernetesDetector reads the pod metadata exposed through the downward API
+type kubernetesDetector struct{}
+
+func (kubernetesDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	var attrs []attribute.KeyValue
+	for _, e := range kubernetesEnv {
+		if value := os.Getenv(e.env); value != "" {
+			attrs = append(attrs, e.key.String(value))
+		}
+	}
+	if len(attrs) == 0 {
+		return resource.Empty(), nil
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
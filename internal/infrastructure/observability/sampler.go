@@ -0,0 +1,247 @@
+package observability
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+)
+
+// Sampler names, matching the values of OTEL_TRACES_SAMPLER where one exists
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+	SamplerRateLimited             = "ratelimited"
+	SamplerParentBasedRateLimited  = "parentbased_ratelimited"
+)
+
+// RouteAttribute is the span attribute that sampling rules match against
+const RouteAttribute = attribute.Key("url.path")
+
+// RuleSampler applies the per-route sampling rules and falls back to the
+// configured sampler for everything else. Its configuration can be swapped
+// at runtime with Update.
+type RuleSampler struct {
+	state atomic.Pointer[samplerState]
+}
+
+type samplerState struct {
+	rules       []samplingRule
+	fallback    sdktrace.Sampler
+	description string
+}
+
+type samplingRule struct {
+	route   string
+	sampler sdktrace.Sampler
+}
+
+func NewRuleSampler(cfg *config.SamplingConfig) (*RuleSampler, error) {
+	s := &RuleSampler{}
+	if err := s.Update(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update replaces the rules and fallback sampler. The previous
+// configuration stays in place when cfg is invalid.
+func (s *RuleSampler) Update(cfg *config.SamplingConfig) error {
+	fallback, err := newSampler(cfg.Sampler, cfg.Arg)
+	if err != nil {
+		return err
+	}
+
+	rules := make([]samplingRule, 0, len(cfg.Rules))
+	descriptions := make([]string, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		if rule.Route == "" {
+			return fmt.Errorf("sampling rule without a route")
+		}
+		if rule.Ratio < 0 || rule.Ratio > 1 {
+			return fmt.Errorf("sampling rule for %s: ratio %v is not between 0 and 1", rule.Route, rule.Ratio)
+		}
+		rules = append(rules, samplingRule{route: rule.Route, sampler: ratioSampler(rule.Ratio)})
+		descriptions = append(descriptions, fmt.Sprintf("%s=%v", rule.Route, rule.Ratio))
+	}
+
+	// The longest matching route wins
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].route) > len(rules[j].route)
+	})
+
+	s.state.Store(&samplerState{
+		rules:    rules,
+		fallback: fallback,
+		description: fmt.Sprintf("RuleSampler{rules=[%s],fallback=%s}",
+			strings.Join(descriptions, ","), fallback.Description()),
+	})
+
+	return nil
+}
+
+func (s *RuleSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	state := s.state.Load()
+
+	for _, attr := range p.Attributes {
+		if attr.Key != RouteAttribute {
+			continue
+		}
+		path := attr.Value.AsString()
+		for _, rule := range state.rules {
+			if matchRoute(path, rule.route) {
+				return rule.sampler.ShouldSample(p)
+			}
+		}
+		break
+	}
+
+	return state.fallback.ShouldSample(p)
+}
+
+func (s *RuleSampler) Description() string {
+	return s.state.Load().description
+}
+
+// matchRoute reports whether path is route or below it
+func matchRoute(path, route string) bool {
+	if !strings.HasPrefix(path, route) {
+		return false
+	}
+	return len(path) == len(route) || strings.HasSuffix(route, "/") || path[len(route)] == '/'
+}
+
+func ratioSampler(ratio float64) sdktrace.Sampler {
+	switch ratio {
+	case 0:
+		return sdktrace.NeverSample()
+	case 1:
+		return sdktrace.AlwaysSample()
+	default:
+		return sdktrace.TraceIDRatioBased(ratio)
+	}
+}
+
+// newSampler builds the sampler named by OTEL_TRACES_SAMPLER semantics
+func newSampler(name, arg string) (sdktrace.Sampler, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case SamplerAlwaysOn:
+		return sdktrace.AlwaysSample(), nil
+	case SamplerAlwaysOff:
+		return sdktrace.NeverSample(), nil
+	case SamplerParentBasedAlwaysOn, "":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case SamplerParentBasedAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case SamplerTraceIDRatio, SamplerParentBasedTraceIDRatio:
+		ratio, err := parseSamplerArg(arg, 1)
+		if err != nil {
+			return nil, err
+		}
+		if ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("sampler ratio %v is not between 0 and 1", ratio)
+		}
+		if name == SamplerTraceIDRatio {
+			return sdktrace.TraceIDRatioBased(ratio), nil
+		}
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	case SamplerRateLimited, SamplerParentBasedRateLimited:
+		perSecond, err := parseSamplerArg(arg, 10)
+		if err != nil {
+			return nil, err
+		}
+		if perSecond < 0 {
+			return nil, fmt.Errorf("sampler rate %v is negative", perSecond)
+		}
+		if name == SamplerRateLimited {
+			return NewRateLimitedSampler(perSecond), nil
+		}
+		return sdktrace.ParentBased(NewRateLimitedSampler(perSecond)), nil
+	default:
+		return nil, fmt.Errorf("unsupported sampler: %s", name)
+	}
+}
+
+func parseSamplerArg(arg string, fallback float64) (float64, error) {
+	if arg == "" {
+		return fallback, nil
+	}
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sampler argument %q: %w", arg, err)
+	}
+	return value, nil
+}
+
+// RateLimitedSampler samples at most perSecond traces per second, with
+// bursts of up to one second's worth of traces
+type RateLimitedSampler struct {
+	perSecond float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimitedSampler(perSecond float64) *RateLimitedSampler {
+	return &RateLimitedSampler{
+		perSecond: perSecond,
+		tokens:    burst(perSecond),
+		last:      time.Now(),
+	}
+}
+
+func (s *RateLimitedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	decision := sdktrace.Drop
+	if s.take() {
+		decision = sdktrace.RecordAndSample
+	}
+
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s *RateLimitedSampler) take() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.tokens = math.Min(s.tokens+now.Sub(s.last).Seconds()*s.perSecond, burst(s.perSecond))
+	s.last = now
+
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (s *RateLimitedSampler) Description() string {
+	return fmt.Sprintf("RateLimitedSampler{%v/s}", s.perSecond)
+}
+
+// burst is the bucket size: one second's worth of traces, and at least one
+// trace unless sampling is disabled
+func burst(perSecond float64) float64 {
+	if perSecond == 0 {
+		return 0
+	}
+	return math.Max(perSecond, 1)
+}
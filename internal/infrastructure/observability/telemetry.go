@@ -3,18 +3,17 @@ package observability
 import (
 	"context"
 	"fmt"
-	"time"
 
+	promclient "github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
@@ -22,18 +21,24 @@ import (
 
 type TelemetryManager struct {
 	tracerProvider *sdktrace.TracerProvider
+	sampler        *RuleSampler
 	meterProvider  *sdkmetric.MeterProvider
+	promGatherer   promclient.Gatherer
+	loggerProvider *sdklog.LoggerProvider
+	resource       *resource.Resource
 	tracer         trace.Tracer
 	meter          metric.Meter
 	config         *config.TelemetryConfig
 }
 
-func NewTelemetryManager(cfg *config.TelemetryConfig) (*TelemetryManager, error) {
+// NewTelemetryManager sets up the providers; environment is recorded as
+// deployment.environment on the resource
+func NewTelemetryManager(cfg *config.TelemetryConfig, environment string) (*TelemetryManager, error) {
 	tm := &TelemetryManager{
 		config: cfg,
 	}
 
-	if err := tm.setupResource(); err != nil {
+	if err := tm.setupResource(environment); err != nil {
 		return nil, fmt.Errorf("failed to setup resource: %w", err)
 	}
 
@@ -45,6 +50,10 @@ func NewTelemetryManager(cfg *config.TelemetryConfig) (*TelemetryManager, error)
 		return nil, fmt.Errorf("failed to setup metrics: %w", err)
 	}
 
+	if err := tm.setupLogs(); err != nil {
+		return nil, fmt.Errorf("failed to setup logs: %w", err)
+	}
+
 	tm.setupPropagation()
 
 	// Initialize tracer and meter
@@ -54,44 +63,43 @@ func NewTelemetryManager(cfg *config.TelemetryConfig) (*TelemetryManager, error)
 	return tm, nil
 }
 
-func (tm *TelemetryManager) setupResource() error {
-	res, err := resource.Merge(
-		resource.Default(),
-		resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(tm.config.ServiceName),
-			semconv.ServiceVersionKey.String(tm.config.ServiceVersion),
-			semconv.DeploymentEnvironmentKey.String("development"),
-		),
-	)
+func (tm *TelemetryManager) setupResource(environment string) error {
+	res, err := newResource(context.Background(), tm.config, environment)
 	if err != nil {
 		return fmt.Errorf("failed to create resource: %w", err)
 	}
-
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
+	tm.resource = res
 
 	return nil
 }
 
 func (tm *TelemetryManager) setupTracing() error {
+	sampler, err := NewRuleSampler(&tm.config.Sampling)
+	if err != nil {
+		return fmt.Errorf("failed to create sampler: %w", err)
+	}
+	tm.sampler = sampler
+
 	var exporters []sdktrace.SpanExporter
 
-	// OTLP HTTP Exporter (modern replacement for Jaeger)
-	if tm.config.JaegerEndpoint != "" {
-		otlpExporter, err := otlptracehttp.New(
-			context.Background(),
-			otlptracehttp.WithEndpoint(tm.config.JaegerEndpoint),
-			otlptracehttp.WithInsecure(), // For development
-		)
+	// OTLP exporter, over HTTP or gRPC
+	if tm.config.OTLP.Endpoint != "" || tm.config.OTLP.TracesEndpoint != "" {
+		otlpExporter, err := newOTLPTraceExporter(context.Background(), &tm.config.OTLP)
 		if err != nil {
 			return fmt.Errorf("failed to create OTLP exporter: %w", err)
 		}
 		exporters = append(exporters, otlpExporter)
 	}
 
+	// Azure Monitor exporter, alongside OTLP
+	if tm.config.AzureMonitorConnectionString != "" {
+		azureExporter, err := newAzureTraceExporter(tm.config.AzureMonitorConnectionString)
+		if err != nil {
+			return fmt.Errorf("failed to create Azure Monitor exporter: %w", err)
+		}
+		exporters = append(exporters, azureExporter)
+	}
+
 	if len(exporters) == 0 {
 		// Fallback to console exporter for development
 		consoleExporter, err := newConsoleExporter()
@@ -109,8 +117,8 @@ func (tm *TelemetryManager) setupTracing() error {
 
 	// Create tracer provider
 	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithResource(tm.getResource()),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithResource(tm.resource),
+		sdktrace.WithSampler(sampler),
 	)
 
 	for _, processor := range processors {
@@ -124,17 +132,70 @@ func (tm *TelemetryManager) setupTracing() error {
 }
 
 func (tm *TelemetryManager) setupMetrics() error {
-	// Prometheus exporter
-	promExporter, err := prometheus.New()
+	exporters, err := metricsExporters(tm.config.Metrics.Exporters)
+	if err != nil {
+		return err
+	}
+
+	views, err := metricViews(tm.config.Metrics.Views)
 	if err != nil {
-		return fmt.Errorf("failed to create prometheus exporter: %w", err)
+		return err
+	}
+
+	filter, err := exemplarFilter(tm.config.Metrics.ExemplarFilter)
+	if err != nil {
+		return err
+	}
+
+	opts := []sdkmetric.Option{
+		sdkmetric.WithResource(tm.resource),
+		sdkmetric.WithView(views...),
+		sdkmetric.WithExemplarFilter(filter),
+	}
+
+	// Prometheus exporter, served by MetricsHandler
+	if exporters[MetricsExporterPrometheus] {
+		filter, err := newInstrumentFilter(tm.config.Metrics.PrometheusInstruments)
+		if err != nil {
+			return err
+		}
+
+		registry := promclient.NewRegistry()
+		promExporter, err := prometheus.New(prometheus.WithRegisterer(registry))
+		if err != nil {
+			return fmt.Errorf("failed to create prometheus exporter: %w", err)
+		}
+		tm.promGatherer = &filteringGatherer{gatherer: registry, filter: filter}
+		opts = append(opts, sdkmetric.WithReader(promExporter))
+	}
+
+	// OTLP exporter on a periodic reader
+	if exporters[MetricsExporterOTLP] {
+		reader, err := newOTLPMetricReader(context.Background(), &tm.config.OTLP, &tm.config.Metrics)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, sdkmetric.WithReader(reader))
+	}
+
+	// Azure Monitor exporter on a periodic reader
+	if tm.config.AzureMonitorConnectionString != "" {
+		interval, err := parseMillisOrDuration(tm.config.Metrics.Interval, defaultMetricsInterval)
+		if err != nil {
+			return fmt.Errorf("invalid metrics export interval: %w", err)
+		}
+		azureExporter, err := newAzureMetricExporter(tm.config.AzureMonitorConnectionString)
+		if err != nil {
+			return fmt.Errorf("failed to create Azure Monitor exporter: %w", err)
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(azureExporter,
+			sdkmetric.WithInterval(interval),
+			sdkmetric.WithTimeout(azureExportTimeout),
+		)))
 	}
 
 	// Create meter provider
-	mp := sdkmetric.NewMeterProvider(
-		sdkmetric.WithResource(tm.getResource()),
-		sdkmetric.WithReader(promExporter),
-	)
+	mp := sdkmetric.NewMeterProvider(opts...)
 
 	tm.meterProvider = mp
 	otel.SetMeterProvider(mp)
@@ -142,6 +203,31 @@ func (tm *TelemetryManager) setupMetrics() error {
 	return nil
 }
 
+func (tm *TelemetryManager) setupLogs() error {
+	exporter, err := logsExporter(tm.config.Logs.Exporter)
+	if err != nil {
+		return err
+	}
+	if exporter == LogsExporterNone {
+		return nil
+	}
+
+	processor, err := newLogBatchProcessor(context.Background(), &tm.config.OTLP, &tm.config.Logs)
+	if err != nil {
+		return err
+	}
+
+	lp := sdklog.NewLoggerProvider(
+		sdklog.WithResource(tm.resource),
+		sdklog.WithProcessor(processor),
+	)
+
+	tm.loggerProvider = lp
+	global.SetLoggerProvider(lp)
+
+	return nil
+}
+
 func (tm *TelemetryManager) setupPropagation() {
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
 		propagation.TraceContext{},
@@ -149,16 +235,10 @@ func (tm *TelemetryManager) setupPropagation() {
 	))
 }
 
-func (tm *TelemetryManager) getResource() *resource.Resource {
-	res, _ := resource.Merge(
-		resource.Default(),
-		resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(tm.config.ServiceName),
-			semconv.ServiceVersionKey.String(tm.config.ServiceVersion),
-		),
-	)
-	return res
+// UpdateSampling swaps the sampler configuration of the running tracer
+// provider
+func (tm *TelemetryManager) UpdateSampling(cfg *config.SamplingConfig) error {
+	return tm.sampler.Update(cfg)
 }
 
 func (tm *TelemetryManager) Tracer() trace.Tracer {
@@ -184,6 +264,12 @@ func (tm *TelemetryManager) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	if tm.loggerProvider != nil {
+		if err := tm.loggerProvider.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("logger provider shutdown failed: %w", err))
+		}
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("telemetry shutdown errors: %v", errs)
 	}
@@ -222,6 +308,12 @@ type BusinessMetrics struct {
 	UserCreationCounter   metric.Int64Counter
 	ExternalAPICounter    metric.Int64Counter
 	ExternalAPIDuration   metric.Float64Histogram
+	BreakerTransitions    metric.Int64Counter
+	BulkheadRejections    metric.Int64Counter
+	RateLimiterWait       metric.Float64Histogram
+	RateLimiterRejections metric.Int64Counter
+	PaymentsReconciled    metric.Int64Counter
+	ReconciliationRuns    metric.Int64Counter
 }
 
 func NewBusinessMetrics(meter metric.Meter) (*BusinessMetrics, error) {
@@ -297,6 +389,60 @@ func NewBusinessMetrics(meter metric.Meter) (*BusinessMetrics, error) {
 		return nil, err
 	}
 
+	breakerTransitions, err := meter.Int64Counter(
+		"external_circuit_breaker_transitions_total",
+		metric.WithDescription("Circuit breaker state changes of external clients"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	bulkheadRejections, err := meter.Int64Counter(
+		"external_bulkhead_rejections_total",
+		metric.WithDescription("External API calls rejected because the client was at its concurrency limit"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimiterWait, err := meter.Float64Histogram(
+		"external_rate_limiter_wait_seconds",
+		metric.WithDescription("Time external API calls waited for a token from the client's rate limiter"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimiterRejections, err := meter.Int64Counter(
+		"external_rate_limiter_rejections_total",
+		metric.WithDescription("External API calls rejected because the client's rate limiter would hold them too long"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentsReconciled, err := meter.Int64Counter(
+		"payments_reconciled_total",
+		metric.WithDescription("Stuck payments checked by the reconciliation job, by outcome"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reconciliationRuns, err := meter.Int64Counter(
+		"payments_reconciliation_runs_total",
+		metric.WithDescription("Reconciliation job runs, by result"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	return &BusinessMetrics{
 		RequestCounter:        requestCounter,
 		RequestDuration:       requestDuration,
@@ -306,5 +452,11 @@ func NewBusinessMetrics(meter metric.Meter) (*BusinessMetrics, error) {
 		UserCreationCounter:   userCreationCounter,
 		ExternalAPICounter:    externalAPICounter,
 		ExternalAPIDuration:   externalAPIDuration,
+		BreakerTransitions:    breakerTransitions,
+		BulkheadRejections:    bulkheadRejections,
+		RateLimiterWait:       rateLimiterWait,
+		RateLimiterRejections: rateLimiterRejections,
+		PaymentsReconciled:    paymentsReconciled,
+		ReconciliationRuns:    reconciliationRuns,
 	}, nil
 }
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+// ErrorResponse answers a handler's error. The ErrorHandler middleware uses
+// it for errors from the routes; as the app's fiber.Config.ErrorHandler it
+// answers the rest, e.g. errors returned by middleware ahead of it.
+//
+// Provider errors are answered as a gateway would: a provider's 404 stays
+// 404, rejected input becomes 422, rate limits and outages become 503 with
+// the provider's Retry-After, and other provider failures become 502.
+// Anything unrecognised is a 500 that does not reveal the error.
+func ErrorResponse(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{
+			"error": fiberErr.Message,
+		})
+	}
+
+	if apiErr, ok := external.AsAPIError(err); ok {
+		status, code := apiErrorStatus(apiErr)
+		if status == fiber.StatusServiceUnavailable && apiErr.RetryAfter > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error":         code,
+			"message":       apiErr.Message,
+			"provider":      apiErr.Provider,
+			"operation":     apiErr.Operation,
+			"provider_code": apiErr.Code,
+		})
+	}
+
+	switch {
+	case errors.Is(err, external.ErrRateLimited):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error":   "upstream_rate_limited",
+			"message": err.Error(),
+		})
+	case errors.Is(err, external.ErrCircuitOpen), errors.Is(err, external.ErrBulkheadFull):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error":   "upstream_unavailable",
+			"message": err.Error(),
+		})
+	case errors.Is(err, context.DeadlineExceeded):
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{
+			"error": "timeout",
+		})
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Internal server error",
+	})
+}
+
+// apiErrorStatus maps a provider error onto this service's status and
+// error code
+func apiErrorStatus(err *external.APIError) (int, string) {
+	switch {
+	case err.StatusCode == http.StatusNotFound:
+		return fiber.StatusNotFound, "upstream_not_found"
+	case external.IsInvalidRequest(err):
+		return fiber.StatusUnprocessableEntity, "upstream_rejected"
+	case err.StatusCode == http.StatusTooManyRequests:
+		return fiber.StatusServiceUnavailable, "upstream_rate_limited"
+	case err.StatusCode == http.StatusServiceUnavailable:
+		return fiber.StatusServiceUnavailable, "upstream_unavailable"
+	case err.StatusCode == http.StatusRequestTimeout || err.StatusCode == http.StatusGatewayTimeout:
+		return fiber.StatusGatewayTimeout, "upstream_timeout"
+	}
+	return fiber.StatusBadGateway, "upstream_error"
+}
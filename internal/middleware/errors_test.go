@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+func apiError(status int, retryAfter time.Duration) error {
+	return fmt.Errorf("calling provider: %w", &external.APIError{
+		Provider:   "mtnpay",
+		Operation:  "process_payment",
+		StatusCode: status,
+		RetryAfter: retryAfter,
+	})
+}
+
+// TestErrorResponse sends every kind of error through the ErrorHandler
+// middleware and checks the status, error code and Retry-After header, and
+// that the app's error handler is not called again
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		code       string
+		retryAfter string
+	}{
+		{"fiber error", fiber.NewError(fiber.StatusTeapot, "short and stout"), fiber.StatusTeapot, "short and stout", ""},
+		{"provider 404", apiError(http.StatusNotFound, time.Second), fiber.StatusNotFound, "upstream_not_found", ""},
+		{"provider 400", apiError(http.StatusBadRequest, 0), fiber.StatusUnprocessableEntity, "upstream_rejected", ""},
+		{"provider 422", apiError(http.StatusUnprocessableEntity, 0), fiber.StatusUnprocessableEntity, "upstream_rejected", ""},
+		{"provider 429", apiError(http.StatusTooManyRequests, 1500*time.Millisecond), fiber.StatusServiceUnavailable, "upstream_rate_limited", "2"},
+		{"provider 503", apiError(http.StatusServiceUnavailable, 30*time.Second), fiber.StatusServiceUnavailable, "upstream_unavailable", "30"},
+		{"provider 503 without Retry-After", apiError(http.StatusServiceUnavailable, 0), fiber.StatusServiceUnavailable, "upstream_unavailable", ""},
+		{"provider 408", apiError(http.StatusRequestTimeout, 0), fiber.StatusGatewayTimeout, "upstream_timeout", ""},
+		{"provider 504", apiError(http.StatusGatewayTimeout, 0), fiber.StatusGatewayTimeout, "upstream_timeout", ""},
+		{"provider 500", apiError(http.StatusInternalServerError, time.Second), fiber.StatusBadGateway, "upstream_error", ""},
+		{"provider 501", apiError(http.StatusNotImplemented, 0), fiber.StatusBadGateway, "upstream_error", ""},
+		{"rate limiter", fmt.Errorf("mtnpay: %w", external.ErrRateLimited), fiber.StatusServiceUnavailable, "upstream_rate_limited", ""},
+		{"circuit open", fmt.Errorf("mtnpay: %w", external.ErrCircuitOpen), fiber.StatusServiceUnavailable, "upstream_unavailable", ""},
+		{"bulkhead full", fmt.Errorf("mtnpay: %w", external.ErrBulkheadFull), fiber.StatusServiceUnavailable, "upstream_unavailable", ""},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), fiber.StatusGatewayTimeout, "timeout", ""},
+		{"anything else", errors.New("secret detail"), fiber.StatusInternalServerError, "Internal server error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appHandled := 0
+			app := fiber.New(fiber.Config{
+				ErrorHandler: func(c *fiber.Ctx, err error) error {
+					appHandled++
+					return ErrorResponse(c, err)
+				},
+			})
+			app.Use(ErrorHandler())
+			app.Get("/", func(c *fiber.Ctx) error { return tt.err })
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get(fiber.HeaderRetryAfter); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			if appHandled != 0 {
+				t.Errorf("app error handler called %d times after the middleware answered", appHandled)
+			}
+
+			// A second write would append another JSON document
+			dec := json.NewDecoder(resp.Body)
+			var body map[string]interface{}
+			if err := dec.Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.Token(); err != io.EOF {
+				t.Errorf("response has more than one body: %v", err)
+			}
+			if body["error"] != tt.code {
+				t.Errorf("error = %v, want %s", body["error"], tt.code)
+			}
+			if tt.status == fiber.StatusInternalServerError && body["message"] != nil {
+				t.Errorf("500 reveals the error: %v", body["message"])
+			}
+		})
+	}
+}
+
+// TestErrorResponseAsAppHandler answers errors raised ahead of the
+// ErrorHandler middleware, such as a missing route
+func TestErrorResponseAsAppHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorResponse})
+	app.Use(ErrorHandler())
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/missing", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/database"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
 	"github.com/webbies/otel-fiber-demo/internal/infrastructure/observability"
 )
 
@@ -29,6 +30,7 @@ func RequestTracing(tracer trace.Tracer) fiber.Handler {
 				attribute.String("http.host", c.Hostname()),
 				attribute.String("http.user_agent", c.Get("User-Agent")),
 				attribute.String("http.route", c.Route().Path),
+				observability.RouteAttribute.String(c.Path()),
 			),
 		)
 		defer span.End()
@@ -93,6 +95,12 @@ func RequestLogging(logger *observability.Logger) fiber.Handler {
 		duration := time.Since(start)
 		ctx := c.UserContext()
 
+		// Errors already answered by ErrorHandler are logged all the same
+		logErr := err
+		if answered, ok := c.Locals(errorLocalsKey).(error); ok && logErr == nil {
+			logErr = answered
+		}
+
 		logFields := []interface{}{
 			"method", c.Method(),
 			"path", string(c.Request().RequestURI()),
@@ -109,11 +117,11 @@ func RequestLogging(logger *observability.Logger) fiber.Handler {
 			}
 		}
 
-		if err != nil {
-			logFields = append(logFields, "error", err.Error())
-			logger.WithTrace(ctx).Error("HTTP request failed", logFields...)
+		if logErr != nil {
+			logFields = append(logFields, "error", logErr.Error())
+			logger.WithTrace(ctx).Sugar().Errorw("HTTP request failed", logFields...)
 		} else {
-			logger.WithTrace(ctx).Info("HTTP request completed", logFields...)
+			logger.WithTrace(ctx).Sugar().Infow("HTTP request completed", logFields...)
 		}
 
 		return err
@@ -147,33 +155,39 @@ func RateLimit(redis *database.Redis, cfg *config.RateLimitConfig) fiber.Handler
 	}
 }
 
-// ErrorHandler middleware handles errors with proper tracing
+// errorLocalsKey holds the error answered by ErrorHandler so that
+// RequestLogging can log it
+const errorLocalsKey = "request_error"
+
+// ErrorHandler middleware answers errors with ErrorResponse where the
+// request middleware can see the status. The error is recorded on the
+// request span and left for RequestLogging, then swallowed so that the
+// app's ErrorHandler does not answer it a second time.
 func ErrorHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		err := c.Next()
-		if err != nil {
-			// Add error to the current span
-			ctx := c.UserContext()
-			if span := trace.SpanFromContext(ctx); span.IsRecording() {
-				span.RecordError(err)
+		if err == nil {
+			return nil
+		}
+
+		// Add error to the current span
+		ctx := c.UserContext()
+		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+			span.RecordError(err)
+			span.SetAttributes(
+				attribute.String("error.type", "http_error"),
+				attribute.Bool("error.handled", true),
+			)
+			if apiErr, ok := external.AsAPIError(err); ok {
 				span.SetAttributes(
-					attribute.String("error.type", "http_error"),
-					attribute.Bool("error.handled", true),
+					attribute.String("error.provider", apiErr.Provider),
+					attribute.Int("error.provider_status_code", apiErr.StatusCode),
 				)
 			}
-
-			// Return appropriate error response
-			if e, ok := err.(*fiber.Error); ok {
-				return c.Status(e.Code).JSON(fiber.Map{
-					"error": e.Message,
-				})
-			}
-
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Internal server error",
-			})
 		}
-		return nil
+		c.Locals(errorLocalsKey, err)
+
+		return ErrorResponse(c, err)
 	}
 }
 
@@ -0,0 +1,168 @@
+package mockproviders
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+// Volume discount of the pricing endpoint
+const (
+	volumeDiscountQuantity = 10
+	volumeDiscount         = 0.1
+	// quoteValidity is how long a price quote holds
+	quoteValidity = 15 * time.Minute
+)
+
+// rewardTypes are the reward types MADAPI accepts, as in entities.RewardType
+var rewardTypes = map[string]bool{"points": true, "cashback": true, "discount": true, "bonus": true}
+
+func (s *Server) madapiRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /validate/user", s.endpoint("madapi", "validate_user", s.validateUser))
+	mux.HandleFunc("POST /pricing", s.endpoint("madapi", "get_pricing", s.getPricing))
+	mux.HandleFunc("POST /validate/reward", s.endpoint("madapi", "validate_reward", s.validateReward))
+	mux.HandleFunc("GET /users/{userID}/profile", s.endpoint("madapi", "get_user_profile", s.getUserProfile))
+	return mux
+}
+
+// validateUser scores users by id; a malformed email, a missing phone or
+// "fraud" in the email fails validation
+func (s *Server) validateUser(w http.ResponseWriter, r *http.Request) {
+	var req external.UserValidationRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.UserID == "" {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	var reasons []string
+	if !strings.Contains(req.Email, "@") {
+		reasons = append(reasons, "invalid_email")
+	}
+	if req.Phone == "" {
+		reasons = append(reasons, "missing_phone")
+	}
+	if strings.Contains(strings.ToLower(req.Email), "fraud") {
+		reasons = append(reasons, "blocklisted")
+	}
+
+	score := 0.5 + float64(hash(req.UserID)%50)/100 - 0.3*float64(len(reasons))
+	score = math.Max(0, math.Round(score*100)/100)
+	risk := "high"
+	switch {
+	case score >= 0.8:
+		risk = "low"
+	case score >= 0.6:
+		risk = "medium"
+	}
+
+	writeJSON(w, http.StatusOK, external.UserValidationResponse{
+		UserID:      req.UserID,
+		IsValid:     len(reasons) == 0,
+		Score:       score,
+		Reasons:     reasons,
+		RiskLevel:   risk,
+		Metadata:    map[string]string{"provider": "madapi-mock"},
+		ValidatedAt: time.Now().UTC(),
+	})
+}
+
+// getPricing quotes a stable unit price per product, 10% off from 10 units
+func (s *Server) getPricing(w http.ResponseWriter, r *http.Request) {
+	var req external.PricingRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	switch {
+	case req.ProductID == "":
+		writeError(w, http.StatusBadRequest, "product_id is required")
+		return
+	case req.Quantity <= 0:
+		writeError(w, http.StatusBadRequest, "quantity must be positive")
+		return
+	}
+
+	resp := external.PricingResponse{
+		ProductID:  req.ProductID,
+		BasePrice:  roundCents(unitPrice(req.ProductID) * float64(req.Quantity)),
+		Currency:   "EUR",
+		ValidUntil: time.Now().UTC().Add(quoteValidity),
+	}
+	resp.FinalPrice = resp.BasePrice
+	if req.Quantity >= volumeDiscountQuantity {
+		resp.Discount = roundCents(resp.BasePrice * volumeDiscount)
+		resp.DiscountType = "volume"
+		resp.FinalPrice = roundCents(resp.BasePrice - resp.Discount)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// validateReward allows 1 cent per point, up to 500, and at most the
+// requested amount
+func (s *Server) validateReward(w http.ResponseWriter, r *http.Request) {
+	var req external.RewardValidationRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	switch {
+	case req.UserID == "":
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	case req.Points < 0:
+		writeError(w, http.StatusBadRequest, "points must not be negative")
+		return
+	}
+
+	resp := external.RewardValidationResponse{
+		Limits:      map[string]string{"max_amount": "500", "points_per_unit": "100"},
+		ValidatedAt: time.Now().UTC(),
+	}
+	switch {
+	case !rewardTypes[req.RewardType]:
+		resp.Reason = fmt.Sprintf("unsupported reward type %q", req.RewardType)
+	case req.Points == 0 && req.Amount == 0:
+		resp.Reason = "nothing to redeem"
+	default:
+		resp.IsValid = true
+		resp.EligibleAmount = math.Min(float64(req.Points)/100, 500)
+		if req.Amount > 0 {
+			resp.EligibleAmount = math.Min(resp.EligibleAmount, req.Amount)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getUserProfile derives a stable profile from the user id
+func (s *Server) getUserProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+	h := hash(userID)
+	now := time.Now().UTC()
+
+	writeJSON(w, http.StatusOK, external.UserProfileResponse{
+		UserID:       userID,
+		Tier:         []string{"bronze", "silver", "gold", "platinum"}[h%4],
+		IsVerified:   h%5 != 0,
+		CreditScore:  300 + int(h%551),
+		Preferences:  map[string]string{"language": "en", "notifications": "sms"},
+		LastActivity: now.Add(-time.Duration(h%72) * time.Hour),
+		CreatedAt:    now.AddDate(0, 0, -int(30+h%700)),
+	})
+}
+
+// unitPrice is a stable price between 5 and 500 for productID
+func unitPrice(productID string) float64 {
+	return 5 + float64(hash(productID)%49500)/100
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,293 @@
+package mockproviders
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+// MTN Pay statuses the mock reports
+const (
+	statusPending    = "PENDING"
+	statusProcessing = "PROCESSING"
+	statusSuccessful = "SUCCESSFUL"
+	statusFailed     = "FAILED"
+)
+
+type payment struct {
+	status    external.MTNPayStatusResponse
+	phone     string
+	metadata  map[string]string
+	createdAt time.Time
+	// Trace headers of the payment request, forwarded with the callback so
+	// the webhook continues the payment's trace
+	traceparent string
+	tracestate  string
+}
+
+func (s *Server) mtnPayRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /payments", s.endpoint("mtnpay", "process_payment", s.processPayment))
+	mux.HandleFunc("GET /payments/{transactionID}", s.endpoint("mtnpay", "get_payment_status", s.getPaymentStatus))
+	mux.HandleFunc("GET /balance/{phoneNumber}", s.endpoint("mtnpay", "get_balance", s.getBalance))
+	return mux
+}
+
+// processPayment answers with the result when settlement is synchronous and
+// PENDING otherwise. A repeated reference returns the original payment.
+func (s *Server) processPayment(w http.ResponseWriter, r *http.Request) {
+	var req external.MTNPayRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	switch {
+	case req.Amount <= 0:
+		writeError(w, http.StatusBadRequest, "amount must be positive")
+		return
+	case req.Currency == "":
+		writeError(w, http.StatusBadRequest, "currency is required")
+		return
+	case req.PhoneNumber == "":
+		writeError(w, http.StatusBadRequest, "phone_number is required")
+		return
+	case req.Reference == "":
+		writeError(w, http.StatusBadRequest, "reference is required")
+		return
+	}
+
+	txnID := "MTN-" + strings.ToUpper(s.newID(16))
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	if existing, ok := s.byReference[req.Reference]; ok {
+		resp := paymentResponse(s.payments[existing], "duplicate reference, original payment returned")
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	settlement := s.scenario.Settlement
+	failureReason := ""
+	switch {
+	case req.Amount > s.balance(req.PhoneNumber):
+		failureReason = "INSUFFICIENT_FUNDS"
+	case settlement.FailureRate > 0 && s.rand.Float64() < settlement.FailureRate:
+		failureReason = "PAYER_DECLINED"
+	}
+
+	p := &payment{
+		status: external.MTNPayStatusResponse{
+			TransactionID: txnID,
+			Status:        statusPending,
+			Amount:        req.Amount,
+			Currency:      req.Currency,
+			Reference:     req.Reference,
+		},
+		phone:       req.PhoneNumber,
+		metadata:    req.Metadata,
+		createdAt:   now,
+		traceparent: r.Header.Get("traceparent"),
+		tracestate:  r.Header.Get("tracestate"),
+	}
+	s.payments[txnID] = p
+	s.byReference[req.Reference] = txnID
+	if settlement.Async {
+		resp := paymentResponse(p, "payment pending approval")
+		s.mu.Unlock()
+
+		s.settleLater(txnID, failureReason, settlement)
+		s.logger.Printf("mtnpay: payment %s pending, settles in %s", txnID, time.Duration(settlement.Delay))
+		writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+	s.finish(p, failureReason, now)
+	resp := paymentResponse(p, "")
+	s.mu.Unlock()
+
+	s.logger.Printf("mtnpay: payment %s %s", txnID, resp.Status)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) getPaymentStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	p, ok := s.payments[r.PathValue("transactionID")]
+	var status external.MTNPayStatusResponse
+	if ok {
+		status = p.status
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no transaction "+r.PathValue("transactionID"))
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) getBalance(w http.ResponseWriter, r *http.Request) {
+	phone := r.PathValue("phoneNumber")
+
+	s.mu.Lock()
+	balance := s.balance(phone)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, external.BalanceResponse{
+		PhoneNumber: phone,
+		Balance:     balance,
+		Currency:    "EUR",
+		Status:      "ACTIVE",
+	})
+}
+
+// balance returns the wallet balance of phone, starting it at a stable
+// amount between 500 and 10,000. s.mu must be held.
+func (s *Server) balance(phone string) float64 {
+	if b, ok := s.balances[phone]; ok {
+		return b
+	}
+	b := float64(500 + hash(phone)%9500)
+	s.balances[phone] = b
+	return b
+}
+
+// finish gives p its final status and debits successful payments. s.mu must
+// be held.
+func (s *Server) finish(p *payment, failureReason string, at time.Time) {
+	p.status.CompletedAt = &at
+	if failureReason != "" {
+		p.status.Status = statusFailed
+		p.status.FailureReason = failureReason
+		return
+	}
+	p.status.Status = statusSuccessful
+	s.balances[p.phone] = s.balance(p.phone) - p.status.Amount
+}
+
+// settleLater moves the payment to PROCESSING halfway through the delay,
+// settles it at the end and posts the result
+func (s *Server) settleLater(txnID, failureReason string, settlement Settlement) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		half := time.Duration(settlement.Delay) / 2
+		if !s.sleep(half) {
+			return
+		}
+		s.mu.Lock()
+		s.payments[txnID].status.Status = statusProcessing
+		s.mu.Unlock()
+
+		if !s.sleep(half) {
+			return
+		}
+		s.mu.Lock()
+		p := s.payments[txnID]
+		s.finish(p, failureReason, time.Now().UTC())
+		callback := external.MTNPayCallback{
+			TransactionID: txnID,
+			Reference:     p.status.Reference,
+			Status:        p.status.Status,
+			Amount:        p.status.Amount,
+			Currency:      p.status.Currency,
+			FailureReason: p.status.FailureReason,
+			Metadata:      p.metadata,
+			Timestamp:     time.Now().UTC(),
+		}
+		traceparent, tracestate := p.traceparent, p.tracestate
+		s.mu.Unlock()
+
+		s.logger.Printf("mtnpay: payment %s %s", txnID, callback.Status)
+		if s.callbackURL == "" || settlement.DropCallbacks {
+			return
+		}
+		for n := 0; n <= settlement.DuplicateCallbacks; n++ {
+			s.sendCallback(callback, traceparent, tracestate)
+		}
+	}()
+}
+
+// sendCallback posts a signed payment result, retrying while the webhook
+// answers 404 (the payment may not be stored yet), 409 or 5xx
+func (s *Server) sendCallback(callback external.MTNPayCallback, traceparent, tracestate string) {
+	body, err := json.Marshal(callback)
+	if err != nil {
+		s.logger.Printf("mtnpay: callback for %s: %v", callback.TransactionID, err)
+		return
+	}
+
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		status, err := s.postCallback(body, traceparent, tracestate)
+		retry := err != nil || status == http.StatusNotFound || status == http.StatusConflict || status >= 500
+		switch {
+		case err != nil:
+			s.logger.Printf("mtnpay: callback for %s, attempt %d: %v", callback.TransactionID, attempt, err)
+		default:
+			s.logger.Printf("mtnpay: callback for %s %s, attempt %d: %d", callback.TransactionID, callback.Status, attempt, status)
+		}
+		if !retry || attempt == callbackAttempts {
+			return
+		}
+
+		if !s.sleep(wait) {
+			return
+		}
+		wait *= 2
+	}
+}
+
+func (s *Server) postCallback(body []byte, traceparent, tracestate string) (int, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if traceparent != "" {
+		req.Header.Set("traceparent", traceparent)
+		if tracestate != "" {
+			req.Header.Set("tracestate", tracestate)
+		}
+	}
+	if s.signer != nil {
+		if err := s.signer.Sign(req); err != nil {
+			return 0, fmt.Errorf("failed to sign callback: %w", err)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func paymentResponse(p *payment, message string) external.MTNPayResponse {
+	if message == "" && p.status.FailureReason != "" {
+		message = p.status.FailureReason
+	}
+	return external.MTNPayResponse{
+		TransactionID: p.status.TransactionID,
+		Status:        p.status.Status,
+		Amount:        p.status.Amount,
+		Currency:      p.status.Currency,
+		Reference:     p.status.Reference,
+		Message:       message,
+		Metadata:      p.metadata,
+		CreatedAt:     p.createdAt,
+	}
+}
+
+// hash derives stable mock data from ids
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,218 @@
+package mockproviders
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string, e.g. "250ms", in
+// scenario files
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"250ms\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Rule shapes the responses of an operation
+type Rule struct {
+	// Latency is added before every response, plus up to Jitter more
+	Latency Duration `json:"latency,omitempty"`
+	Jitter  Duration `json:"jitter,omitempty"`
+	// ErrorRate is the fraction of requests, 0 to 1, answered with
+	// ErrorStatus (503 when unset) instead of a result
+	ErrorRate   float64 `json:"error_rate,omitempty"`
+	ErrorStatus int     `json:"error_status,omitempty"`
+	// RetryAfter is sent with 429 and 503 errors when set
+	RetryAfter Duration `json:"retry_after,omitempty"`
+}
+
+// Settlement controls how MTN Pay payments reach a final status
+type Settlement struct {
+	// Async answers payments PENDING and settles them Delay later;
+	// otherwise the result is in the payment response
+	Async bool     `json:"async,omitempty"`
+	Delay Duration `json:"delay,omitempty"`
+	// FailureRate is the fraction of payments, 0 to 1, that fail
+	FailureRate float64 `json:"failure_rate,omitempty"`
+	// DropCallbacks settles payments without posting the result, leaving
+	// them to reconciliation
+	DropCallbacks bool `json:"drop_callbacks,omitempty"`
+	// DuplicateCallbacks posts each result this many extra times
+	DuplicateCallbacks int `json:"duplicate_callbacks,omitempty"`
+}
+
+// Scenario scripts the behaviour of the mock providers
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Default applies to operations without a rule of their own
+	Default Rule `json:"default"`
+	// Rules are keyed by provider ("mtnpay", "madapi", "soa") or by
+	// provider and operation, e.g. "mtnpay.process_payment"; the most
+	// specific one applies, whole
+	Rules      map[string]Rule `json:"rules,omitempty"`
+	Settlement Settlement      `json:"settlement"`
+}
+
+// rule returns the rule for an operation of provider
+func (s *Scenario) rule(provider, operation string) Rule {
+	if r, ok := s.Rules[provider+"."+operation]; ok {
+		return r
+	}
+	if r, ok := s.Rules[provider]; ok {
+		return r
+	}
+	return s.Default
+}
+
+func (s *Scenario) validate() error {
+	rules := map[string]Rule{"default": s.Default}
+	for key, r := range s.Rules {
+		provider, _, _ := strings.Cut(key, ".")
+		if _, ok := operations[provider]; !ok {
+			return fmt.Errorf("rule %q: unknown provider %q", key, provider)
+		}
+		if _, operation, ok := strings.Cut(key, "."); ok && !operations[provider][operation] {
+			return fmt.Errorf("rule %q: unknown operation %q", key, operation)
+		}
+		rules[key] = r
+	}
+	for key, r := range rules {
+		if r.ErrorRate < 0 || r.ErrorRate > 1 {
+			return fmt.Errorf("rule %q: error_rate must be between 0 and 1", key)
+		}
+		if r.ErrorStatus != 0 && (r.ErrorStatus < 400 || r.ErrorStatus > 599) {
+			return fmt.Errorf("rule %q: error_status must be a 4xx or 5xx status", key)
+		}
+	}
+	if f := s.Settlement.FailureRate; f < 0 || f > 1 {
+		return fmt.Errorf("settlement: failure_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// operations lists the operations of each provider, named as in the
+// clients' metrics and spans
+var operations = map[string]map[string]bool{
+	"mtnpay": {"process_payment": true, "get_payment_status": true, "get_balance": true},
+	"madapi": {"validate_user": true, "get_pricing": true, "validate_reward": true, "get_user_profile": true},
+	"soa":    {"check_inventory": true, "check_inventory_batch": true, "create_shipping": true, "get_product_catalog": true, "get_shipping_status": true},
+}
+
+var scenarios = map[string]Scenario{
+	"happy": {
+		Name:        "happy",
+		Description: "fast, error-free responses; payments succeed synchronously",
+		Default:     Rule{Latency: Duration(20 * time.Millisecond), Jitter: Duration(30 * time.Millisecond)},
+	},
+	"async": {
+		Name:        "async",
+		Description: "payments answer PENDING and settle by callback after 3s; 10% fail",
+		Default:     Rule{Latency: Duration(20 * time.Millisecond), Jitter: Duration(30 * time.Millisecond)},
+		Settlement:  Settlement{Async: true, Delay: Duration(3 * time.Second), FailureRate: 0.1},
+	},
+	"lost-callbacks": {
+		Name:        "lost-callbacks",
+		Description: "payments settle asynchronously but no callback is sent; reconciliation must find them",
+		Default:     Rule{Latency: Duration(20 * time.Millisecond), Jitter: Duration(30 * time.Millisecond)},
+		Settlement:  Settlement{Async: true, Delay: Duration(3 * time.Second), FailureRate: 0.1, DropCallbacks: true},
+	},
+	"duplicate-callbacks": {
+		Name:        "duplicate-callbacks",
+		Description: "every payment result is posted three times",
+		Default:     Rule{Latency: Duration(20 * time.Millisecond), Jitter: Duration(30 * time.Millisecond)},
+		Settlement:  Settlement{Async: true, Delay: Duration(3 * time.Second), DuplicateCallbacks: 2},
+	},
+	"slow": {
+		Name:        "slow",
+		Description: "1.5-2.5s responses from every provider",
+		Default:     Rule{Latency: Duration(1500 * time.Millisecond), Jitter: Duration(time.Second)},
+	},
+	"flaky": {
+		Name:        "flaky",
+		Description: "20% of calls to every provider fail with 503",
+		Default:     Rule{Latency: Duration(50 * time.Millisecond), Jitter: Duration(100 * time.Millisecond), ErrorRate: 0.2},
+		Settlement:  Settlement{Async: true, Delay: Duration(3 * time.Second), FailureRate: 0.1},
+	},
+	"throttled": {
+		Name:        "throttled",
+		Description: "30% of calls to every provider are rate limited with 429 and Retry-After: 1",
+		Default: Rule{
+			Latency:     Duration(20 * time.Millisecond),
+			Jitter:      Duration(30 * time.Millisecond),
+			ErrorRate:   0.3,
+			ErrorStatus: 429,
+			RetryAfter:  Duration(time.Second),
+		},
+	},
+	"mtnpay-outage": {
+		Name:        "mtnpay-outage",
+		Description: "MTN Pay answers every call with 503; MADAPI and SOA are healthy",
+		Default:     Rule{Latency: Duration(20 * time.Millisecond), Jitter: Duration(30 * time.Millisecond)},
+		Rules: map[string]Rule{
+			"mtnpay": {Latency: Duration(100 * time.Millisecond), ErrorRate: 1},
+		},
+	},
+}
+
+// Scenarios returns the names of the built-in scenarios
+func Scenarios() []string {
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuiltinScenario returns the built-in scenario with name
+func BuiltinScenario(name string) (*Scenario, error) {
+	s, ok := scenarios[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scenario %q, want one of %v or a JSON file", name, Scenarios())
+	}
+	return &s, nil
+}
+
+// LoadScenario returns the built-in scenario called nameOrPath or reads
+// one from the JSON file at that path
+func LoadScenario(nameOrPath string) (*Scenario, error) {
+	if !strings.HasSuffix(nameOrPath, ".json") {
+		return BuiltinScenario(nameOrPath)
+	}
+
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+	return ParseScenario(data)
+}
+
+// ParseScenario decodes and validates a JSON scenario
+func ParseScenario(data []byte) (*Scenario, error) {
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario: %w", err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %q: %w", s.Name, err)
+	}
+	return &s, nil
+}
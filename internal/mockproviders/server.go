@@ -0,0 +1,340 @@
+// Package mockproviders serves local stand-ins for the MTN Pay, MADAPI and
+// SOA APIs so the service can run, and be checked, offline. A Scenario
+// scripts their latency, errors and how payments settle.
+package mockproviders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+// Handler serves each provider under its prefix, so a client's base URL is
+// the server's URL plus the prefix
+const (
+	MTNPayPrefix = "/mtnpay"
+	MADAPIPrefix = "/madapi"
+	SOAPrefix    = "/soa"
+	// TokenPath issues OAuth2 client credentials tokens to any client
+	TokenPath = "/oauth2/token"
+	// ScenarioPath reports the scenario on GET and replaces it on PUT, with
+	// a scenario as the body or a built-in one's name as ?name=
+	ScenarioPath = "/_scenario"
+)
+
+// callbackAttempts bounds the deliveries of one callback
+const callbackAttempts = 5
+
+type Options struct {
+	// Scenario defaults to the "happy" one
+	Scenario *Scenario
+	// CallbackURL receives MTN Pay payment results, e.g. the service's
+	// /v1/webhooks/mtnpay; empty disables callbacks
+	CallbackURL string
+	// Secret, when set, is required to sign MTN Pay requests and signs the
+	// callbacks, as MTN_PAY_SECRET does for the service
+	Secret string
+	// Seed makes the scripted errors and generated ids repeatable; zero
+	// seeds from the clock
+	Seed int64
+	// Logger defaults to discarding
+	Logger *log.Logger
+}
+
+// Server holds the providers' state: payments, balances and shipments live
+// in memory for the life of the server
+type Server struct {
+	callbackURL string
+	signer      *external.MTNPaySigner
+	verifier    *external.MTNPayVerifier
+	client      *http.Client
+	logger      *log.Logger
+
+	handler http.Handler
+	mtnPay  http.Handler
+	madapi  http.Handler
+	soa     http.Handler
+
+	mu          sync.Mutex
+	scenario    *Scenario
+	rand        *rand.Rand
+	payments    map[string]*payment
+	byReference map[string]string
+	balances    map[string]float64
+	shipments   map[string]*shipment
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(opts Options) *Server {
+	scenario := opts.Scenario
+	if scenario == nil {
+		scenario, _ = BuiltinScenario("happy")
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		callbackURL: opts.CallbackURL,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      logger,
+		scenario:    scenario,
+		rand:        rand.New(rand.NewSource(seed)),
+		payments:    map[string]*payment{},
+		byReference: map[string]string{},
+		balances:    map[string]float64{},
+		shipments:   map[string]*shipment{},
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	if opts.Secret != "" {
+		s.signer = external.NewMTNPaySigner(opts.Secret)
+		s.verifier = external.NewMTNPayVerifier(opts.Secret, 5*time.Minute)
+	}
+
+	s.mtnPay = s.authenticate(s.mtnPayRoutes(), s.verifier)
+	s.madapi = s.authenticate(s.madapiRoutes(), nil)
+	s.soa = s.authenticate(s.soaRoutes(), nil)
+
+	mux := http.NewServeMux()
+	mux.Handle(MTNPayPrefix+"/", http.StripPrefix(MTNPayPrefix, s.mtnPay))
+	mux.Handle(MADAPIPrefix+"/", http.StripPrefix(MADAPIPrefix, s.madapi))
+	mux.Handle(SOAPrefix+"/", http.StripPrefix(SOAPrefix, s.soa))
+	mux.HandleFunc("POST "+TokenPath, s.issueToken)
+	mux.HandleFunc("GET "+ScenarioPath, s.getScenario)
+	mux.HandleFunc("PUT "+ScenarioPath, s.putScenario)
+	s.handler = mux
+
+	return s
+}
+
+// Handler serves every provider under its prefix, the token endpoint and
+// the scenario endpoint
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// MTNPay serves the MTN Pay API at the root
+func (s *Server) MTNPay() http.Handler {
+	return s.mtnPay
+}
+
+// MADAPI serves the MADAPI API at the root
+func (s *Server) MADAPI() http.Handler {
+	return s.madapi
+}
+
+// SOA serves the SOA API at the root
+func (s *Server) SOA() http.Handler {
+	return s.soa
+}
+
+// Scenario returns the scenario in use
+func (s *Server) Scenario() *Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scenario
+}
+
+// SetScenario replaces the scenario for the requests that follow; payments
+// already pending keep settling as they were scripted
+func (s *Server) SetScenario(scenario *Scenario) error {
+	if err := scenario.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.scenario = scenario
+	s.mu.Unlock()
+	return nil
+}
+
+// Close stops pending settlements and callbacks and waits for them
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// authenticate requires an API key or bearer token on every request and,
+// when verifier is set, an MTN Pay signature
+func (s *Server) authenticate(next http.Handler, verifier *external.MTNPayVerifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") == "" && !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			writeError(w, http.StatusUnauthorized, "missing API key or bearer token")
+			return
+		}
+
+		if verifier != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "unreadable body")
+				return
+			}
+			// RequestURI keeps the prefix StripPrefix removed from the
+			// path, as the client signed it
+			if err := verifier.Verify(r.Method, r.RequestURI, r.Header.Get, body); err != nil {
+				writeError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// endpoint applies the scenario's rule for the operation before h
+func (s *Server) endpoint(provider, operation string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		rule := s.scenario.rule(provider, operation)
+		delay := time.Duration(rule.Latency)
+		if rule.Jitter > 0 {
+			delay += time.Duration(s.rand.Int63n(int64(rule.Jitter)))
+		}
+		fail := rule.ErrorRate > 0 && s.rand.Float64() < rule.ErrorRate
+		s.mu.Unlock()
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if fail {
+			status := rule.ErrorStatus
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			if rule.RetryAfter > 0 && (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) {
+				seconds := math.Ceil(time.Duration(rule.RetryAfter).Seconds())
+				w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+			}
+			writeError(w, status, fmt.Sprintf("scripted %s.%s failure", provider, operation))
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request) {
+	clientID, _, ok := r.BasicAuth()
+	if !ok || clientID == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_client",
+			"error_description": "client credentials must be sent with basic auth",
+		})
+		return
+	}
+	if r.PostFormValue("grant_type") != "client_credentials" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "unsupported_grant_type",
+			"error_description": "only client_credentials is supported",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "mock-" + s.newID(16),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        r.PostFormValue("scope"),
+	})
+}
+
+func (s *Server) getScenario(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Scenario())
+}
+
+func (s *Server) putScenario(w http.ResponseWriter, r *http.Request) {
+	var scenario *Scenario
+	var err error
+	if name := r.URL.Query().Get("name"); name != "" {
+		scenario, err = BuiltinScenario(name)
+	} else {
+		var body []byte
+		if body, err = io.ReadAll(r.Body); err == nil {
+			scenario, err = ParseScenario(body)
+		}
+	}
+	if err == nil {
+		err = s.SetScenario(scenario)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.logger.Printf("scenario %q in use", scenario.Name)
+	writeJSON(w, http.StatusOK, scenario)
+}
+
+// newID returns n random hex digits
+func (s *Server) newID(n int) string {
+	const digits = "0123456789abcdef"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := make([]byte, n)
+	for i := range id {
+		id[i] = digits[s.rand.Intn(len(digits))]
+	}
+	return string(id)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers in the {"error", "message"} shape the clients decode
+func writeError(w http.ResponseWriter, status int, message string) {
+	code := strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	writeJSON(w, status, map[string]string{"error": code, "message": message})
+}
+
+// sleep waits for d and reports false if the server closed first
+func (s *Server) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
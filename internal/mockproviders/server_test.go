@@ -0,0 +1,190 @@
+package mockproviders
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/config"
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+const testSecret = "mock-providers-test-secret"
+
+// TestMockProviders drives every client call against an in-process mock,
+// with signed MTN Pay requests and OAuth2 for MADAPI, then checks async
+// settlement posts a signed callback and scripted errors reach the client
+func TestMockProviders(t *testing.T) {
+	callbacks := make(chan external.MTNPayCallback, 4)
+	verifier := external.NewMTNPayVerifier(testSecret, time.Minute)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := verifier.Verify(r.Method, r.URL.RequestURI(), r.Header.Get, body); err != nil {
+			t.Errorf("callback: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var callback external.MTNPayCallback
+		if err := json.Unmarshal(body, &callback); err != nil {
+			t.Errorf("callback body: %v", err)
+		}
+		callbacks <- callback
+	}))
+	defer webhook.Close()
+
+	mock := New(Options{
+		Scenario:    &Scenario{Name: "check"},
+		CallbackURL: webhook.URL + "/v1/webhooks/mtnpay",
+		Secret:      testSecret,
+		Seed:        1,
+	})
+	defer mock.Close()
+	srv := httptest.NewServer(mock.Handler())
+	defer srv.Close()
+
+	resilience := config.ResilienceConfig{
+		Timeout:          5 * time.Second,
+		FailureThreshold: 100,
+		OpenTimeout:      time.Second,
+		HalfOpenProbes:   1,
+	}
+	mtnPay := external.NewMTNPayClient(&config.MTNPayConfig{
+		BaseURL:    srv.URL + MTNPayPrefix,
+		APIKey:     "check",
+		Secret:     testSecret,
+		Resilience: resilience,
+	}, nil, nil, nil)
+	madapi := external.NewMADAPIClient(&config.MADAPIConfig{
+		BaseURL: srv.URL + MADAPIPrefix,
+		OAuth2: config.OAuth2Config{
+			TokenURL:     srv.URL + TokenPath,
+			ClientID:     "check",
+			ClientSecret: "check",
+			Timeout:      5 * time.Second,
+		},
+		Resilience: resilience,
+	}, nil, nil, nil, nil)
+	soa := external.NewSOAClient(&config.SOAConfig{
+		BaseURL:            srv.URL + SOAPrefix,
+		APIKey:             "check",
+		InventoryBatchSize: 50,
+		Resilience:         resilience,
+	}, nil, nil, nil)
+
+	ctx := context.Background()
+
+	payment, err := mtnPay.ProcessPayment(ctx, external.MTNPayRequest{
+		Amount: 10, Currency: "EUR", PhoneNumber: "233241234567", Reference: "check-1",
+	})
+	if err != nil {
+		t.Errorf("mtnpay process_payment: %v", err)
+	} else if payment.Status != statusSuccessful {
+		t.Errorf("mtnpay process_payment: status %q, want %q", payment.Status, statusSuccessful)
+	}
+	if payment != nil {
+		if status, err := mtnPay.GetPaymentStatus(ctx, payment.TransactionID); err != nil {
+			t.Errorf("mtnpay get_payment_status: %v", err)
+		} else if status.CompletedAt == nil {
+			t.Errorf("mtnpay get_payment_status: no completed_at on a settled payment")
+		}
+	}
+	if _, err := mtnPay.GetBalance(ctx, "233241234567"); err != nil {
+		t.Errorf("mtnpay get_balance: %v", err)
+	}
+
+	if _, err := madapi.ValidateUser(ctx, external.UserValidationRequest{UserID: "u-1", Email: "u@example.com", Phone: "233241234567"}); err != nil {
+		t.Errorf("madapi validate_user: %v", err)
+	}
+	if pricing, err := madapi.GetPricing(ctx, external.PricingRequest{ProductID: "prod-001", Quantity: 10}); err != nil {
+		t.Errorf("madapi get_pricing: %v", err)
+	} else if pricing.FinalPrice >= pricing.BasePrice {
+		t.Errorf("madapi get_pricing: no volume discount on 10 units")
+	}
+	if prices, err := madapi.GetPricingBatch(ctx, []external.PricingRequest{
+		{ProductID: "prod-001", Quantity: 1}, {ProductID: "prod-002", Quantity: 1},
+	}); err != nil {
+		t.Errorf("madapi get_pricing batch: %v", err)
+	} else if prices[0].ProductID != "prod-001" || prices[1].ProductID != "prod-002" {
+		t.Errorf("madapi get_pricing batch: prices out of order")
+	}
+	if _, err := madapi.ValidateReward(ctx, external.RewardValidationRequest{UserID: "u-1", RewardType: "points", Points: 500}); err != nil {
+		t.Errorf("madapi validate_reward: %v", err)
+	}
+	if _, err := madapi.GetUserProfile(ctx, "u-1"); err != nil {
+		t.Errorf("madapi get_user_profile: %v", err)
+	}
+
+	if _, err := soa.CheckInventory(ctx, external.InventoryRequest{ProductID: "prod-001", Quantity: 1}); err != nil {
+		t.Errorf("soa check_inventory: %v", err)
+	}
+	if stock, err := soa.CheckInventoryBatch(ctx, []external.InventoryRequest{
+		{ProductID: "prod-001", Quantity: 1}, {ProductID: "prod-002", Quantity: 1},
+	}); err != nil {
+		t.Errorf("soa check_inventory_batch: %v", err)
+	} else if stock[0].ProductID != "prod-001" || stock[1].ProductID != "prod-002" {
+		t.Errorf("soa check_inventory_batch: stock out of order")
+	}
+	shipping, err := soa.CreateShipping(ctx, external.ShippingRequest{
+		OrderID: "order-1",
+		Items:   []external.ShippingItem{{ProductID: "prod-001", Quantity: 1, Weight: 1}},
+		Address: external.Address{City: "Accra", Country: "GH"},
+	})
+	if err != nil {
+		t.Errorf("soa create_shipping: %v", err)
+	} else if status, err := soa.GetShippingStatus(ctx, shipping.ShippingID); err != nil {
+		t.Errorf("soa get_shipping_status: %v", err)
+	} else if status.Status == "" || len(status.Events) == 0 {
+		t.Errorf("soa get_shipping_status: no status or events")
+	}
+	if catalog, err := soa.GetProductCatalog(ctx, external.ProductCatalogRequest{Category: "books", Limit: 5}); err != nil {
+		t.Errorf("soa get_product_catalog: %v", err)
+	} else if len(catalog.Products) != 5 || !catalog.HasMore {
+		t.Errorf("soa get_product_catalog: %d products, has_more %t", len(catalog.Products), catalog.HasMore)
+	}
+
+	testAsyncSettlement(t, ctx, mock, mtnPay, callbacks)
+
+	mock.SetScenario(&Scenario{Name: "outage", Rules: map[string]Rule{"soa": {ErrorRate: 1}}})
+	if _, err := soa.CheckInventory(ctx, external.InventoryRequest{ProductID: "prod-001", Quantity: 1}); err == nil {
+		t.Errorf("soa check_inventory: scripted outage did not fail the call")
+	}
+	if _, err := madapi.GetUserProfile(ctx, "u-1"); err != nil {
+		t.Errorf("madapi get_user_profile: outage scripted for soa only: %v", err)
+	}
+}
+
+// testAsyncSettlement pays under a scenario that settles asynchronously
+// and waits for the callback
+func testAsyncSettlement(t *testing.T, ctx context.Context, mock *Server, mtnPay *external.MTNPayClient, callbacks <-chan external.MTNPayCallback) {
+	t.Helper()
+	mock.SetScenario(&Scenario{
+		Name:       "test-async",
+		Settlement: Settlement{Async: true, Delay: Duration(100 * time.Millisecond)},
+	})
+
+	payment, err := mtnPay.ProcessPayment(ctx, external.MTNPayRequest{
+		Amount: 10, Currency: "EUR", PhoneNumber: "233241234567", Reference: "test-async",
+	})
+	if err != nil {
+		t.Errorf("mtnpay async process_payment: %v", err)
+		return
+	}
+	if payment.Status != statusPending {
+		t.Errorf("mtnpay async process_payment: status %q, want %q", payment.Status, statusPending)
+		return
+	}
+
+	select {
+	case callback := <-callbacks:
+		if callback.TransactionID != payment.TransactionID || callback.Status != statusSuccessful {
+			t.Errorf("callback for %s %s, want %s %s",
+				callback.TransactionID, callback.Status, payment.TransactionID, statusSuccessful)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no callback for async payment %s", payment.TransactionID)
+	}
+}
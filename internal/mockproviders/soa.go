@@ -0,0 +1,304 @@
+package mockproviders
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/webbies/otel-fiber-demo/internal/infrastructure/external"
+)
+
+// catalogSize is the number of products in the mock catalog
+const catalogSize = 120
+
+// inventoryBatchLimit bounds the items of one batch inventory check
+const inventoryBatchLimit = 100
+
+var categories = []string{"electronics", "books", "home", "fashion", "sports"}
+
+// shipmentTimeline compresses a delivery into minutes: each status applies
+// from its offset after the shipment was created
+var shipmentTimeline = []struct {
+	status      string
+	description string
+	after       time.Duration
+}{
+	{"LABEL_CREATED", "Shipping label created", 0},
+	{"PICKED_UP", "Parcel picked up by the carrier", 15 * time.Second},
+	{"IN_TRANSIT", "Parcel in transit", 30 * time.Second},
+	{"OUT_FOR_DELIVERY", "Out for delivery", time.Minute},
+	{"DELIVERED", "Delivered", 2 * time.Minute},
+}
+
+type shipment struct {
+	response external.ShippingResponse
+	city     string
+}
+
+func (s *Server) soaRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /inventory/check", s.endpoint("soa", "check_inventory", s.checkInventory))
+	mux.HandleFunc("POST /inventory/check/batch", s.endpoint("soa", "check_inventory_batch", s.checkInventoryBatch))
+	mux.HandleFunc("POST /shipping", s.endpoint("soa", "create_shipping", s.createShipping))
+	mux.HandleFunc("POST /catalog/products", s.endpoint("soa", "get_product_catalog", s.getProductCatalog))
+	mux.HandleFunc("GET /shipping/{shippingID}/status", s.endpoint("soa", "get_shipping_status", s.getShippingStatus))
+	return mux
+}
+
+// checkInventory reports a stable stock level per product; one product in
+// ten is out of stock until its next restock
+func (s *Server) checkInventory(w http.ResponseWriter, r *http.Request) {
+	var req external.InventoryRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if msg := validateInventory(req); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	writeJSON(w, http.StatusOK, inventory(req))
+}
+
+// checkInventoryBatch answers up to inventoryBatchLimit checks in the order
+// they were asked; one invalid item rejects the batch
+func (s *Server) checkInventoryBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Items []external.InventoryRequest `json:"items"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	switch {
+	case len(req.Items) == 0:
+		writeError(w, http.StatusBadRequest, "items are required")
+		return
+	case len(req.Items) > inventoryBatchLimit:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d items per batch", inventoryBatchLimit))
+		return
+	}
+
+	items := make([]external.InventoryResponse, len(req.Items))
+	for i, item := range req.Items {
+		if msg := validateInventory(item); msg != "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("items[%d]: %s", i, msg))
+			return
+		}
+		items[i] = inventory(item)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+func validateInventory(req external.InventoryRequest) string {
+	switch {
+	case req.ProductID == "":
+		return "product_id is required"
+	case req.Quantity <= 0:
+		return "quantity must be positive"
+	}
+	return ""
+}
+
+func inventory(req external.InventoryRequest) external.InventoryResponse {
+	location := req.Location
+	if location == "" {
+		location = "main-warehouse"
+	}
+
+	h := hash(req.ProductID)
+	resp := external.InventoryResponse{
+		ProductID: req.ProductID,
+		Location:  location,
+	}
+	if h%10 != 0 {
+		resp.StockLevel = 5 + int(h%200)
+		resp.ReservedStock = int(h % 5)
+	} else {
+		restock := time.Now().UTC().Add(time.Duration(1+h%7) * 24 * time.Hour).Truncate(time.Hour)
+		resp.NextRestock = &restock
+	}
+	resp.Available = resp.StockLevel-resp.ReservedStock >= req.Quantity
+	return resp
+}
+
+func (s *Server) createShipping(w http.ResponseWriter, r *http.Request) {
+	var req external.ShippingRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	switch {
+	case req.OrderID == "":
+		writeError(w, http.StatusBadRequest, "order_id is required")
+		return
+	case len(req.Items) == 0:
+		writeError(w, http.StatusBadRequest, "items are required")
+		return
+	case req.Address.Country == "":
+		writeError(w, http.StatusBadRequest, "address.country is required")
+		return
+	}
+
+	weight := req.Weight
+	if weight == 0 {
+		for _, item := range req.Items {
+			weight += item.Weight * float64(item.Quantity)
+		}
+	}
+
+	resp := external.ShippingResponse{
+		OrderID:        req.OrderID,
+		ShippingID:     "SHP-" + strings.ToUpper(s.newID(12)),
+		TrackingNumber: "1Z" + strings.ToUpper(s.newID(16)),
+		Carrier:        "DHL",
+		Service:        "standard",
+		Cost:           roundCents(4.99 + 1.5*weight),
+		Currency:       "EUR",
+		EstimatedDays:  3,
+		CreatedAt:      time.Now().UTC(),
+	}
+	if strings.EqualFold(req.Address.Country, "DE") {
+		resp.EstimatedDays = 1
+	}
+
+	s.mu.Lock()
+	s.shipments[resp.ShippingID] = &shipment{response: resp, city: req.Address.City}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// getShippingStatus follows shipmentTimeline from the shipment's creation
+func (s *Server) getShippingStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	sh, ok := s.shipments[r.PathValue("shippingID")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no shipment "+r.PathValue("shippingID"))
+		return
+	}
+
+	created := sh.response.CreatedAt
+	elapsed := time.Since(created)
+	resp := external.ShippingStatusResponse{
+		ShippingID:     sh.response.ShippingID,
+		OrderID:        sh.response.OrderID,
+		TrackingNumber: sh.response.TrackingNumber,
+		Carrier:        sh.response.Carrier,
+	}
+	for _, step := range shipmentTimeline {
+		if elapsed < step.after {
+			break
+		}
+		event := external.TrackingEvent{
+			Status:      step.status,
+			Description: step.description,
+			Location:    sh.city,
+			Timestamp:   created.Add(step.after),
+		}
+		resp.Events = append(resp.Events, event)
+		resp.Status = event.Status
+		resp.LastUpdate = event.Timestamp
+	}
+
+	last := shipmentTimeline[len(shipmentTimeline)-1]
+	if resp.Status == last.status {
+		delivered := created.Add(last.after)
+		resp.DeliveredAt = &delivered
+	} else {
+		estimated := created.Add(last.after)
+		resp.EstimatedDelivery = &estimated
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getProductCatalog filters and pages a fixed catalog
+func (s *Server) getProductCatalog(w http.ResponseWriter, r *http.Request) {
+	var req external.ProductCatalogRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Limit < 0 || req.Offset < 0 {
+		writeError(w, http.StatusBadRequest, "limit and offset must not be negative")
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = 20
+	}
+	limit = min(limit, 100)
+
+	var matched []external.Product
+	for i := 0; i < catalogSize; i++ {
+		p := catalogProduct(i)
+		switch {
+		case req.Category != "" && p.Category != req.Category:
+			continue
+		case req.MinPrice > 0 && p.Price < req.MinPrice:
+			continue
+		case req.MaxPrice > 0 && p.Price > req.MaxPrice:
+			continue
+		case !hasTags(p.Tags, req.Tags):
+			continue
+		}
+		matched = append(matched, p)
+	}
+
+	start := min(req.Offset, len(matched))
+	end := min(start+limit, len(matched))
+	writeJSON(w, http.StatusOK, external.ProductCatalogResponse{
+		Products: append([]external.Product{}, matched[start:end]...),
+		Total:    len(matched),
+		Page:     req.Offset/limit + 1,
+		PerPage:  limit,
+		HasMore:  end < len(matched),
+	})
+}
+
+func catalogProduct(i int) external.Product {
+	id := fmt.Sprintf("prod-%03d", i+1)
+	category := categories[i%len(categories)]
+	h := hash(id)
+	// The same stock as checkInventory reports
+	stock := 0
+	if h%10 != 0 {
+		stock = 5 + int(h%200)
+	}
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i)
+
+	tags := []string{category}
+	if h%3 == 0 {
+		tags = append(tags, "bestseller")
+	}
+	if h%4 == 0 {
+		tags = append(tags, "sale")
+	}
+
+	return external.Product{
+		ID:          id,
+		Name:        fmt.Sprintf("%s item %d", strings.ToUpper(category[:1])+category[1:], i+1),
+		SKU:         fmt.Sprintf("SKU-%s-%04d", strings.ToUpper(category[:3]), i+1),
+		Description: "Mock " + category + " product",
+		Price:       unitPrice(id),
+		Currency:    "EUR",
+		Category:    category,
+		Tags:        tags,
+		Images:      []string{fmt.Sprintf("https://images.example.com/%s.jpg", id)},
+		Attributes:  map[string]string{"weight_kg": fmt.Sprintf("%.1f", math.Max(0.1, float64(h%50)/10))},
+		InStock:     stock > 0,
+		StockLevel:  stock,
+		CreatedAt:   created,
+		UpdatedAt:   created.AddDate(0, 1, 0),
+	}
+}
+
+func hasTags(tags, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}